@@ -1,311 +1,346 @@
-// client/main.go
-package main
+// client.go
+// Headless TCR client: connection, account and lobby requests, and the
+// in-match event stream, with no terminal I/O. Used by cmd/client and
+// cmd/loadgen.
+package client
 
 import (
-	"bufio"
 	"encoding/json"
-	"flag"
+	"errors"
 	"fmt"
-	"math/rand"
 	"net"
-	"os"
-	"os/signal"
-	"strconv"
-	"strings"
-	"syscall"
+	"sync"
+	"sync/atomic"
 	"tcr/server"
 	"time"
 )
 
-const (
-	maxReconnectAttempts = 3
-	reconnectDelay       = 5 * time.Second
-)
-
-type GameClient struct {
-	serverAddr      string
-	conn            net.Conn
-	reader          *bufio.Reader
-	username        string
-	password        string
-	inGame          bool
-	availableTroops []string
+// ErrClosed is reported by Err when the event stream ended without an error
+var ErrClosed = errors.New("client closed")
+
+// Client is one connection to a TCR server. Requests (Register, Login,
+// ListMatches, ...) expect a reply and must not be issued once Events has
+// been started; after that the server pushes PDUs and the client only sends.
+type Client struct {
+	conn      net.Conn
+	sendMutex sync.Mutex
+	events    chan server.PDU
+	listen    sync.Once
+	err       error // why the event stream ended
+
+	deliverMutex sync.Mutex     // guards closed and sending.Add
+	closed       bool           // no more deliveries; set when done is closed
+	done         chan struct{}  // closed when the event stream ends, to unblock deliver
+	sending      sync.WaitGroup // deliveries in flight, waited for before events is closed
+
+	wantUDP bool                    // ask for UDP at login
+	udp     atomic.Pointer[udpLink] // nil unless snapshots come over UDP
 }
 
-func NewGameClient(serverAddr string) *GameClient {
-	return &GameClient{
-		serverAddr: serverAddr,
-		reader:     bufio.NewReader(os.Stdin),
+// Dial connects to a server
+func Dial(addr string) (*Client, error) {
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		return nil, err
 	}
+	return New(conn), nil
 }
 
-func (c *GameClient) connect() error {
+// DialRetry connects to a server, trying up to attempts times
+func DialRetry(addr string, attempts int, delay time.Duration, onRetry func(attempt int, err error)) (*Client, error) {
 	var err error
-	for i := 0; i < maxReconnectAttempts; i++ {
-		c.conn, err = net.Dial("tcp", c.serverAddr)
-		if err == nil {
-			return nil
+	for i := 0; i < attempts; i++ {
+		var c *Client
+		if c, err = Dial(addr); err == nil {
+			return c, nil
 		}
-		fmt.Printf("Connection attempt %d failed: %v\n", i+1, err)
-		if i < maxReconnectAttempts-1 {
-			fmt.Printf("Retrying in %v...\n", reconnectDelay)
-			time.Sleep(reconnectDelay)
+		if onRetry != nil {
+			onRetry(i+1, err)
+		}
+		if i < attempts-1 {
+			time.Sleep(delay)
 		}
 	}
-	return fmt.Errorf("failed to connect after %d attempts", maxReconnectAttempts)
+	return nil, fmt.Errorf("failed to connect after %d attempts: %w", attempts, err)
 }
 
-// Register new user
-func (c *GameClient) register() error {
-	fmt.Print("Choose a username: ")
-	c.username = strings.TrimSpace(readLine(c.reader))
-	fmt.Print("Choose a password: ")
-	c.password = strings.TrimSpace(readLine(c.reader))
-
-	cred := fmt.Sprintf(`{"username":"%s","password":"%s"}`, c.username, c.password)
-	if err := server.SendPDU(c.conn, server.PDU{
-		Type: "register",
-		Data: json.RawMessage(cred),
-	}); err != nil {
-		return fmt.Errorf("register send error: %v", err)
-	}
+// New wraps an established connection
+func New(conn net.Conn) *Client {
+	return &Client{conn: conn, events: make(chan server.PDU, 64), done: make(chan struct{})}
+}
 
-	pdu, err := server.ReceivePDU(c.conn)
-	if err != nil {
-		return fmt.Errorf("register response error: %v", err)
-	}
+// Conn returns the underlying connection
+func (c *Client) Conn() net.Conn {
+	return c.conn
+}
 
-	var resp struct{ Status string }
-	if err := json.Unmarshal(pdu.Data, &resp); err != nil {
-		return fmt.Errorf("register parse error: %v", err)
+// Close closes the connection, ending the event stream
+func (c *Client) Close() error {
+	if l := c.udp.Load(); l != nil {
+		c.stopUDP(l)
 	}
+	c.stopDelivery()
+	return c.conn.Close()
+}
 
-	if resp.Status != "OK" {
-		return fmt.Errorf("register failed: %s", resp.Status)
+// Send writes one PDU; it is safe for concurrent use
+func (c *Client) Send(pduType string, payload interface{}) error {
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return fmt.Errorf("%s marshal error: %w", pduType, err)
+	}
+	c.sendMutex.Lock()
+	defer c.sendMutex.Unlock()
+	if err := server.SendPDU(c.conn, server.PDU{Type: pduType, Data: data}); err != nil {
+		return fmt.Errorf("%s send error: %w", pduType, err)
 	}
-
 	return nil
 }
 
-// Login
-func (c *GameClient) login() error {
-	fmt.Print("Username: ")
-	c.username = strings.TrimSpace(readLine(c.reader))
-	fmt.Print("Password: ")
-	c.password = strings.TrimSpace(readLine(c.reader))
-
-	cred := fmt.Sprintf(`{"username":"%s","password":"%s"}`, c.username, c.password)
-	if err := server.SendPDU(c.conn, server.PDU{
-		Type: "login",
-		Data: json.RawMessage(cred)}); err != nil {
-		return fmt.Errorf("login send error: %v", err)
+// Request sends a PDU and decodes the next PDU from the server into resp
+func (c *Client) Request(pduType string, payload, resp interface{}) error {
+	if err := c.Send(pduType, payload); err != nil {
+		return err
 	}
-
 	pdu, err := server.ReceivePDU(c.conn)
 	if err != nil {
-		return fmt.Errorf("login response error: %v", err)
+		return fmt.Errorf("%s response error: %w", pduType, err)
 	}
+	if pdu.Type == "error" {
+		return fmt.Errorf("%s rejected: %s", pduType, pdu.Data)
+	}
+	if err := json.Unmarshal(pdu.Data, resp); err != nil {
+		return fmt.Errorf("%s parse error: %w", pduType, err)
+	}
+	return nil
+}
 
+// status requests and checks a {"status":"OK"} style reply
+func (c *Client) status(pduType string, payload interface{}) error {
 	var resp struct{ Status string }
-	if err := json.Unmarshal(pdu.Data, &resp); err != nil {
-		return fmt.Errorf("login parse error: %v", err)
+	if err := c.Request(pduType, payload, &resp); err != nil {
+		return err
 	}
-
 	if resp.Status != "OK" {
-		return fmt.Errorf("login failed: %s", resp.Status)
+		return fmt.Errorf("%s failed: %s", pduType, resp.Status)
 	}
-
 	return nil
 }
 
-func (c *GameClient) handleGameStart(pdu server.PDU) {
-	var startData struct {
-		Mode    string `json:"mode"`
-		Players []int  `json:"players"`
+// Hello negotiates the PDU codec, offering codecs in order of preference,
+// and switches the connection to the server's choice. It must be the first
+// request; without it the connection stays on JSON.
+func (c *Client) Hello(codecs ...string) (string, error) {
+	var resp struct {
+		Status string `json:"status"`
+		Codec  string `json:"codec"`
+	}
+	if err := c.Request("hello", map[string][]string{"codecs": codecs}, &resp); err != nil {
+		return "", err
+	}
+	if resp.Status != "OK" {
+		return "", fmt.Errorf("hello failed: %s", resp.Status)
 	}
-	if err := json.Unmarshal(pdu.Data, &startData); err != nil {
-		fmt.Printf("Error parsing game start: %v\n", err)
-		return
+	codec, err := server.NewCodec(resp.Codec)
+	if err != nil {
+		return "", err
 	}
+	c.sendMutex.Lock()
+	c.conn = server.WithCodec(c.conn, codec)
+	c.sendMutex.Unlock()
+	return codec.Name(), nil
+}
 
-	// Randomly select 3 unique troops at game start
-	allTroops := []string{"pawn", "bishop", "rook", "knight", "prince", "queen", "archer", "giant", "minion"}
-	rand.Shuffle(len(allTroops), func(i, j int) { allTroops[i], allTroops[j] = allTroops[j], allTroops[i] })
-	c.availableTroops = allTroops[:3]
-	c.inGame = true
-	fmt.Printf("\n=== Game Started ===\n")
-	fmt.Printf("Mode: %s\n", startData.Mode)
-	fmt.Printf("Players: %v\n", startData.Players)
-	fmt.Println("==================")
+// Credentials identify an account and, for Login and PlayVsBot, the match
+// it wants
+type Credentials struct {
+	Username   string `json:"username"`
+	Password   string `json:"password"`
+	Mode       string `json:"mode,omitempty"`
+	Difficulty string `json:"difficulty,omitempty"`
+	UDP        bool   `json:"udp,omitempty"` // set by UseUDP
 }
 
-func (c *GameClient) handleStateUpdate(pdu server.PDU) {
-	var state server.GameState
-	if err := json.Unmarshal(pdu.Data, &state); err != nil {
-		fmt.Printf("Error parsing state update: %v\n", err)
-		return
-	}
+// Register creates an account
+func (c *Client) Register(username, password string) error {
+	return c.status("register", Credentials{Username: username, Password: password})
+}
 
-	// Clear screen
-	fmt.Print("\033[H\033[2J")
+// Login logs in and joins the matchmaking queue for mode; the card catalog
+// and then the match arrive on Events as card_catalog and game_start
+func (c *Client) Login(username, password, mode string) error {
+	return c.login("login", Credentials{Username: username, Password: password, Mode: mode})
+}
 
-	// Display game state
-	fmt.Println("\n=== Game State ===")
-	fmt.Printf("Player 1 Mana: %d\n", state.YourMana)
-	fmt.Printf("Player 2 Mana: %d\n", state.OpponentMana)
+// PlayVsBot logs in and starts a match against a bot right away
+func (c *Client) PlayVsBot(username, password, mode, difficulty string) error {
+	return c.login("play_vs_bot", Credentials{
+		Username: username, Password: password, Mode: mode, Difficulty: difficulty,
+	})
+}
 
-	fmt.Println("\nPlayer 1 Towers:")
-	for _, tower := range state.Player1Towers {
-		fmt.Printf("- %s: HP %d\n", tower.Name, tower.Health)
+// login sends login or play_vs_bot and starts UDP if the server offered it
+func (c *Client) login(pduType string, creds Credentials) error {
+	creds.UDP = c.wantUDP
+	var resp struct {
+		Status   string `json:"status"`
+		UDPPort  int    `json:"udp_port"`
+		UDPToken string `json:"udp_token"`
 	}
-
-	fmt.Println("\nPlayer 2 Towers:")
-	for _, tower := range state.Player2Towers {
-		fmt.Printf("- %s: HP %d\n", tower.Name, tower.Health)
+	if err := c.Request(pduType, creds, &resp); err != nil {
+		return err
 	}
-
-	troopCosts := map[string]int{
-		"pawn": 3, "bishop": 4, "rook": 5,
-		"knight": 5, "prince": 6, "queen": 5,
-		"archer": 3, "giant": 7, "minion": 3,
+	if resp.Status != "OK" {
+		return fmt.Errorf("%s failed: %s", pduType, resp.Status)
+	}
+	if resp.UDPToken != "" {
+		// Without UDP the state simply keeps coming over TCP
+		c.startUDP(resp.UDPPort, resp.UDPToken)
 	}
+	return nil
+}
 
-	fmt.Println("\nAvailable Troops:")
-	for i, troop := range c.availableTroops {
-		capitalized := strings.Title(troop)
-		fmt.Printf("%d. %s (%d mana)\n", i+1, capitalized, troopCosts[troop])
+// ListMatches lists the server's live matches
+func (c *Client) ListMatches() ([]server.MatchInfo, error) {
+	var resp struct {
+		Matches []server.MatchInfo `json:"matches"`
 	}
+	err := c.Request("list_matches", struct{}{}, &resp)
+	return resp.Matches, err
+}
+
+// Spectate subscribes to a live match; read the spectate_update views with
+// Next until spectate_end
+func (c *Client) Spectate(matchID string, delaySec int) error {
+	return c.status("spectate", map[string]interface{}{"match_id": matchID, "delay_sec": delaySec})
+}
 
-	fmt.Println("\nEnter troop number or 'quit' to exit")
+// ListReplays lists the replays stored on the server
+func (c *Client) ListReplays() ([]server.ReplayInfo, error) {
+	var resp struct {
+		Replays []server.ReplayInfo `json:"replays"`
+	}
+	err := c.Request("replay_list", struct{}{}, &resp)
+	return resp.Replays, err
 }
 
-func (c *GameClient) handleGameEnd(pdu server.PDU) {
-	var endData struct {
-		Result string `json:"result"`
-		Exp    int    `json:"exp"`
+// FetchReplay downloads a replay file
+func (c *Client) FetchReplay(id string) ([]byte, error) {
+	var resp struct {
+		Status string `json:"status"`
+		Replay []byte `json:"replay"`
 	}
-	if err := json.Unmarshal(pdu.Data, &endData); err != nil {
-		fmt.Printf("Error parsing game end: %v\n", err)
-		return
+	if err := c.Request("replay_fetch", map[string]string{"id": id}, &resp); err != nil {
+		return nil, err
 	}
+	if resp.Status != "OK" {
+		return nil, fmt.Errorf("replay_fetch failed: %s", resp.Status)
+	}
+	return resp.Replay, nil
+}
 
-	fmt.Printf("\n=== Game Over ===\n")
-	fmt.Printf("Result: %s\n", endData.Result)
-	fmt.Printf("EXP Gained: %d\n", endData.Exp)
-	fmt.Println("================")
+// Deploy asks the server to deploy a troop in the current match
+func (c *Client) Deploy(troop string) error {
+	return c.Send("deploy", map[string]string{"troop": troop})
+}
 
-	c.inGame = false
+// DeployLane deploys a troop with a lane hint; the server has a single lane
+// and ignores it for now
+func (c *Client) DeployLane(troop, lane string) error {
+	return c.Send("deploy", map[string]string{"troop": troop, "lane": lane})
 }
 
-func (c *GameClient) run() error {
-	if err := c.connect(); err != nil {
-		return err
-	}
-	defer c.conn.Close()
-
-	// Login/Register loop
-	for {
-		fmt.Print("Choose L(Login) or R(Register): ")
-		choice := strings.TrimSpace(readLine(c.reader))
-		switch choice {
-		case "L", "l":
-			if err := c.login(); err != nil {
-				fmt.Printf("Login failed: %v\n", err)
-			} else {
-				fmt.Println("Login successful!")
-				fmt.Println("Finding a match! Please wait a moment")
-				goto StartGameLoop
-			}
-		case "R", "r":
-			if err := c.register(); err != nil {
-				fmt.Printf("Register failed: %v\n", err)
-			} else {
-				fmt.Println("Registration successful. Please login.")
-			}
-		default:
-			fmt.Println("Invalid choice. Please enter 'L or l' or 'R or r'.")
-		}
-	}
+// Chat sends a message to the opponent in the current match
+func (c *Client) Chat(message string) error {
+	return c.Send("chat", server.ChatMessage{Message: message})
+}
 
-StartGameLoop:
-	// Handle graceful shutdown
-	sigChan := make(chan os.Signal, 1)
-	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
-	go func() {
-		<-sigChan
-		fmt.Println("\nShutting down...")
-		if c.conn != nil {
-			c.conn.Close()
-		}
-		os.Exit(0)
-	}()
-
-	// Start goroutine to receive updates
-	go func() {
-		for {
-			pdu, err := server.ReceivePDU(c.conn)
-			if err != nil {
-				fmt.Printf("Receive error: %v\n", err)
-				continue
-			}
+// Surrender gives up the current match; game_end follows with the loss
+func (c *Client) Surrender() error {
+	return c.Send("surrender", struct{}{})
+}
 
-			switch pdu.Type {
-			case "game_start":
-				c.handleGameStart(pdu)
-			case "state_update":
-				c.handleStateUpdate(pdu)
-			case "level_up":
-				c.handleLevelUp(pdu)
-			case "game_end":
-				c.handleGameEnd(pdu)
-				os.Exit(0) // Gracefully exit game
-			}
-		}
-	}()
+// Rematch answers the rematch offered in game_end. When both players accept,
+// game_start follows; otherwise rematch_cancel, and the server hangs up.
+func (c *Client) Rematch(accept bool) error {
+	return c.Send("rematch", map[string]bool{"accept": accept})
+}
 
-	// Input loop
-	for {
-		if c.inGame {
-			fmt.Print("\nEnter troop number (1–3) or 'quit': ")
-			input := strings.TrimSpace(readLine(c.reader))
+// Resync asks the server for a full state_update on the next tick, after a
+// StateTracker reported a missed state_delta
+func (c *Client) Resync() error {
+	return c.Send("resync", struct{}{})
+}
 
-			if input == "quit" {
-				return nil
-			}
+// Next reads the next PDU synchronously. Use it instead of Events for short
+// streams such as spectating, after which requests can be issued again.
+func (c *Client) Next() (server.PDU, error) {
+	return server.ReceivePDU(c.conn)
+}
 
-			if idx, err := strconv.Atoi(input); err == nil && idx >= 1 && idx <= 3 {
-				troop := c.availableTroops[idx-1]
-				deployCmd := fmt.Sprintf(`{"Troop": "%s"}`, troop)
-				if err := server.SendPDU(c.conn, server.PDU{
-					Type: "deploy", Data: json.RawMessage(deployCmd)}); err != nil {
-					fmt.Printf("Error sending deploy command: %v\n", err)
+// Events starts reading server-pushed PDUs (game_start, state_update,
+// state_delta, game_end, spectate_update, ...) and returns them in order,
+// with state_update snapshots from UDP merged in as they arrive. The channel
+// is closed when the connection fails or closes; Err then tells why.
+func (c *Client) Events() <-chan server.PDU {
+	c.listen.Do(func() {
+		go func() {
+			defer func() {
+				c.stopDelivery()
+				c.sending.Wait()
+				close(c.events)
+			}()
+			for {
+				pdu, err := server.ReceivePDU(c.conn)
+				if err != nil {
+					c.err = err
+					return
+				}
+				if l := c.udp.Load(); l != nil {
+					l.observe(pdu)
 				}
-			} else {
-				fmt.Println("Invalid troop number!")
+				c.deliver(pdu)
 			}
-		} else {
-			time.Sleep(500 * time.Millisecond) // Avoid busy-waiting
-		}
-	}
+		}()
+	})
+	return c.events
 }
 
-func (c *GameClient) handleLevelUp(pdu server.PDU) {
-	fmt.Println("Congratulation you have level up!")
-	fmt.Println(pdu.Data)
+// deliver queues a PDU on Events and reports false once the stream has
+// ended. It waits for room without holding deliverMutex, so a reader that
+// stopped draining Events cannot keep the stream from closing.
+func (c *Client) deliver(pdu server.PDU) bool {
+	c.deliverMutex.Lock()
+	if c.closed {
+		c.deliverMutex.Unlock()
+		return false
+	}
+	c.sending.Add(1)
+	c.deliverMutex.Unlock()
+	defer c.sending.Done()
+
+	select {
+	case c.events <- pdu:
+		return true
+	case <-c.done:
+		return false
+	}
 }
 
-func readLine(reader *bufio.Reader) string {
-	line, _ := reader.ReadString('\n')
-	return strings.TrimSpace(line)
+// stopDelivery makes deliver drop PDUs and wakes any waiting for room
+func (c *Client) stopDelivery() {
+	c.deliverMutex.Lock()
+	defer c.deliverMutex.Unlock()
+	if !c.closed {
+		c.closed = true
+		close(c.done)
+	}
 }
 
-func main() {
-	serverAddr := flag.String("server", "localhost:9000", "Server address")
-	flag.Parse()
-
-	client := NewGameClient(*serverAddr)
-	if err := client.run(); err != nil {
-		fmt.Printf("Error: %v\n", err)
-		os.Exit(1)
+// Err reports why the event stream ended; valid once Events is closed
+func (c *Client) Err() error {
+	if c.err == nil {
+		return ErrClosed
 	}
+	return c.err
 }
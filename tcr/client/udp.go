@@ -0,0 +1,168 @@
+// udp.go
+// Client side of the UDP state channel: keepalives carrying the login token,
+// and state_update snapshots merged into the event stream, keeping only the
+// newest. If snapshots stop during a match the client tells the server with
+// udp_fallback and state continues over TCP.
+
+package client
+
+import (
+	"encoding/json"
+	"errors"
+	"net"
+	"strconv"
+	"sync"
+	"tcr/server"
+	"time"
+)
+
+// udpLink is an active UDP channel
+type udpLink struct {
+	conn    *net.UDPConn
+	token   []byte // keepalive datagram
+	stop    chan struct{}
+	stopped sync.Once
+
+	mutex      sync.Mutex
+	lastSeq    int       // newest snapshot delivered this match
+	lastPacket time.Time // newest datagram of any kind
+	matchStart time.Time // zero outside matches
+}
+
+// UseUDP asks for the UDP state channel at the next Login or PlayVsBot. The
+// server may not offer one; UDPActive tells.
+func (c *Client) UseUDP() {
+	c.wantUDP = true
+}
+
+// UDPActive reports whether state snapshots are arriving over UDP
+func (c *Client) UDPActive() bool {
+	return c.udp.Load() != nil
+}
+
+// startUDP binds the channel with the token from login_resp
+func (c *Client) startUDP(port int, token string) error {
+	host, _, err := net.SplitHostPort(c.conn.RemoteAddr().String())
+	if err != nil {
+		return err
+	}
+	addr, err := net.ResolveUDPAddr("udp", net.JoinHostPort(host, strconv.Itoa(port)))
+	if err != nil {
+		return err
+	}
+	conn, err := net.DialUDP("udp", nil, addr)
+	if err != nil {
+		return err
+	}
+	keepalive, _ := json.Marshal(server.UDPKeepaliveMsg{Token: token})
+	link := &udpLink{conn: conn, token: keepalive, stop: make(chan struct{}), lastPacket: time.Now()}
+	c.udp.Store(link)
+	conn.Write(link.token)
+	go link.keepalive()
+	go c.readUDP(link)
+	return nil
+}
+
+// keepalive sends the token until the link stops
+func (l *udpLink) keepalive() {
+	ticker := time.NewTicker(server.UDPKeepalive)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-l.stop:
+			return
+		case <-ticker.C:
+			l.conn.Write(l.token)
+		}
+	}
+}
+
+// close stops the keepalives and the reader
+func (l *udpLink) close() {
+	l.stopped.Do(func() {
+		close(l.stop)
+		l.conn.Close()
+	})
+}
+
+// readUDP delivers snapshots newer than the last one and falls back to TCP
+// when none arrive for server.UDPTimeout during a match
+func (c *Client) readUDP(l *udpLink) {
+	buf := make([]byte, 64*1024)
+	for {
+		l.conn.SetReadDeadline(time.Now().Add(server.UDPKeepalive))
+		n, err := l.conn.Read(buf)
+		if err != nil {
+			var netErr net.Error
+			if !errors.As(err, &netErr) || !netErr.Timeout() {
+				c.stopUDP(l)
+				return
+			}
+			if l.stale() {
+				c.stopUDP(l)
+				c.Send("udp_fallback", struct{}{})
+				return
+			}
+			continue
+		}
+
+		var pdu server.PDU
+		var snap struct {
+			Seq int `json:"seq"`
+		}
+		if json.Unmarshal(buf[:n], &pdu) != nil || pdu.Type != "state_update" ||
+			json.Unmarshal(pdu.Data, &snap) != nil {
+			continue
+		}
+		if l.fresh(snap.Seq) && !c.deliver(pdu) {
+			c.stopUDP(l)
+			return
+		}
+	}
+}
+
+// fresh records a snapshot's arrival and reports whether it belongs to the
+// running match and is newer than every snapshot delivered so far
+func (l *udpLink) fresh(seq int) bool {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	l.lastPacket = time.Now()
+	if l.matchStart.IsZero() || seq <= l.lastSeq {
+		return false
+	}
+	l.lastSeq = seq
+	return true
+}
+
+// stale reports whether a match has gone UDPTimeout without snapshots
+func (l *udpLink) stale() bool {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	if l.matchStart.IsZero() {
+		return false
+	}
+	since := l.lastPacket
+	if l.matchStart.After(since) {
+		since = l.matchStart
+	}
+	return time.Since(since) > server.UDPTimeout
+}
+
+// observe follows matches on the TCP stream so the link knows when
+// snapshots are due; sequence numbers start over with every match
+func (l *udpLink) observe(pdu server.PDU) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	switch pdu.Type {
+	case "game_start":
+		l.matchStart, l.lastSeq = time.Now(), 0
+	case "game_end":
+		l.matchStart = time.Time{}
+	}
+}
+
+// stopUDP shuts the link down; state then only comes over TCP
+func (c *Client) stopUDP(l *udpLink) {
+	c.udp.CompareAndSwap(l, nil)
+	l.close()
+}
@@ -0,0 +1,403 @@
+// cmd/client/commands.go
+// In-game command language: deploy, cast, stats, hand, chat, surrender,
+// rematch and help, plus tab completion of command names, cards and lanes
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"tcr/server"
+)
+
+// lanes a deploy may name. The arena has a single lane, so the server
+// ignores them for now.
+var lanes = []string{"left", "right"}
+
+// errQuit ends the input loop
+var errQuit = errors.New("quit")
+
+// gameCommand is one command of the in-game prompt
+type gameCommand struct {
+	name  string
+	usage string // arguments after the name
+	help  string
+	run   func(c *GameClient, args []string) error
+	// complete lists candidates for argument n, nil when it takes free text
+	complete func(c *GameClient, n int) []string
+}
+
+// gameCommands is set in init since help refers to it
+var gameCommands []gameCommand
+
+func init() {
+	gameCommands = []gameCommand{
+		{name: "deploy", usage: "<card> [lane]", help: "deploy a card by number, id or name; lane (" + strings.Join(lanes, ", ") +
+			") is accepted but ignored while the arena has one lane",
+			run: (*GameClient).cmdDeploy, complete: completeDeploy},
+		{name: "cast", usage: "<card>", help: "play a card with an ability, like the Queen's heal",
+			run: (*GameClient).cmdCast, complete: completeCast},
+		{name: "hand", help: "list the cards in your hand", run: (*GameClient).cmdHand},
+		{name: "stats", help: "show this match's stats", run: (*GameClient).cmdStats},
+		{name: "chat", usage: "<message>", help: "send a message to your opponent", run: (*GameClient).cmdChat},
+		{name: "surrender", help: "give up the match as a loss", run: (*GameClient).cmdSurrender},
+		{name: "rematch", help: "accept the rematch offered when a match ends", run: (*GameClient).cmdRematch},
+		{name: "help", usage: "[command]", help: "list commands, or describe one",
+			run: (*GameClient).cmdHelp, complete: completeCommand},
+		{name: "quit", help: "exit the client", run: func(*GameClient, []string) error { return errQuit }},
+	}
+}
+
+// lookupCommand finds a command by name
+func lookupCommand(name string) *gameCommand {
+	for i := range gameCommands {
+		if gameCommands[i].name == strings.ToLower(name) {
+			return &gameCommands[i]
+		}
+	}
+	return nil
+}
+
+// command runs one line of in-game input and reports whether to quit
+func (c *GameClient) command(input string) bool {
+	err := c.runCommand(input)
+	if errors.Is(err, errQuit) {
+		return true
+	}
+	if err != nil {
+		c.notify("%v", err)
+	}
+	return false
+}
+
+// runCommand parses and runs one line. A bare number deploys that card of
+// the hand.
+func (c *GameClient) runCommand(input string) error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	fields := strings.Fields(input)
+	if len(fields) == 0 {
+		return nil
+	}
+	if _, err := strconv.Atoi(fields[0]); err == nil && len(fields) == 1 {
+		fields = []string{"deploy", fields[0]}
+	}
+	cmd := lookupCommand(fields[0])
+	if cmd == nil {
+		return fmt.Errorf("unknown command %q, type help for a list", fields[0])
+	}
+	return cmd.run(c, fields[1:])
+}
+
+// findCard resolves a hand number, card id or card name to a card in hand
+func (c *GameClient) findCard(arg string) (server.Card, error) {
+	if !c.inGame {
+		return server.Card{}, errors.New("no match yet")
+	}
+	if idx, err := strconv.Atoi(arg); err == nil {
+		if idx < 1 || idx > len(c.hand) {
+			return server.Card{}, fmt.Errorf("no card %d, your hand has %d", idx, len(c.hand))
+		}
+		return c.hand[idx-1], nil
+	}
+	for _, card := range c.hand {
+		if strings.EqualFold(card.ID, arg) || strings.EqualFold(card.Name, arg) {
+			return card, nil
+		}
+	}
+	if c.catalog != nil {
+		for _, card := range c.catalog.Cards {
+			if strings.EqualFold(card.ID, arg) || strings.EqualFold(card.Name, arg) {
+				return server.Card{}, fmt.Errorf("%s is not in your hand", card.Name)
+			}
+		}
+	}
+	return server.Card{}, fmt.Errorf("unknown card %q", arg)
+}
+
+// play sends a deploy for card once it is affordable
+func (c *GameClient) play(card server.Card, lane string) error {
+	if card.Cost > c.mana {
+		return fmt.Errorf("not enough mana for %s: costs %d, you have %d", card.Name, card.Cost, c.mana)
+	}
+	var err error
+	if lane == "" {
+		err = c.api.Deploy(card.ID)
+	} else {
+		err = c.api.DeployLane(card.ID, lane)
+	}
+	if err != nil {
+		return fmt.Errorf("error sending deploy command: %v", err)
+	}
+	if c.ui != nil {
+		c.ui.notify("Deploying %s", card.Name)
+	}
+	return nil
+}
+
+func (c *GameClient) cmdDeploy(args []string) error {
+	if len(args) < 1 || len(args) > 2 {
+		return errors.New("usage: deploy <card> [lane]")
+	}
+	card, err := c.findCard(args[0])
+	if err != nil {
+		return err
+	}
+	lane := ""
+	if len(args) == 2 {
+		lane = strings.ToLower(args[1])
+		if !contains(lanes, lane) {
+			return fmt.Errorf("unknown lane %q, use %s", args[1], strings.Join(lanes, " or "))
+		}
+	}
+	return c.play(card, lane)
+}
+
+func (c *GameClient) cmdCast(args []string) error {
+	if len(args) != 1 {
+		return errors.New("usage: cast <card>")
+	}
+	card, err := c.findCard(args[0])
+	if err != nil {
+		return err
+	}
+	if card.Ability == nil {
+		return fmt.Errorf("%s has no ability to cast, deploy it instead", card.Name)
+	}
+	return c.play(card, "")
+}
+
+func (c *GameClient) cmdHand(args []string) error {
+	if !c.inGame {
+		return errors.New("no match yet")
+	}
+	lines := make([]string, 0, len(c.hand))
+	for i, card := range c.hand {
+		lines = append(lines, fmt.Sprintf("[%d] %s: %s", i+1, card.ID, describeCard(card)))
+	}
+	c.show("Hand", lines)
+	return nil
+}
+
+func (c *GameClient) cmdStats(args []string) error {
+	c.show("Match stats", c.stats.lines())
+	return nil
+}
+
+func (c *GameClient) cmdChat(args []string) error {
+	if len(args) == 0 {
+		return errors.New("usage: chat <message>")
+	}
+	if !c.inGame {
+		return errors.New("no match yet")
+	}
+	if err := c.api.Chat(strings.Join(args, " ")); err != nil {
+		return fmt.Errorf("error sending chat: %v", err)
+	}
+	return nil
+}
+
+func (c *GameClient) cmdSurrender(args []string) error {
+	if !c.inGame {
+		return errors.New("no match yet")
+	}
+	if err := c.api.Surrender(); err != nil {
+		return fmt.Errorf("error sending surrender: %v", err)
+	}
+	c.notify("You surrendered")
+	return nil
+}
+
+func (c *GameClient) cmdRematch(args []string) error {
+	if !c.rematch {
+		return errors.New("no rematch on offer")
+	}
+	if err := c.api.Rematch(true); err != nil {
+		return fmt.Errorf("error sending rematch: %v", err)
+	}
+	c.notify("Waiting for your opponent to accept the rematch...")
+	return nil
+}
+
+func (c *GameClient) cmdHelp(args []string) error {
+	if len(args) > 0 {
+		cmd := lookupCommand(args[0])
+		if cmd == nil {
+			return fmt.Errorf("unknown command %q", args[0])
+		}
+		c.show("Help", []string{strings.TrimSpace(cmd.name+" "+cmd.usage) + ": " + cmd.help})
+		return nil
+	}
+	lines := []string{fmt.Sprintf("%-22s deploy that card of your hand", "1-"+strconv.Itoa(handSize))}
+	for _, cmd := range gameCommands {
+		lines = append(lines, fmt.Sprintf("%-22s %s", strings.TrimSpace(cmd.name+" "+cmd.usage), cmd.help))
+	}
+	c.show("Commands", lines)
+	return nil
+}
+
+// show prints a titled block of lines, in the full-screen view in place of
+// the combat log until the next key
+func (c *GameClient) show(title string, lines []string) {
+	if c.ui != nil {
+		c.ui.showInfo(title, lines)
+		return
+	}
+	fmt.Printf("\n--- %s ---\n", title)
+	for _, line := range lines {
+		fmt.Println(line)
+	}
+}
+
+// complete extends the end of input as far as the candidates agree and
+// returns the candidates when more than one remains
+func (c *GameClient) complete(input string) (string, []string) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	words := strings.Fields(input)
+	if len(words) == 0 || strings.HasSuffix(input, " ") {
+		words = append(words, "")
+	}
+	n := len(words) - 1
+	partial := strings.ToLower(words[n])
+
+	var options []string
+	if n == 0 {
+		options = completeCommand(c, 0)
+	} else if cmd := lookupCommand(words[0]); cmd != nil && cmd.complete != nil {
+		options = cmd.complete(c, n-1)
+	}
+	var matches []string
+	for _, o := range options {
+		if strings.HasPrefix(strings.ToLower(o), partial) {
+			matches = append(matches, o)
+		}
+	}
+	if len(matches) == 0 {
+		return input, nil
+	}
+
+	prefix := input[:len(input)-len(words[n])]
+	if len(matches) == 1 {
+		return prefix + matches[0] + " ", nil
+	}
+	common := matches[0]
+	for _, m := range matches[1:] {
+		for !strings.HasPrefix(strings.ToLower(m), strings.ToLower(common)) {
+			common = common[:len(common)-1]
+		}
+	}
+	return prefix + common, matches
+}
+
+func completeCommand(c *GameClient, n int) []string {
+	if n > 0 {
+		return nil
+	}
+	names := make([]string, 0, len(gameCommands))
+	for _, cmd := range gameCommands {
+		names = append(names, cmd.name)
+	}
+	return names
+}
+
+func completeDeploy(c *GameClient, n int) []string {
+	switch n {
+	case 0:
+		return c.handIDs(false)
+	case 1:
+		return lanes
+	}
+	return nil
+}
+
+func completeCast(c *GameClient, n int) []string {
+	if n > 0 {
+		return nil
+	}
+	return c.handIDs(true)
+}
+
+// handIDs lists the ids of the cards in hand, only those with an ability if
+// abilities is set
+func (c *GameClient) handIDs(abilities bool) []string {
+	var ids []string
+	for _, card := range c.hand {
+		if !abilities || card.Ability != nil {
+			ids = append(ids, card.ID)
+		}
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+// matchStats counts what happened to the player during a match
+type matchStats struct {
+	deploys, manaSpent          int
+	damageDealt, damageTaken    int // to towers
+	towersDestroyed, towersLost int
+	kills, troopsLost           int
+	healed, exp                 int
+}
+
+// add counts one combat event; you is our player index
+func (s *matchStats) add(ev server.CombatEvent, you int) {
+	ours := ev.Player == you
+	switch ev.Type {
+	case server.EventTroopDeployed:
+		if ours {
+			s.deploys++
+			s.manaSpent += ev.Amount
+		}
+	case server.EventTroopAttack:
+		if ours {
+			s.damageDealt += ev.Damage
+		} else {
+			s.damageTaken += ev.Damage
+		}
+	case server.EventTowerDestroyed:
+		if ours {
+			s.towersDestroyed++
+		} else {
+			s.towersLost++
+		}
+	case server.EventTroopKilled:
+		if ours {
+			s.kills++
+		} else {
+			s.troopsLost++
+		}
+	case server.EventHeal:
+		if ours {
+			s.healed += ev.Amount
+		}
+	case server.EventExpUpdate:
+		if ours {
+			s.exp += ev.Amount
+		}
+	}
+}
+
+// lines formats the stats for show
+func (s *matchStats) lines() []string {
+	return []string{
+		fmt.Sprintf("Deploys          %d (%d mana)", s.deploys, s.manaSpent),
+		fmt.Sprintf("Tower damage     %d dealt, %d taken", s.damageDealt, s.damageTaken),
+		fmt.Sprintf("Towers           %d destroyed, %d lost", s.towersDestroyed, s.towersLost),
+		fmt.Sprintf("Troops           %d killed, %d lost", s.kills, s.troopsLost),
+		fmt.Sprintf("Healed           %d", s.healed),
+		fmt.Sprintf("EXP              %d", s.exp),
+	}
+}
@@ -0,0 +1,758 @@
+// cmd/client/main.go
+// Interactive terminal client built on the tcr/client library
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"math/rand"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"tcr/client"
+	"tcr/server"
+	"tcr/specs"
+	"time"
+)
+
+const (
+	maxReconnectAttempts = 3
+	reconnectDelay       = 5 * time.Second
+	combatLogLines       = 8 // lines kept in the combat log pane
+)
+
+type GameClient struct {
+	serverAddr  string
+	api         *client.Client
+	reader      *bufio.Reader
+	username    string
+	password    string
+	mode        string
+	codec       string // negotiated with hello unless json
+	udp         bool   // ask for state snapshots over UDP
+	playerIndex int
+	inGame      bool
+	ended       bool                // the last match is over
+	rematch     bool                // its game_end offered a rematch that is still open
+	catalog     *server.CardCatalog // from the server after login
+	hand        []server.Card       // cards drawn from the catalog for this match
+	mana        int                 // our mana in the latest state
+	specsPath   string              // specs used to re-simulate replays, empty for the recorded ones
+	replaySpeed float64             // playback speed multiplier
+	replaying   bool
+	combatLog   []string // newest last
+	state       server.StateTracker
+	ui          *tui // full-screen view during matches, nil for the line prompt
+	stats       matchStats
+	scripted    bool // running a -script: plain output, no full-screen view
+
+	// handleEvent and the commands run on different goroutines; each holds
+	// mutex while it reads or changes the match state above
+	mutex sync.Mutex
+}
+
+func NewGameClient(serverAddr, mode string) *GameClient {
+	return &GameClient{
+		serverAddr: serverAddr,
+		mode:       mode,
+		reader:     bufio.NewReader(os.Stdin),
+	}
+}
+
+func (c *GameClient) connect() error {
+	api, err := client.DialRetry(c.serverAddr, maxReconnectAttempts, reconnectDelay, func(attempt int, err error) {
+		fmt.Printf("Connection attempt %d failed: %v\n", attempt, err)
+		if attempt < maxReconnectAttempts {
+			fmt.Printf("Retrying in %v...\n", reconnectDelay)
+		}
+	})
+	if err != nil {
+		return err
+	}
+	c.api = api
+	if c.udp {
+		api.UseUDP()
+	}
+	if c.codec != server.JSONCodec.Name() {
+		if _, err := api.Hello(c.codec); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Register new user
+func (c *GameClient) register() error {
+	fmt.Print("Choose a username: ")
+	c.username = strings.TrimSpace(readLine(c.reader))
+	fmt.Print("Choose a password: ")
+	c.password = strings.TrimSpace(readLine(c.reader))
+
+	return c.api.Register(c.username, c.password)
+}
+
+// Login; with a bot difficulty the server starts a match against a bot
+// instead of queueing
+func (c *GameClient) login(difficulty string) error {
+	fmt.Print("Username: ")
+	c.username = strings.TrimSpace(readLine(c.reader))
+	fmt.Print("Password: ")
+	c.password = strings.TrimSpace(readLine(c.reader))
+
+	if difficulty != "" {
+		return c.api.PlayVsBot(c.username, c.password, c.mode, difficulty)
+	}
+	return c.api.Login(c.username, c.password, c.mode)
+}
+
+func (c *GameClient) handleGameStart(pdu server.PDU) {
+	var startData struct {
+		Mode    string `json:"mode"`
+		Players []int  `json:"players"`
+		You     int    `json:"you"`
+	}
+	if err := json.Unmarshal(pdu.Data, &startData); err != nil {
+		fmt.Printf("Error parsing game start: %v\n", err)
+		return
+	}
+
+	// Randomly draw the hand's unique cards from the catalog at game start
+	c.hand = nil
+	if c.catalog != nil {
+		cards := append([]server.Card(nil), c.catalog.Cards...)
+		rand.Shuffle(len(cards), func(i, j int) { cards[i], cards[j] = cards[j], cards[i] })
+		c.hand = cards[:min(handSize, len(cards))]
+	}
+	c.playerIndex = startData.You
+	c.inGame, c.ended = true, false
+	c.combatLog = nil
+	c.stats = matchStats{}
+	if c.ui != nil {
+		c.ui.startMatch(c.playerIndex, c.hand, c.manaCap())
+		if c.catalog == nil {
+			c.ui.notify("No card catalog from the server; cannot deploy")
+		}
+		return
+	}
+	fmt.Printf("\n=== Game Started ===\n")
+	fmt.Printf("Mode: %s\n", startData.Mode)
+	fmt.Printf("Players: %v\n", startData.Players)
+	fmt.Println("\nYour cards:")
+	for i, card := range c.hand {
+		fmt.Printf("%d. %s\n", i+1, describeCard(card))
+	}
+	fmt.Println("==================")
+}
+
+// handleCatalog stores the card catalog sent after login
+func (c *GameClient) handleCatalog(pdu server.PDU) {
+	var catalog server.CardCatalog
+	if err := json.Unmarshal(pdu.Data, &catalog); err != nil {
+		c.notify("Error parsing card catalog: %v", err)
+		return
+	}
+	c.catalog = &catalog
+}
+
+// manaCap is the catalog's mana cap, or the default rules' before it arrives
+func (c *GameClient) manaCap() int {
+	if c.catalog == nil || c.catalog.ManaCap <= 0 {
+		return specs.DefaultRules().ManaCap
+	}
+	return c.catalog.ManaCap
+}
+
+// describeCard is a card's one-line summary
+func describeCard(card server.Card) string {
+	line := fmt.Sprintf("%s (%d mana)", card.Name, card.Cost)
+	if card.Ability != nil && card.Ability.Type == specs.AbilityHeal {
+		line += fmt.Sprintf(" heals %d", card.Ability.Amount)
+	} else {
+		line += fmt.Sprintf(" HP %d ATK %d DEF %d", card.Health, card.Damage, card.Defence)
+	}
+	if card.Description != "" {
+		line += " - " + card.Description
+	}
+	return line
+}
+
+// handleStatePDU applies a full or delta state update and redraws, asking
+// for a full update when a delta went missing
+func (c *GameClient) handleStatePDU(pdu server.PDU) {
+	state, err := c.state.Apply(pdu)
+	switch {
+	case errors.Is(err, server.ErrStateGap):
+		if err := c.api.Resync(); err != nil {
+			c.notify("Error requesting resync: %v", err)
+		}
+		return
+	case errors.Is(err, server.ErrResyncPending):
+		return
+	case err != nil:
+		c.notify("Error parsing state update: %v", err)
+		return
+	}
+	c.mana = [2]int{state.YourMana, state.OpponentMana}[c.playerIndex]
+	c.showState(state)
+}
+
+// notify reports a message in the status line, or on its own line without
+// the full-screen view
+func (c *GameClient) notify(format string, args ...interface{}) {
+	if c.ui != nil {
+		c.ui.notify(format, args...)
+		return
+	}
+	fmt.Printf("\n"+format+"\n", args...)
+}
+
+// showState redraws the game screen
+func (c *GameClient) showState(state server.GameState) {
+	if c.ui != nil && !c.replaying {
+		c.ui.setState(state)
+		return
+	}
+	if c.scripted {
+		c.printStateLine(state)
+		return
+	}
+
+	// Clear screen
+	fmt.Print("\033[H\033[2J")
+
+	// Display game state
+	fmt.Println("\n=== Game State ===")
+	fmt.Printf("Time Left: %d:%02d", state.TimeLeft/60, state.TimeLeft%60)
+	if state.Phase != "" {
+		fmt.Printf(" [%s]", strings.ToUpper(state.Phase))
+	}
+	fmt.Println()
+	fmt.Printf("Player 1 Mana: %d\n", state.YourMana)
+	fmt.Printf("Player 2 Mana: %d\n", state.OpponentMana)
+
+	fmt.Println("\nPlayer 1 Towers:")
+	for _, tower := range state.Player1Towers {
+		fmt.Printf("- %s: HP %d\n", tower.Name, tower.Health)
+	}
+
+	fmt.Println("\nPlayer 2 Towers:")
+	for _, tower := range state.Player2Towers {
+		fmt.Printf("- %s: HP %d\n", tower.Name, tower.Health)
+	}
+
+	if c.replaying {
+		return
+	}
+	c.printCombatLog()
+
+	fmt.Println("\nAvailable Troops:")
+	for i, card := range c.hand {
+		fmt.Printf("%d. %s (%d mana)\n", i+1, card.Name, card.Cost)
+	}
+
+	if state.Turn >= 0 {
+		if state.Turn == c.playerIndex {
+			fmt.Printf("\nYour turn: %d deploy(s) left\n", state.ActionsLeft)
+		} else {
+			fmt.Println("\nOpponent's turn")
+		}
+	}
+
+	fmt.Printf("\nEnter a card number (1–%d) or a command, 'help' lists them\n", len(c.hand))
+}
+
+// printStateLine prints the state in one line, for scripts whose output is
+// kept as a log
+func (c *GameClient) printStateLine(state server.GameState) {
+	towers := [2][]specs.TowerSpec{state.Player1Towers, state.Player2Towers}
+	mana := [2]int{state.YourMana, state.OpponentMana}
+	side := func(i int) string {
+		hp := 0
+		for _, t := range towers[i] {
+			hp += t.Health
+		}
+		return fmt.Sprintf("mana %d, %d towers %d HP", mana[i], len(towers[i]), hp)
+	}
+	line := fmt.Sprintf("[%d:%02d] you: %s | opponent: %s", state.TimeLeft/60, state.TimeLeft%60,
+		side(c.playerIndex), side(1-c.playerIndex))
+	if state.Phase != "" {
+		line += " [" + strings.ToUpper(state.Phase) + "]"
+	}
+	fmt.Println(line)
+}
+
+// handleCombatEvents adds a tick's events to the combat log; the next state
+// update redraws it
+func (c *GameClient) handleCombatEvents(pdu server.PDU) {
+	var batch server.CombatEvents
+	if err := json.Unmarshal(pdu.Data, &batch); err != nil {
+		c.notify("Error parsing combat events: %v", err)
+		return
+	}
+	names := [2]string{"Opponent", "Opponent"}
+	names[c.playerIndex] = "You"
+	for _, ev := range batch.Events {
+		c.stats.add(ev, c.playerIndex)
+		if line := formatEvent(ev, names); line != "" && c.scripted {
+			fmt.Println(line)
+		}
+	}
+	c.logEvents(batch.Events, names)
+	if c.ui != nil {
+		c.ui.setLog(c.combatLog)
+	}
+}
+
+// logEvents appends events to the combat log, naming each side by names
+func (c *GameClient) logEvents(events []server.CombatEvent, names [2]string) {
+	for _, ev := range events {
+		if line := formatEvent(ev, names); line != "" {
+			c.combatLog = append(c.combatLog, line)
+		}
+	}
+	if n := len(c.combatLog); n > combatLogLines {
+		c.combatLog = c.combatLog[n-combatLogLines:]
+	}
+}
+
+// printCombatLog draws the combat log pane
+func (c *GameClient) printCombatLog() {
+	fmt.Println("\n--- Combat Log ---")
+	for _, line := range c.combatLog {
+		fmt.Println(line)
+	}
+}
+
+// formatEvent describes an event in one line
+func formatEvent(ev server.CombatEvent, names [2]string) string {
+	if ev.Player < 0 || ev.Player > 1 {
+		return ""
+	}
+	side, other := names[ev.Player], names[1-ev.Player]
+	crit := ""
+	if ev.Crit {
+		crit = " (CRIT!)"
+	}
+	switch ev.Type {
+	case server.EventTroopDeployed:
+		return fmt.Sprintf("%s deployed %s for %d mana", side, ev.Attacker, ev.Amount)
+	case server.EventTroopAttack:
+		return fmt.Sprintf("%s's %s hit %s's %s for %d%s, %d HP left", side, ev.Attacker, other, ev.Target,
+			ev.Damage, crit, ev.Health)
+	case server.EventTowerAttack:
+		return fmt.Sprintf("%s's %s hit %s's %s for %d%s, %d HP left", side, ev.Attacker, other, ev.Target,
+			ev.Damage, crit, ev.Health)
+	case server.EventTowerDestroyed:
+		return fmt.Sprintf("💥 %s's %s destroyed %s's %s", side, ev.Attacker, other, ev.Target)
+	case server.EventTroopKilled:
+		return fmt.Sprintf("☠ %s's %s killed %s's %s", side, ev.Attacker, other, ev.Target)
+	case server.EventHeal:
+		return fmt.Sprintf("%s's %s healed %s for %d, %d HP", side, ev.Attacker, ev.Target, ev.Amount, ev.Health)
+	case server.EventExpUpdate:
+		return fmt.Sprintf("%s gained %d EXP", side, ev.Amount)
+	default:
+		return "" // mana_update is already in the state
+	}
+}
+
+func (c *GameClient) handleGameEnd(pdu server.PDU) {
+	var end server.GameEnd
+	if err := json.Unmarshal(pdu.Data, &end); err != nil {
+		c.notify("Error parsing game end: %v", err)
+		return
+	}
+	c.inGame, c.ended = false, true
+	c.rematch = end.Rematch
+	summary := []string{
+		fmt.Sprintf("Result: %s (%s)", end.Result, end.Reason),
+		fmt.Sprintf("EXP Gained: %d", end.Exp),
+	}
+
+	// With a rematch on offer the view stays up for the next match
+	if c.rematch && c.ui != nil {
+		c.show("Game Over", summary)
+		c.ui.notify("Type rematch to play again, or quit")
+		return
+	}
+	// The summary stays on the normal screen after the view closes
+	if c.ui != nil {
+		c.ui.close()
+	}
+	fmt.Printf("\n=== Game Over ===\n")
+	for _, line := range summary {
+		fmt.Println(line)
+	}
+	fmt.Println("================")
+	if c.rematch && !c.scripted {
+		fmt.Println("Type 'rematch' to play again, or 'quit'")
+	}
+}
+
+func (c *GameClient) run() error {
+	if err := c.connect(); err != nil {
+		return err
+	}
+	defer c.api.Close()
+
+	// Login/Register loop
+	for {
+		fmt.Print("Choose L(Login), B(Play vs bot), R(Register), S(Spectate) or V(View replays): ")
+		choice := strings.TrimSpace(readLine(c.reader))
+		switch choice {
+		case "L", "l":
+			if err := c.login(""); err != nil {
+				fmt.Printf("Login failed: %v\n", err)
+			} else {
+				fmt.Println("Login successful!")
+				fmt.Println("Finding a match! Please wait a moment")
+				goto StartGameLoop
+			}
+		case "B", "b":
+			fmt.Printf("Bot difficulty (%s): ", strings.Join(server.BotDifficulties(), ", "))
+			difficulty := readLine(c.reader)
+			if difficulty == "" {
+				difficulty = server.DefaultBotDifficulty
+			}
+			if err := c.login(difficulty); err != nil {
+				fmt.Printf("Login failed: %v\n", err)
+			} else {
+				fmt.Println("Login successful! Starting a match against the bot")
+				goto StartGameLoop
+			}
+		case "R", "r":
+			if err := c.register(); err != nil {
+				fmt.Printf("Register failed: %v\n", err)
+			} else {
+				fmt.Println("Registration successful. Please login.")
+			}
+		case "S", "s":
+			if err := c.spectate(); err != nil {
+				fmt.Printf("Spectate failed: %v\n", err)
+			}
+		case "V", "v":
+			path, err := c.fetchReplay()
+			if err != nil {
+				fmt.Printf("Replay fetch failed: %v\n", err)
+				continue
+			}
+			if err := c.playReplay(path); err != nil {
+				fmt.Printf("Replay failed: %v\n", err)
+			}
+		default:
+			fmt.Println("Invalid choice. Please enter 'L or l', 'B or b', 'R or r', 'S or s' or 'V or v'.")
+		}
+	}
+
+StartGameLoop:
+	// Full-screen view when stdin is a terminal, else the line prompt
+	if ui, err := newTUI(); err == nil {
+		c.ui = ui
+		defer ui.close()
+	}
+
+	// Handle graceful shutdown
+	sigChan := make(chan os.Signal, 1)
+	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
+	go func() {
+		<-sigChan
+		if c.ui != nil {
+			c.ui.close()
+		}
+		fmt.Println("\nShutting down...")
+		c.api.Close()
+		os.Exit(0)
+	}()
+
+	// Start goroutine to receive updates until the connection ends
+	go func() {
+		for pdu := range c.api.Events() {
+			if rematch := c.handleEvent(pdu); pdu.Type == "game_end" && !rematch {
+				os.Exit(0) // Gracefully exit game
+			}
+		}
+		if c.ui != nil {
+			c.ui.close()
+		}
+		c.mutex.Lock()
+		ended := c.ended
+		c.mutex.Unlock()
+		if ended {
+			// The server hangs up once a rematch is off
+			fmt.Println("\nNo rematch, goodbye!")
+			os.Exit(0)
+		}
+		fmt.Printf("\nConnection lost: %v\n", c.api.Err())
+		os.Exit(1)
+	}()
+
+	// Input loop
+	if c.ui != nil {
+		return c.ui.readInput(c.reader, c.command, c.complete)
+	}
+	for {
+		if prompt := c.prompt(); prompt != "" {
+			fmt.Print(prompt)
+			if c.command(strings.TrimSpace(readLine(c.reader))) {
+				return nil
+			}
+		} else {
+			time.Sleep(500 * time.Millisecond) // Avoid busy-waiting
+		}
+	}
+}
+
+// prompt is what the line prompt asks for, "" outside a match and its
+// rematch offer
+func (c *GameClient) prompt() string {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	switch {
+	case c.inGame:
+		return fmt.Sprintf("\nEnter a card number (1–%d) or a command: ", len(c.hand))
+	case c.rematch:
+		return "\nType 'rematch' or 'quit': "
+	}
+	return ""
+}
+
+// handleEvent handles one PDU from the server during a match and reports
+// whether a rematch is on offer afterwards
+func (c *GameClient) handleEvent(pdu server.PDU) bool {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	switch pdu.Type {
+	case "card_catalog":
+		c.handleCatalog(pdu)
+	case "game_start":
+		c.handleGameStart(pdu)
+	case "state_update", "state_delta":
+		c.handleStatePDU(pdu)
+	case "combat_events":
+		c.handleCombatEvents(pdu)
+	case "level_up":
+		c.handleLevelUp(pdu)
+	case "broadcast":
+		var msg struct {
+			Message string `json:"message"`
+		}
+		json.Unmarshal(pdu.Data, &msg)
+		c.notify("📢 Server: %s", msg.Message)
+	case "chat":
+		var msg server.ChatMessage
+		json.Unmarshal(pdu.Data, &msg)
+		c.notify("💬 %s: %s", msg.From, msg.Message)
+	case "overtime":
+		c.notify("Towers tied! Sudden-death overtime: first tower destroyed wins")
+	case "rematch_offer":
+		var offer struct {
+			From string `json:"from"`
+		}
+		json.Unmarshal(pdu.Data, &offer)
+		c.notify("⚔ %s wants a rematch! Type rematch to accept", offer.From)
+	case "rematch_cancel":
+		var cancel struct {
+			Reason string `json:"reason"`
+		}
+		json.Unmarshal(pdu.Data, &cancel)
+		c.rematch = false
+		c.notify("No rematch: %s", cancel.Reason)
+	case "game_end":
+		c.handleGameEnd(pdu)
+	}
+	return c.rematch
+}
+
+// spectate lists live matches and streams the chosen one until it ends
+func (c *GameClient) spectate() error {
+	matches, err := c.api.ListMatches()
+	if err != nil {
+		return err
+	}
+	if len(matches) == 0 {
+		return fmt.Errorf("no live matches")
+	}
+
+	fmt.Println("\n=== Live Matches ===")
+	for i, m := range matches {
+		fmt.Printf("%d. %s vs %s (%s, %ds left, %d watching)\n",
+			i+1, m.Players[0], m.Players[1], m.Mode, m.TimeLeft, m.Spectators)
+	}
+	fmt.Print("Match number: ")
+	idx, err := strconv.Atoi(readLine(c.reader))
+	if err != nil || idx < 1 || idx > len(matches) {
+		return fmt.Errorf("invalid match number")
+	}
+	fmt.Print("Delay in seconds (0 for live): ")
+	delay, _ := strconv.Atoi(readLine(c.reader))
+
+	if err := c.api.Spectate(matches[idx-1].MatchID, delay); err != nil {
+		return err
+	}
+
+	for {
+		pdu, err := c.api.Next()
+		if err != nil {
+			return fmt.Errorf("spectate receive error: %v", err)
+		}
+		switch pdu.Type {
+		case "spectate_update":
+			c.handleSpectateUpdate(pdu)
+		case "spectate_end":
+			fmt.Println("\n=== Match over, stopped spectating ===")
+			return nil
+		}
+	}
+}
+
+// handleSpectateUpdate renders a neutral view of both sides
+func (c *GameClient) handleSpectateUpdate(pdu server.PDU) {
+	var view server.MatchView
+	if err := json.Unmarshal(pdu.Data, &view); err != nil {
+		fmt.Printf("Error parsing spectate update: %v\n", err)
+		return
+	}
+
+	fmt.Print("\033[H\033[2J")
+	fmt.Printf("\n=== Spectating %s (%s) ===\n", view.MatchID, view.Mode)
+	fmt.Printf("Time Left: %d:%02d", view.TimeLeft/60, view.TimeLeft%60)
+	if view.Phase != "" {
+		fmt.Printf(" [%s]", strings.ToUpper(view.Phase))
+	}
+	fmt.Println()
+	for _, p := range view.Players {
+		fmt.Printf("\n%s - Mana: %d\n", p.Username, p.Mana)
+		for _, tower := range p.Towers {
+			fmt.Printf("- %s: HP %d\n", tower.Name, tower.Health)
+		}
+		if len(p.Troops) > 0 {
+			fmt.Printf("Troops: %s\n", strings.Join(p.Troops, ", "))
+		}
+	}
+	c.logEvents(view.Events, [2]string{view.Players[0].Username, view.Players[1].Username})
+	c.printCombatLog()
+}
+
+// fetchReplay lists the server's replays, downloads the chosen one and
+// returns the local path it was saved to
+func (c *GameClient) fetchReplay() (string, error) {
+	replays, err := c.api.ListReplays()
+	if err != nil {
+		return "", err
+	}
+	if len(replays) == 0 {
+		return "", fmt.Errorf("no replays on the server")
+	}
+
+	fmt.Println("\n=== Replays ===")
+	for i, info := range replays {
+		fmt.Printf("%d. %s (%s)\n", i+1, info.ID, info.Modified.Format("2006-01-02 15:04"))
+	}
+	fmt.Print("Replay number: ")
+	idx, err := strconv.Atoi(readLine(c.reader))
+	if err != nil || idx < 1 || idx > len(replays) {
+		return "", fmt.Errorf("invalid replay number")
+	}
+	id := replays[idx-1].ID
+
+	data, err := c.api.FetchReplay(id)
+	if err != nil {
+		return "", err
+	}
+	path := id + ".replay"
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		return "", err
+	}
+	fmt.Printf("Saved replay to %s\n", path)
+	return path, nil
+}
+
+// playReplay re-simulates a replay file locally and renders every tick
+// through handleStateUpdate
+func (c *GameClient) playReplay(path string) error {
+	replay, err := server.LoadReplay(path)
+	if err != nil {
+		return err
+	}
+	var gameSpecs *specs.Specs // nil plays with the specs in the replay
+	if c.specsPath != "" {
+		if gameSpecs, err = specs.LoadSpecs(c.specsPath); err != nil {
+			return fmt.Errorf("load specs: %v", err)
+		}
+	}
+
+	h := replay.Header
+	delay := time.Duration(float64(h.TickMs) * float64(time.Millisecond) / c.replaySpeed)
+	c.replaying = true
+	defer func() { c.replaying = false }()
+
+	err = server.PlayReplay(replay, gameSpecs, func(state server.GameState) {
+		c.showState(state)
+		fmt.Printf("\nReplay %s: %s vs %s (%s mode, %.1fx)\n",
+			h.MatchID, h.Players[0].Username, h.Players[1].Username, h.Mode, c.replaySpeed)
+		time.Sleep(delay)
+	})
+	if err != nil {
+		return err
+	}
+	fmt.Println("\n=== Replay finished ===")
+	return nil
+}
+
+func (c *GameClient) handleLevelUp(pdu server.PDU) {
+	var level struct {
+		Level int `json:"Your level"`
+	}
+	json.Unmarshal(pdu.Data, &level)
+	c.notify("Congratulation you have level up! Level %d", level.Level)
+}
+
+func readLine(reader *bufio.Reader) string {
+	line, _ := reader.ReadString('\n')
+	return strings.TrimSpace(line)
+}
+
+func main() {
+	serverAddr := flag.String("server", "localhost:9000", "Server address")
+	mode := flag.String("mode", server.DefaultMode, "Game mode: "+strings.Join(server.ModeNames(), ", "))
+	replayFile := flag.String("replay", "", "Play back a local replay file instead of connecting")
+	specsPath := flag.String("specs", "", "Game specs for replay playback, for replays that do not record their own")
+	speed := flag.Float64("speed", 1.0, "Replay playback speed")
+	codec := flag.String("codec", server.JSONCodec.Name(), "PDU codec: "+strings.Join(server.CodecNames(), ", "))
+	udp := flag.Bool("udp", false, "Receive state snapshots over UDP when the server offers it")
+	scriptFile := flag.String("script", "", "Run a timed command script instead of the interactive prompt")
+	flag.Parse()
+
+	gameClient := NewGameClient(*serverAddr, *mode)
+	gameClient.codec = *codec
+	gameClient.udp = *udp
+	gameClient.specsPath = *specsPath
+	gameClient.replaySpeed = *speed
+	if gameClient.replaySpeed <= 0 {
+		gameClient.replaySpeed = 1.0
+	}
+	if *replayFile != "" {
+		if err := gameClient.playReplay(*replayFile); err != nil {
+			fmt.Printf("Error: %v\n", err)
+			os.Exit(1)
+		}
+		return
+	}
+	if *scriptFile != "" {
+		if err := gameClient.runScript(*scriptFile); err != nil {
+			fmt.Printf("Error: %v\n", err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	if err := gameClient.run(); err != nil {
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,19 @@
+//go:build !linux
+
+// cmd/client/rawterm_other.go
+// Raw terminal mode is only implemented for Linux; elsewhere the client keeps
+// the line-based prompt
+
+package main
+
+import "errors"
+
+var errNoRawMode = errors.New("raw terminal mode not supported on this platform")
+
+func makeRaw(fd int) (func() error, error) {
+	return nil, errNoRawMode
+}
+
+func termSize(fd int) (int, int, error) {
+	return 0, 0, errNoRawMode
+}
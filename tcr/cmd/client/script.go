@@ -0,0 +1,219 @@
+// cmd/client/script.go
+// Script mode: logs in and plays a match from a file of timed commands, with
+// plain output, for demos and regression runs. A script looks like
+//
+//	# comments and blank lines are skipped; # starts one only at the start
+//	# of a line or after a space, so chat can say "gg#1"
+//	register alice secret        # optional, an existing account is fine
+//	bot alice secret greedy      # or: login alice secret
+//	2s deploy knight left        # 2s after the line before, or game_start
+//	+1.5s chat good luck         # 1.5s after the line before
+//	@30s cast queen              # 30s after game_start
+//	stats                        # right after the line before
+//
+// Setup lines come first; login or bot must be one of them.
+
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"tcr/server"
+	"time"
+)
+
+// scriptStep is one line of a script
+type scriptStep struct {
+	line      int           // in the file, for errors
+	delay     time.Duration // after the previous step, or game_start if fromStart
+	fromStart bool
+	command   string
+}
+
+// script is a parsed script file
+type script struct {
+	setup [][]string // register, login or bot with their arguments
+	steps []scriptStep
+}
+
+// stripComment cuts a line at a # that starts a word
+func stripComment(line string) string {
+	for i, c := range line {
+		if c == '#' && (i == 0 || line[i-1] == ' ' || line[i-1] == '\t') {
+			return line[:i]
+		}
+	}
+	return line
+}
+
+// parseScript reads a whole script, so syntax errors show up before the
+// client connects
+func parseScript(r io.Reader) (*script, error) {
+	s := &script{}
+	loggedIn := false
+	scanner := bufio.NewScanner(r)
+	for n := 1; scanner.Scan(); n++ {
+		fields := strings.Fields(stripComment(scanner.Text()))
+		if len(fields) == 0 {
+			continue
+		}
+
+		switch fields[0] {
+		case "register", "login", "bot":
+			if len(s.steps) > 0 {
+				return nil, fmt.Errorf("line %d: %s must come before the match commands", n, fields[0])
+			}
+			if len(fields) < 3 || len(fields) > 4 || (fields[0] != "bot" && len(fields) == 4) {
+				usage := fields[0] + " <username> <password>"
+				if fields[0] == "bot" {
+					usage += " [difficulty]"
+				}
+				return nil, fmt.Errorf("line %d: usage: %s", n, usage)
+			}
+			if fields[0] != "register" {
+				if loggedIn {
+					return nil, fmt.Errorf("line %d: only one login or bot", n)
+				}
+				loggedIn = true
+			}
+			s.setup = append(s.setup, fields)
+			continue
+		}
+
+		step := scriptStep{line: n}
+		if at := strings.TrimPrefix(strings.TrimPrefix(fields[0], "@"), "+"); at != fields[0] || isDuration(at) {
+			d, err := time.ParseDuration(at)
+			if err != nil || d < 0 {
+				return nil, fmt.Errorf("line %d: bad time %q", n, fields[0])
+			}
+			step.delay, step.fromStart = d, fields[0][0] == '@'
+			fields = fields[1:]
+		}
+		if len(fields) == 0 {
+			return nil, fmt.Errorf("line %d: missing command", n)
+		}
+		if lookupCommand(fields[0]) == nil && !isNumber(fields[0]) {
+			return nil, fmt.Errorf("line %d: unknown command %q", n, fields[0])
+		}
+		step.command = strings.Join(fields, " ")
+		s.steps = append(s.steps, step)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if !loggedIn {
+		return nil, errors.New("script has no login or bot line")
+	}
+	return s, nil
+}
+
+// isDuration reports whether a word is a time like 2s or 1m30s
+func isDuration(word string) bool {
+	_, err := time.ParseDuration(word)
+	return err == nil
+}
+
+func isNumber(word string) bool {
+	return strings.Trim(word, "0123456789") == "" && word != ""
+}
+
+// runScript plays the script at path, returning the first error of a setup
+// line or command
+func (c *GameClient) runScript(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	s, err := parseScript(f)
+	f.Close()
+	if err != nil {
+		return fmt.Errorf("%s: %v", path, err)
+	}
+
+	c.scripted = true
+	if err := c.connect(); err != nil {
+		return err
+	}
+	defer c.api.Close()
+
+	for _, fields := range s.setup {
+		c.username, c.password = fields[1], fields[2]
+		switch fields[0] {
+		case "register":
+			err = c.api.Register(c.username, c.password)
+			if err != nil && strings.Contains(err.Error(), "UserExists") {
+				err = nil
+			}
+		case "login":
+			err = c.api.Login(c.username, c.password, c.mode)
+		case "bot":
+			difficulty := server.DefaultBotDifficulty
+			if len(fields) == 4 {
+				difficulty = fields[3]
+			}
+			err = c.api.PlayVsBot(c.username, c.password, c.mode, difficulty)
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	// The receiver closes ended at game_end, or with over unset when the
+	// connection is gone
+	started, ended := make(chan struct{}), make(chan struct{})
+	over := false
+	go func() {
+		defer close(ended)
+		for pdu := range c.api.Events() {
+			c.handleEvent(pdu)
+			switch pdu.Type {
+			case "game_start":
+				close(started)
+			case "game_end":
+				over = true
+				return
+			}
+		}
+	}()
+	lost := func() error {
+		if over {
+			return nil
+		}
+		return fmt.Errorf("connection lost: %v", c.api.Err())
+	}
+
+	select {
+	case <-started:
+	case <-ended:
+		return lost()
+	}
+	start := time.Now()
+	due := start
+	for i, step := range s.steps {
+		if step.fromStart {
+			due = start.Add(step.delay)
+		} else {
+			due = due.Add(step.delay)
+		}
+		select {
+		case <-time.After(time.Until(due)):
+		case <-ended:
+			fmt.Printf("Match ended with %d script line(s) left\n", len(s.steps)-i)
+			return lost()
+		}
+		fmt.Printf("[%5.1fs] > %s\n", time.Since(start).Seconds(), step.command)
+		err := c.runCommand(step.command)
+		if errors.Is(err, errQuit) {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("%s:%d: %v", path, step.line, err)
+		}
+	}
+	<-ended
+	return lost()
+}
@@ -0,0 +1,381 @@
+// cmd/client/tui.go
+// Full-screen match view: fixed panes for the clock, towers with HP bars,
+// mana, hand and combat log, and an input line that state updates redraw
+// around instead of wiping
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"tcr/server"
+	"tcr/specs"
+	"unicode/utf8"
+)
+
+const (
+	hpBarWidth   = 20
+	manaBarWidth = 10
+	handSize     = 4 // cards in hand, deployed with keys 1-4
+)
+
+// ANSI sequences
+const (
+	ansiAltScreen  = "\x1b[?1049h"
+	ansiMainScreen = "\x1b[?1049l"
+	ansiHome       = "\x1b[H"
+	ansiClearLine  = "\x1b[K"
+	ansiClearDown  = "\x1b[J"
+	ansiHideCursor = "\x1b[?25l"
+	ansiShowCursor = "\x1b[?25h"
+	ansiBold       = "\x1b[1m"
+	ansiDim        = "\x1b[2m"
+	ansiRed        = "\x1b[31m"
+	ansiGreen      = "\x1b[32m"
+	ansiYellow     = "\x1b[33m"
+	ansiReset      = "\x1b[0m"
+)
+
+// towerHP remembers a tower's full health so its bar has a scale, and keeps
+// destroyed towers on screen after they drop out of the state
+type towerHP struct {
+	name   string
+	health int
+	max    int
+}
+
+// tui draws the match screen. Its methods are safe for concurrent use by the
+// event and input goroutines.
+type tui struct {
+	mutex   sync.Mutex
+	out     io.Writer
+	restore func() error
+	closed  bool
+
+	width   int
+	state   *server.GameState
+	you     int
+	towers  [2][]*towerHP // by player index
+	hand    []server.Card
+	log     []string
+	info    []string // help or stats shown over the log until the next key
+	status  string
+	input   []rune
+	manaCap int
+}
+
+// newTUI switches the terminal to raw mode and the alternate screen
+func newTUI() (*tui, error) {
+	fd := int(os.Stdin.Fd())
+	restore, err := makeRaw(fd)
+	if err != nil {
+		return nil, err
+	}
+	width, _, err := termSize(int(os.Stdout.Fd()))
+	if err != nil || width <= 0 {
+		width = 80
+	}
+	ui := &tui{
+		out:     os.Stdout,
+		restore: restore,
+		width:   width,
+		manaCap: specs.DefaultRules().ManaCap,
+	}
+	fmt.Fprint(ui.out, ansiAltScreen)
+	ui.draw()
+	return ui, nil
+}
+
+// close restores the terminal; later updates are ignored
+func (ui *tui) close() {
+	ui.mutex.Lock()
+	defer ui.mutex.Unlock()
+	if ui.closed {
+		return
+	}
+	ui.closed = true
+	fmt.Fprint(ui.out, ansiReset+ansiShowCursor+ansiMainScreen)
+	ui.restore()
+}
+
+// startMatch resets the screen for a new match
+func (ui *tui) startMatch(you int, hand []server.Card, manaCap int) {
+	ui.mutex.Lock()
+	defer ui.mutex.Unlock()
+	ui.you, ui.hand, ui.manaCap = you, hand, manaCap
+	ui.state, ui.towers, ui.log = nil, [2][]*towerHP{}, nil
+	ui.status = "Match started! Press 1-4 to deploy, or type help"
+	ui.draw()
+}
+
+// setState shows a new game state
+func (ui *tui) setState(state server.GameState) {
+	ui.mutex.Lock()
+	defer ui.mutex.Unlock()
+	ui.state = &state
+	// Towers are always listed from player 0's side. Destroyed towers drop
+	// out of the list and several share a name, so each tracked tower takes
+	// the next live one with its name.
+	for i, list := range [2][]specs.TowerSpec{state.Player1Towers, state.Player2Towers} {
+		alive := make(map[string][]int)
+		for _, t := range list {
+			alive[t.Name] = append(alive[t.Name], t.Health)
+		}
+		for _, hp := range ui.towers[i] {
+			if hp.health <= 0 {
+				continue // destroyed towers stay destroyed
+			}
+			hp.health = 0
+			if queue := alive[hp.name]; len(queue) > 0 {
+				hp.health, alive[hp.name] = queue[0], queue[1:]
+				hp.max = max(hp.max, hp.health) // healed past the first value seen
+			}
+		}
+		for _, t := range list {
+			if queue := alive[t.Name]; len(queue) > 0 {
+				ui.towers[i] = append(ui.towers[i], &towerHP{name: t.Name, health: queue[0], max: queue[0]})
+				alive[t.Name] = queue[1:]
+			}
+		}
+	}
+	ui.draw()
+}
+
+// setLog replaces the combat log pane
+func (ui *tui) setLog(lines []string) {
+	ui.mutex.Lock()
+	defer ui.mutex.Unlock()
+	ui.log = append([]string(nil), lines...)
+	ui.draw()
+}
+
+// notify shows a one-line message above the input line
+func (ui *tui) notify(format string, args ...interface{}) {
+	ui.mutex.Lock()
+	defer ui.mutex.Unlock()
+	ui.status = fmt.Sprintf(format, args...)
+	ui.draw()
+}
+
+// showInfo shows a titled block in place of the combat log until the next key
+func (ui *tui) showInfo(title string, lines []string) {
+	ui.mutex.Lock()
+	defer ui.mutex.Unlock()
+	ui.info = append([]string{fmt.Sprintf("── %s %s", title, strings.Repeat("─", max(0, ui.width-len(title)-4)))}, lines...)
+	ui.draw()
+}
+
+// readInput handles keys until quit returns true. A digit typed on an empty
+// line runs at once; anything else is submitted with Enter. Tab completes
+// the line with complete, listing the candidates when several remain.
+func (ui *tui) readInput(in io.Reader, run func(line string) (quit bool),
+	complete func(line string) (string, []string)) error {
+	buf := make([]byte, 64)
+	for {
+		n, err := in.Read(buf)
+		if err != nil {
+			return err
+		}
+		for _, b := range buf[:n] {
+			var line string
+			submit := false
+			if b == '\t' {
+				ui.tab(complete)
+				continue
+			}
+			ui.mutex.Lock()
+			ui.info = nil
+			switch {
+			case b >= '1' && b <= '0'+handSize && len(ui.input) == 0:
+				line, submit = string(b), true
+			case b == '\r' || b == '\n':
+				line, submit = strings.TrimSpace(string(ui.input)), true
+				ui.input = ui.input[:0]
+			case b == 0x7f || b == 0x08: // backspace
+				if len(ui.input) > 0 {
+					ui.input = ui.input[:len(ui.input)-1]
+				}
+			case b == 0x15: // Ctrl-U clears the line
+				ui.input = ui.input[:0]
+			case b == 0x04: // Ctrl-D
+				line, submit = "quit", true
+			case b >= 0x20 && b < 0x7f:
+				ui.input = append(ui.input, rune(b))
+			}
+			ui.draw()
+			ui.mutex.Unlock()
+
+			if submit && line != "" && run(line) {
+				return nil
+			}
+		}
+	}
+}
+
+// tab completes the input line. complete reads the client's state, so it
+// runs without mutex, which the client's event handling takes after its own.
+func (ui *tui) tab(complete func(line string) (string, []string)) {
+	ui.mutex.Lock()
+	input := string(ui.input)
+	ui.mutex.Unlock()
+	completed, options := complete(input)
+
+	ui.mutex.Lock()
+	defer ui.mutex.Unlock()
+	ui.info = nil
+	ui.input = []rune(completed)
+	if len(options) > 1 {
+		ui.status = strings.Join(options, "  ")
+	}
+	ui.draw()
+}
+
+// draw repaints the whole screen; callers hold mutex
+func (ui *tui) draw() {
+	if ui.closed {
+		return
+	}
+	var lines []string
+	add := func(format string, args ...interface{}) {
+		lines = append(lines, fmt.Sprintf(format, args...))
+	}
+
+	add("%s⚔  Text Clash Royale%s", ansiBold, ansiReset)
+	if ui.state == nil {
+		add("")
+		add("Waiting for the match to start...")
+	} else {
+		s := ui.state
+		header := fmt.Sprintf("%s mode  ⏱ %d:%02d", s.Mode, s.TimeLeft/60, s.TimeLeft%60)
+		if s.Phase != "" {
+			header += " [" + strings.ToUpper(s.Phase) + "]"
+		}
+		if s.Turn >= 0 {
+			if s.Turn == ui.you {
+				header += fmt.Sprintf("  Your turn: %d deploy(s) left", s.ActionsLeft)
+			} else {
+				header += "  Opponent's turn"
+			}
+		}
+		add("%s", header)
+
+		them := 1 - ui.you
+		mana := [2]int{s.YourMana, s.OpponentMana} // by player index
+		add("")
+		add("%sOpponent%s", ansiBold, ansiReset)
+		lines = append(lines, towerLines(ui.towers[them])...)
+		add("  Mana  %s", bar(mana[them], ui.manaCap, manaBarWidth, ansiYellow))
+		add("")
+		add("%sYou%s", ansiBold, ansiReset)
+		lines = append(lines, towerLines(ui.towers[ui.you])...)
+		add("  Mana  %s", bar(mana[ui.you], ui.manaCap, manaBarWidth, ansiYellow))
+
+		// Cards the player cannot afford are dimmed
+		add("")
+		add("%sHand%s", ansiBold, ansiReset)
+		for i, card := range ui.hand {
+			line := fmt.Sprintf("  [%d] %s", i+1, describeCard(card))
+			if card.Cost > mana[ui.you] {
+				line = ansiDim + line + ansiReset
+			}
+			add("%s", line)
+		}
+	}
+
+	add("")
+	pane := ui.info
+	if pane == nil {
+		pane = append([]string{"── Combat Log " + strings.Repeat("─", max(0, ui.width-15))}, ui.log...)
+	}
+	for i := 0; i <= combatLogLines || i < len(pane); i++ {
+		if i < len(pane) {
+			add("%s", pane[i])
+		} else {
+			add("")
+		}
+	}
+	add("%s", strings.Repeat("─", ui.width))
+	add("%s", ui.status)
+	prompt := "> " + string(ui.input)
+	add("%s", prompt)
+
+	var b strings.Builder
+	b.WriteString(ansiHideCursor + ansiHome)
+	for i, line := range lines {
+		b.WriteString(truncate(line, ui.width))
+		b.WriteString(ansiReset + ansiClearLine)
+		if i < len(lines)-1 {
+			b.WriteString("\r\n")
+		}
+	}
+	b.WriteString(ansiClearDown)
+	fmt.Fprintf(&b, "\x1b[%d;%dH%s", len(lines), min(utf8.RuneCountInString(prompt)+1, ui.width), ansiShowCursor)
+	io.WriteString(ui.out, b.String())
+}
+
+// towerLines draws one side's towers with HP bars
+func towerLines(towers []*towerHP) []string {
+	lines := make([]string, 0, len(towers))
+	for _, t := range towers {
+		if t.health <= 0 {
+			lines = append(lines, fmt.Sprintf("  %-14s %s💥 destroyed%s", t.name, ansiRed, ansiReset))
+			continue
+		}
+		color := ansiGreen
+		switch {
+		case t.health*4 <= t.max:
+			color = ansiRed
+		case t.health*2 <= t.max:
+			color = ansiYellow
+		}
+		lines = append(lines, fmt.Sprintf("  %-14s %s", t.name, bar(t.health, t.max, hpBarWidth, color)))
+	}
+	return lines
+}
+
+// bar renders value out of total as a filled bar with the numbers after it
+func bar(value, total, width int, color string) string {
+	filled := 0
+	if total > 0 {
+		filled = value * width / total
+	}
+	filled = clamp(filled, 0, width)
+	return fmt.Sprintf("%s%s%s%s %d/%d", color, strings.Repeat("█", filled), ansiReset,
+		strings.Repeat("░", width-filled), value, total)
+}
+
+func clamp(v, lo, hi int) int {
+	if v < lo {
+		return lo
+	}
+	if v > hi {
+		return hi
+	}
+	return v
+}
+
+// truncate cuts a line to width visible runes, skipping ANSI sequences
+func truncate(line string, width int) string {
+	var b strings.Builder
+	visible, escape, csi := 0, false, false
+	for _, r := range line {
+		switch {
+		case csi:
+			csi = r < '@' || r > '~' // parameters run up to the final byte
+		case escape:
+			escape, csi = false, r == '['
+		case r == '\x1b':
+			escape = true
+		default:
+			if visible == width {
+				return b.String()
+			}
+			visible++
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
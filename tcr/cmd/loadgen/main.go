@@ -0,0 +1,256 @@
+// cmd/loadgen/main.go
+// Load generator: runs N scripted bots against a server and reports match
+// throughput, PDU latency percentiles and errors
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"math/rand"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"tcr/client"
+	"tcr/server"
+	"time"
+)
+
+// Stats collects results from every bot
+type Stats struct {
+	mutex     sync.Mutex
+	latencies map[string][]time.Duration
+	errors    map[string]int
+	matches   int
+	results   map[string]int
+}
+
+// NewStats creates an empty collector
+func NewStats() *Stats {
+	return &Stats{
+		latencies: make(map[string][]time.Duration),
+		errors:    make(map[string]int),
+		results:   make(map[string]int),
+	}
+}
+
+// observe records one latency sample
+func (s *Stats) observe(name string, d time.Duration) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.latencies[name] = append(s.latencies[name], d)
+}
+
+// fail counts one error of the given kind
+func (s *Stats) fail(kind string, err error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.errors[kind]++
+	if s.errors[kind] == 1 {
+		log.Printf("first %s error: %v", kind, err)
+	}
+}
+
+// finish counts one completed match
+func (s *Stats) finish(result string) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.matches++
+	s.results[result]++
+}
+
+// percentile returns the p-th percentile of sorted samples
+func percentile(sorted []time.Duration, p float64) time.Duration {
+	if len(sorted) == 0 {
+		return 0
+	}
+	i := int(float64(len(sorted)-1) * p)
+	return sorted[i]
+}
+
+// Report prints throughput, latency percentiles and error counts
+func (s *Stats) Report(elapsed time.Duration) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	fmt.Printf("\n=== Load Test Report (%v) ===\n", elapsed.Round(time.Second))
+	fmt.Printf("Matches completed (per player): %d (%.2f/min)\n",
+		s.matches, float64(s.matches)/elapsed.Minutes())
+	for result, n := range s.results {
+		fmt.Printf("- %s: %d\n", result, n)
+	}
+
+	names := make([]string, 0, len(s.latencies))
+	for name := range s.latencies {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	fmt.Printf("\n%-14s %7s %10s %10s %10s %10s\n", "PDU", "count", "p50", "p90", "p99", "max")
+	for _, name := range names {
+		samples := s.latencies[name]
+		sort.Slice(samples, func(i, j int) bool { return samples[i] < samples[j] })
+		fmt.Printf("%-14s %7d %10v %10v %10v %10v\n", name, len(samples),
+			percentile(samples, 0.50).Round(time.Microsecond),
+			percentile(samples, 0.90).Round(time.Microsecond),
+			percentile(samples, 0.99).Round(time.Microsecond),
+			samples[len(samples)-1].Round(time.Microsecond))
+	}
+
+	fmt.Println("\nErrors:")
+	if len(s.errors) == 0 {
+		fmt.Println("- none")
+	}
+	for kind, n := range s.errors {
+		fmt.Printf("- %s: %d\n", kind, n)
+	}
+}
+
+// LoadBot is one scripted player that queues, deploys and requeues until
+// the deadline
+type LoadBot struct {
+	addr     string
+	username string
+	password string
+	mode     string
+	codec    string // negotiated with hello unless json
+	udp      bool   // ask for state snapshots over UDP
+	troops   []string
+	every    int // deploy on every n-th state update
+	stats    *Stats
+	rng      *rand.Rand
+}
+
+// run plays matches back to back until deadline
+func (b *LoadBot) run(deadline time.Time) {
+	for time.Now().Before(deadline) {
+		if err := b.playMatch(deadline); err != nil {
+			time.Sleep(time.Second) // back off before reconnecting
+		}
+	}
+}
+
+// playMatch connects, logs in, plays one match and disconnects
+func (b *LoadBot) playMatch(deadline time.Time) error {
+	start := time.Now()
+	api, err := client.Dial(b.addr)
+	if err != nil {
+		b.stats.fail("connect", err)
+		return err
+	}
+	defer api.Close()
+	b.stats.observe("connect", time.Since(start))
+
+	// Stop waiting for a match once the test is over
+	timer := time.AfterFunc(time.Until(deadline), func() { api.Close() })
+	defer timer.Stop()
+
+	if b.udp {
+		api.UseUDP()
+	}
+	if b.codec != server.JSONCodec.Name() {
+		if _, err := api.Hello(b.codec); err != nil {
+			b.stats.fail("hello", err)
+			return err
+		}
+	}
+
+	start = time.Now()
+	if err := api.Register(b.username, b.password); err != nil && !strings.Contains(err.Error(), "UserExists") {
+		b.stats.fail("register", err)
+		return err
+	}
+	b.stats.observe("register", time.Since(start))
+
+	start = time.Now()
+	if err := api.Login(b.username, b.password, b.mode); err != nil {
+		b.stats.fail("login", err)
+		return err
+	}
+	queued := time.Now()
+	b.stats.observe("login", queued.Sub(start))
+
+	updates := 0
+	lastUpdate := time.Time{}
+	for pdu := range api.Events() {
+		switch pdu.Type {
+		case "game_start":
+			b.stats.observe("queue_wait", time.Since(queued))
+		case "state_update", "state_delta":
+			if !lastUpdate.IsZero() {
+				b.stats.observe("state_interval", time.Since(lastUpdate))
+			}
+			lastUpdate = time.Now()
+			updates++
+			if updates%b.every == 0 {
+				troop := b.troops[b.rng.Intn(len(b.troops))]
+				if err := api.Deploy(troop); err != nil {
+					b.stats.fail("deploy", err)
+				}
+			}
+		case "game_end":
+			var end struct {
+				Result string `json:"result"`
+			}
+			if err := json.Unmarshal(pdu.Data, &end); err != nil {
+				b.stats.fail("game_end", err)
+			}
+			b.stats.finish(end.Result)
+			return nil
+		}
+	}
+	if time.Now().Before(deadline) {
+		b.stats.fail("disconnect", api.Err())
+		return api.Err()
+	}
+	return nil
+}
+
+func main() {
+	serverAddr := flag.String("server", "localhost:9000", "Server address")
+	bots := flag.Int("bots", 10, "Number of scripted bots")
+	duration := flag.Duration("duration", 5*time.Minute, "How long to keep starting matches")
+	mode := flag.String("mode", server.DefaultMode, "Game mode: "+strings.Join(server.ModeNames(), ", "))
+	prefix := flag.String("prefix", "loadbot", "Username prefix for bot accounts")
+	password := flag.String("password", "loadbot", "Password for bot accounts")
+	troops := flag.String("troops", "pawn,archer,minion,knight", "Comma-separated troops the bots deploy")
+	every := flag.Int("deploy-every", 2, "Deploy on every n-th state update")
+	codec := flag.String("codec", server.JSONCodec.Name(), "PDU codec: "+strings.Join(server.CodecNames(), ", "))
+	udp := flag.Bool("udp", false, "Receive state snapshots over UDP when the server offers it")
+	flag.Parse()
+
+	if *bots < 1 || *every < 1 {
+		fmt.Println("Error: -bots and -deploy-every must be at least 1")
+		os.Exit(1)
+	}
+
+	stats := NewStats()
+	deadline := time.Now().Add(*duration)
+	started := time.Now()
+	log.Printf("Starting %d bots against %s for %v", *bots, *serverAddr, *duration)
+
+	var wg sync.WaitGroup
+	for i := 0; i < *bots; i++ {
+		bot := &LoadBot{
+			addr:     *serverAddr,
+			username: fmt.Sprintf("%s%d", *prefix, i),
+			password: *password,
+			mode:     *mode,
+			codec:    *codec,
+			udp:      *udp,
+			troops:   strings.Split(*troops, ","),
+			every:    *every,
+			stats:    stats,
+			rng:      rand.New(rand.NewSource(int64(i))),
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			bot.run(deadline)
+		}()
+	}
+	wg.Wait()
+
+	stats.Report(time.Since(started))
+}
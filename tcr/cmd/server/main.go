@@ -1,30 +1,135 @@
 package main
 
 import (
+	"flag"
+	"fmt"
+	"log/slog"
+	"net"
+	"strconv"
+	"tcr/config"
+	"tcr/logger"
 	"tcr/server"
 	"tcr/specs"
 )
 
 func main() {
+	usersPath := flag.String("users", "players.json", "User accounts file, created on first register; by default next to the config")
+	configPath := flag.String("config", config.Find("config/dev.json"), "Server config file")
+	specsPath := flag.String("specs", config.Find("specs/game_specs.json"), "Game specs file")
+	replayDir := flag.String("replays", "replays", "Replay directory, empty to disable recording; by default next to the config")
+	auditFile := flag.String("audit", "admin_audit.log", "Admin audit log, empty to only log admin actions; by default next to the config")
+	port := flag.Int("port", 0, "Listen port, overrides the config")
+	logDir := flag.String("logs", "", "Log directory, overrides the config")
+	debug := flag.Bool("debug", false, "Log at debug level, overrides the config")
+	flag.Parse()
+
+	// Data files not named on the command line live next to the config file
+	set := make(map[string]bool)
+	flag.Visit(func(f *flag.Flag) { set[f.Name] = true })
+	for name, path := range map[string]*string{"users": usersPath, "replays": replayDir, "audit": auditFile} {
+		if !set[name] {
+			*path = config.Beside(*configPath, *path)
+		}
+	}
+
 	// Load users
-	users, err := server.LoadUsers("players.json")
+	users, err := server.LoadUsers(*usersPath)
 	if err != nil {
 		panic("failed to load users: " + err.Error())
 	}
 	// log.Println(users)
+	// Load config
+	cfg, err := config.LoadConfig(*configPath)
+	if err != nil {
+		panic("failed to load config: " + err.Error())
+	}
+	if *port != 0 {
+		cfg.Server.Port = *port
+	}
+	if *logDir != "" {
+		cfg.Log.Dir = *logDir
+	} else {
+		cfg.Log.Dir = config.Beside(*configPath, cfg.Log.Dir)
+	}
+	if *debug {
+		cfg.Game.LogLevel = "debug"
+	}
+	// Set up logging
+	closeLogs, err := logger.Init(logger.Options{
+		Dir:        cfg.Log.Dir,
+		Level:      cfg.Game.LogLevel,
+		Format:     cfg.Log.Format,
+		MaxSizeMB:  cfg.Log.MaxSizeMB,
+		MaxAgeDays: cfg.Log.MaxAgeDays,
+	})
+	if err != nil {
+		panic("failed to set up logging: " + err.Error())
+	}
+	defer closeLogs()
 	// Load specs
-	loadedSpecs, err := specs.LoadSpecs("../../specs/game_specs.json")
+	loadedSpecs, err := specs.LoadSpecs(*specsPath)
 	if err != nil {
 		panic("failed to load specs: " + err.Error())
 	}
 
-	troops := loadedSpecs.Troops
-	towers := loadedSpecs.Towers
-	// log.Println("troops: ", troops, "towers: ", towers)
-	matchQueue := make(chan *server.ClientHandler, 10) // adjust size as needed
+	// Open the UDP state channel
+	var udp *server.UDPChannel
+	if cfg.Server.UDPPort != 0 {
+		udp, err = server.ListenUDP(fmt.Sprintf(":%d", cfg.Server.UDPPort))
+		if err != nil {
+			panic("failed to open udp port: " + err.Error())
+		}
+		go udp.Serve()
+	}
+
+	gm := server.NewGameManager(server.Options{
+		Users:     users,
+		UserFile:  *usersPath,
+		Specs:     loadedSpecs,
+		SpecsFile: *specsPath,
+		Config:    cfg,
+		ReplayDir: *replayDir,
+		AuditFile: *auditFile,
+		UDP:       udp,
+	})
+
+	// Start the admin console, on localhost unless admin_host says otherwise
+	if cfg.Server.AdminPort != 0 {
+		go func() {
+			adminHost := cfg.Server.AdminHost
+			if adminHost == "" {
+				adminHost = "127.0.0.1"
+			}
+			adminAddr := net.JoinHostPort(adminHost, strconv.Itoa(cfg.Server.AdminPort))
+			if err := server.StartAdmin(adminAddr, gm); err != nil {
+				slog.Error("admin console stopped", "err", err)
+			}
+		}()
+	}
+
+	// Start the health check and metrics endpoints
+	if cfg.Server.MetricsPort != 0 {
+		go func() {
+			metricsAddr := fmt.Sprintf(":%d", cfg.Server.MetricsPort)
+			if err := server.StartHTTP(metricsAddr, gm); err != nil {
+				slog.Error("metrics server stopped", "err", err)
+			}
+		}()
+	}
+
+	// Start the WebSocket listener for browser clients
+	if cfg.Server.WSPort != 0 {
+		go func() {
+			wsAddr := fmt.Sprintf(":%d", cfg.Server.WSPort)
+			if err := server.StartWebSocket(wsAddr, gm); err != nil {
+				slog.Error("websocket listener stopped", "err", err)
+			}
+		}()
+	}
 
 	// Start the server
-	if err := server.StartServer(":9000", users, troops, towers, matchQueue); err != nil {
+	addr := fmt.Sprintf(":%d", cfg.Server.Port)
+	if err := server.StartServer(addr, gm); err != nil {
 		panic("failed to start server: " + err.Error())
 	}
 
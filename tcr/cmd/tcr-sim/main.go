@@ -0,0 +1,226 @@
+// cmd/tcr-sim/main.go
+// Monte Carlo balance simulator: plays many headless bot-vs-bot matches with
+// a specs file and reports win rates, match length, tower losses and how much
+// each troop contributes to winning
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+	"tcr/config"
+	"tcr/logger"
+	"tcr/server"
+	"tcr/specs"
+	"text/tabwriter"
+)
+
+// troopStats aggregates one troop over every side that deployed it
+type troopStats struct {
+	used     int     // matches in which a side deployed it
+	deploys  int     // total deploys
+	score    float64 // wins (draws count half) of the sides that used it
+	expected float64 // what those sides scored on average over all matches
+}
+
+// Report aggregates simulated matches
+type Report struct {
+	matches    int
+	score      [2]float64 // wins, draws count half
+	wins       [2]int
+	draws      int
+	overtime   int
+	seconds    float64
+	towersLost [2]int
+	kingLost   [2]int
+	results    []server.SimResult
+}
+
+// Add records one match
+func (r *Report) Add(res server.SimResult) {
+	r.matches++
+	r.results = append(r.results, res)
+	r.seconds += float64(res.Ticks) * res.TickInterval
+	if res.Overtime {
+		r.overtime++
+	}
+	switch res.Winner {
+	case -1:
+		r.draws++
+		r.score[0] += 0.5
+		r.score[1] += 0.5
+	default:
+		r.wins[res.Winner]++
+		r.score[res.Winner]++
+	}
+	for i := range res.TowersLost {
+		r.towersLost[i] += res.TowersLost[i]
+		if res.KingLost[i] {
+			r.kingLost[i]++
+		}
+	}
+}
+
+// troops computes per-troop contribution: how much better the sides that
+// deployed a troop scored than those sides did overall
+func (r *Report) troops() map[string]*troopStats {
+	stats := make(map[string]*troopStats)
+	for _, res := range r.results {
+		for side, deploys := range res.Deploys {
+			score := 0.0
+			switch res.Winner {
+			case side:
+				score = 1
+			case -1:
+				score = 0.5
+			}
+			for troop, n := range deploys {
+				st, ok := stats[troop]
+				if !ok {
+					st = &troopStats{}
+					stats[troop] = st
+				}
+				st.used++
+				st.deploys += n
+				st.score += score
+				st.expected += r.score[side] / float64(r.matches)
+			}
+		}
+	}
+	return stats
+}
+
+// Print writes the report
+func (r *Report) Print(w io.Writer) {
+	pct := func(n int) float64 { return 100 * float64(n) / float64(r.matches) }
+
+	fmt.Fprintf(w, "Matches: %d\n", r.matches)
+	fmt.Fprintf(w, "Player 1 wins: %.1f%%  Player 2 wins: %.1f%%  Draws: %.1f%%\n",
+		pct(r.wins[0]), pct(r.wins[1]), pct(r.draws))
+	fmt.Fprintf(w, "Average match length: %.1fs  Overtime: %.1f%%\n",
+		r.seconds/float64(r.matches), pct(r.overtime))
+	for i := range r.towersLost {
+		fmt.Fprintf(w, "Player %d towers lost per match: %.2f  King destroyed: %.1f%%\n",
+			i+1, float64(r.towersLost[i])/float64(r.matches), pct(r.kingLost[i]))
+	}
+
+	stats := r.troops()
+	names := make([]string, 0, len(stats))
+	for name := range stats {
+		names = append(names, name)
+	}
+	contribution := func(st *troopStats) float64 { return 100 * (st.score - st.expected) / float64(st.used) }
+	sort.Slice(names, func(i, j int) bool {
+		ci, cj := contribution(stats[names[i]]), contribution(stats[names[j]])
+		if ci != cj {
+			return ci > cj
+		}
+		return names[i] < names[j]
+	})
+
+	fmt.Fprintln(w, "\nTroop contribution (score of sides that deployed it vs their overall score)")
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintln(tw, "TROOP\tUSED\tDEPLOYS/MATCH\tWIN%\tCONTRIBUTION\t")
+	for _, name := range names {
+		st := stats[name]
+		fmt.Fprintf(tw, "%s\t%d\t%.1f\t%.1f\t%+.1f pp\t\n", name, st.used,
+			float64(st.deploys)/float64(st.used), 100*st.score/float64(st.used), contribution(st))
+	}
+	tw.Flush()
+}
+
+// level returns the stats of an account at the given level, as repeated
+// checkLevelUp calls would leave it
+func level(n int) server.Level {
+	l := server.Level{Level: 1, NextLevel: 200, Multiplier: 1.0}
+	for l.Level < n {
+		l.Level++
+		l.NextLevel = int(float64(l.NextLevel) * 1.1)
+		l.Multiplier = 1.0 + (float64(l.Level) * 0.1) - 0.1
+	}
+	return l
+}
+
+// deck splits a comma-separated deck flag
+func deck(flagValue string) []string {
+	if flagValue == "" {
+		return nil
+	}
+	return strings.Split(flagValue, ",")
+}
+
+func main() {
+	specsPath := flag.String("specs", config.Find("specs/game_specs.json"), "Game specs file to simulate")
+	matches := flag.Int("matches", 1000, "Number of matches")
+	mode := flag.String("mode", server.DefaultMode, "Game mode: "+strings.Join(server.ModeNames(), ", "))
+	bot1 := flag.String("bot1", server.DefaultBotDifficulty, "Player 1 strategy: "+strings.Join(server.BotDifficulties(), ", "))
+	bot2 := flag.String("bot2", server.DefaultBotDifficulty, "Player 2 strategy")
+	deck1 := flag.String("deck1", "", "Player 1 troops, comma-separated; empty deals a random hand per match")
+	deck2 := flag.String("deck2", "", "Player 2 troops, comma-separated; empty deals a random hand per match")
+	level1 := flag.Int("level1", 1, "Player 1 level")
+	level2 := flag.Int("level2", 1, "Player 2 level")
+	seed := flag.Int64("seed", 1, "Seed of the first match; match i uses seed+i")
+	workers := flag.Int("workers", runtime.NumCPU(), "Matches simulated in parallel")
+	verbose := flag.Bool("v", false, "Log the engine's events, down to every deploy and attack, to stderr")
+	flag.Parse()
+
+	if *matches < 1 || *workers < 1 {
+		fmt.Println("Error: -matches and -workers must be at least 1")
+		os.Exit(1)
+	}
+	// The engine logs deploys and attacks at debug level, and overtime and
+	// match ends at info
+	if *verbose {
+		logger.Init(logger.Options{Level: "debug"})
+	} else {
+		slog.SetDefault(slog.New(slog.NewTextHandler(io.Discard, nil)))
+	}
+
+	s, err := specs.LoadSpecs(*specsPath)
+	if err != nil {
+		fmt.Printf("Error: load specs: %v\n", err)
+		os.Exit(1)
+	}
+	sides := [2]server.SimSide{
+		{Difficulty: *bot1, Deck: deck(*deck1), Level: level(*level1)},
+		{Difficulty: *bot2, Deck: deck(*deck2), Level: level(*level2)},
+	}
+	// Fail fast on a bad mode, strategy or deck
+	if _, err := server.Simulate(s, *mode, sides, *seed); err != nil {
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(1)
+	}
+
+	results := make([]server.SimResult, *matches)
+	jobs := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < *workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				results[i], _ = server.Simulate(s, *mode, sides, *seed+int64(i))
+			}
+		}()
+	}
+	for i := 0; i < *matches; i++ {
+		jobs <- i
+	}
+	close(jobs)
+	wg.Wait()
+
+	// Aggregate in match order so the report does not depend on scheduling
+	var report Report
+	for _, res := range results {
+		report.Add(res)
+	}
+	fmt.Printf("Specs %s (%s), %s mode, %s (L%d) vs %s (L%d)\n\n", *specsPath, specs.Hash(s),
+		*mode, *bot1, *level1, *bot2, *level2)
+	report.Print(os.Stdout)
+}
@@ -0,0 +1,154 @@
+// cmd/tcr-specs/main.go
+// Validates a game specs file and prints a balance table
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"tcr/config"
+	"tcr/server"
+	"tcr/specs"
+	"text/tabwriter"
+	"time"
+)
+
+// multiplier is the damage multiplier at a level, as checkLevelUp sets it
+func multiplier(level int) float64 {
+	return 1.0 + float64(level)*0.1 - 0.1
+}
+
+// load decodes a spec file the way LoadSpecs does, but rejects unknown fields
+// so that typos such as "defense" are reported instead of ignored
+func load(path string) (*specs.Specs, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	s := specs.Specs{Rules: specs.DefaultRules()}
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&s); err != nil {
+		var syntax *json.SyntaxError
+		if errors.As(err, &syntax) {
+			line := 1 + bytes.Count(data[:syntax.Offset], []byte("\n"))
+			return nil, fmt.Errorf("line %d: %v", line, err)
+		}
+		return nil, err
+	}
+	return &s, nil
+}
+
+// printProblems lists errors before warnings and returns the error count
+func printProblems(path string, problems []specs.Problem) (errs int) {
+	sort.SliceStable(problems, func(i, j int) bool { return !problems[i].Warning && problems[j].Warning })
+	for _, p := range problems {
+		if !p.Warning {
+			errs++
+		}
+	}
+	fmt.Printf("%s: %d error(s), %d warning(s)\n", path, errs, len(problems)-errs)
+	for _, p := range problems {
+		fmt.Println("  " + p.String())
+	}
+	return errs
+}
+
+// printBalance prints one row per troop against the guard tower. EFF DMG is
+// the damage left after the tower's defence, GUARD HITS how many guard tower
+// hits the troop survives, and TTK the time one troop needs to destroy a
+// full-health guard tower at each level.
+func printBalance(s *specs.Specs, levels int) {
+	guard, ok := s.Towers["guard_tower"]
+	if !ok {
+		fmt.Println("\nNo towers.guard_tower, skipping the balance table")
+		return
+	}
+	fmt.Printf("\nBalance vs %s (%d HP, %d DMG, %d DEF), one action every %v\n",
+		guard.Name, guard.Health, guard.Damage, guard.Defence, server.TroopActionInterval)
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', tabwriter.AlignRight)
+	header := []string{"TROOP", "COST", "HP", "DMG", "DEF", "DMG/MANA", "EFF DMG", "GUARD HITS"}
+	for level := 1; level <= levels; level++ {
+		header = append(header, fmt.Sprintf("TTK L%d", level))
+	}
+	fmt.Fprintln(w, strings.Join(header, "\t")+"\t")
+
+	keys := make([]string, 0, len(s.Troops))
+	for key := range s.Troops {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		a, b := s.Troops[keys[i]], s.Troops[keys[j]]
+		if a.Cost != b.Cost {
+			return a.Cost < b.Cost
+		}
+		return keys[i] < keys[j]
+	})
+
+	for _, key := range keys {
+		t := s.Troops[key]
+		row := []string{t.Name, fmt.Sprint(t.Cost), fmt.Sprint(t.Health), fmt.Sprint(t.Damage), fmt.Sprint(t.Defence)}
+		if t.Cost > 0 {
+			row = append(row, fmt.Sprintf("%.1f", float64(t.Damage)/float64(t.Cost)))
+		} else {
+			row = append(row, "-")
+		}
+		row = append(row, fmt.Sprint(max(t.Damage-guard.Defence, 0)))
+		if taken := guard.Damage - t.Defence; taken > 0 {
+			row = append(row, fmt.Sprint((t.Health+taken-1)/taken))
+		} else {
+			row = append(row, "immune")
+		}
+
+		for level := 1; level <= levels; level++ {
+			if t.Heals() {
+				row = append(row, fmt.Sprintf("heal %d", int(float64(t.Ability.Amount)*multiplier(level))))
+				continue
+			}
+			hit := int(float64(t.Damage)*multiplier(level)) - guard.Defence
+			if hit <= 0 {
+				row = append(row, "never")
+				continue
+			}
+			hits := (guard.Health + hit - 1) / hit
+			// The first hit lands on deploy
+			row = append(row, (time.Duration(hits-1) * server.TroopActionInterval).String())
+		}
+		fmt.Fprintln(w, strings.Join(row, "\t")+"\t")
+	}
+	w.Flush()
+}
+
+func main() {
+	specsPath := flag.String("specs", config.Find("specs/game_specs.json"), "Game specs file to check")
+	levels := flag.Int("levels", 5, "Levels to show time-to-kill for")
+	strict := flag.Bool("strict", false, "Fail on warnings too")
+	balance := flag.Bool("balance", true, "Print the balance table")
+	flag.Parse()
+	if flag.NArg() > 0 {
+		*specsPath = flag.Arg(0)
+	}
+
+	s, err := load(*specsPath)
+	if err != nil {
+		fmt.Printf("%s: %v\n", *specsPath, err)
+		os.Exit(1)
+	}
+
+	problems := specs.Check(s)
+	errs := printProblems(*specsPath, problems)
+	if *balance {
+		printBalance(s, *levels)
+	}
+	fmt.Printf("\nSpecs hash: %s\n", specs.Hash(s))
+
+	if errs > 0 || (*strict && len(problems) > 0) {
+		os.Exit(1)
+	}
+}
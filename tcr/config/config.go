@@ -14,18 +14,34 @@ type Config struct {
 		ReadTimeout  int    `json:"read_timeout"`
 		WriteTimeout int    `json:"write_timeout"`
 		IdleTimeout  int    `json:"idle_timeout"`
+		AdminHost    string `json:"admin_host"`   // admin console interface, empty listens on 127.0.0.1 only
+		AdminPort    int    `json:"admin_port"`   // admin console, 0 disables it
+		MetricsPort  int    `json:"metrics_port"` // HTTP health checks and metrics, 0 disables them
+		WSPort       int    `json:"ws_port"`      // WebSocket clients at /ws, 0 disables them
+		UDPPort      int    `json:"udp_port"`     // state snapshots over UDP, 0 keeps them on TCP
 	} `json:"server"`
 	Game struct {
 		TickIntervalMs  int    `json:"tick_interval_ms"`
 		MatchTimeoutSec int    `json:"match_timeout_sec"`
 		MaxPlayers      int    `json:"max_players"`
+		MaxSpectators   int    `json:"max_spectators"`   // per match, 0 disables spectating
+		BotBackfillSec  int    `json:"bot_backfill_sec"` // queue wait before a bot steps in, 0 disables
+		SpecsPollSec    int    `json:"specs_poll_sec"`   // how often to check the specs file for changes, 0 disables
+		RematchSec      int    `json:"rematch_sec"`      // how long game_end's rematch offer stays open, 0 disables
 		LogLevel        string `json:"log_level"`
 	} `json:"game"`
 	Security struct {
 		RateLimit     int    `json:"rate_limit"`
 		RateWindowSec int    `json:"rate_window_sec"`
 		PasswordSalt  string `json:"password_salt"`
+		AdminToken    string `json:"admin_token"` // required by admin PDUs, empty disables them
 	} `json:"security"`
+	Log struct {
+		Dir        string `json:"dir"`          // server.log and error.log go here, empty logs to stderr
+		Format     string `json:"format"`       // logfmt or json
+		MaxSizeMB  int    `json:"max_size_mb"`  // rotate at this size, 0 never rotates
+		MaxAgeDays int    `json:"max_age_days"` // delete rotated logs older than this, 0 keeps them
+	} `json:"log"`
 }
 
 // LoadConfig reads and parses the configuration file
@@ -54,6 +70,18 @@ func validateConfig(config *Config) error {
 	if config.Server.Port <= 0 || config.Server.Port > 65535 {
 		return fmt.Errorf("invalid server port: %d", config.Server.Port)
 	}
+	if config.Server.AdminPort < 0 || config.Server.AdminPort > 65535 {
+		return fmt.Errorf("invalid admin port: %d", config.Server.AdminPort)
+	}
+	if config.Server.MetricsPort < 0 || config.Server.MetricsPort > 65535 {
+		return fmt.Errorf("invalid metrics port: %d", config.Server.MetricsPort)
+	}
+	if config.Server.WSPort < 0 || config.Server.WSPort > 65535 {
+		return fmt.Errorf("invalid websocket port: %d", config.Server.WSPort)
+	}
+	if config.Server.UDPPort < 0 || config.Server.UDPPort > 65535 {
+		return fmt.Errorf("invalid udp port: %d", config.Server.UDPPort)
+	}
 	if config.Server.ReadTimeout <= 0 {
 		return fmt.Errorf("invalid read timeout: %d", config.Server.ReadTimeout)
 	}
@@ -74,7 +102,21 @@ func validateConfig(config *Config) error {
 	if config.Game.MaxPlayers <= 0 {
 		return fmt.Errorf("invalid max players: %d", config.Game.MaxPlayers)
 	}
-	if config.Game.LogLevel != "debug" && config.Game.LogLevel != "info" {
+	if config.Game.MaxSpectators < 0 {
+		return fmt.Errorf("invalid max spectators: %d", config.Game.MaxSpectators)
+	}
+	if config.Game.BotBackfillSec < 0 {
+		return fmt.Errorf("invalid bot backfill wait: %d", config.Game.BotBackfillSec)
+	}
+	if config.Game.SpecsPollSec < 0 {
+		return fmt.Errorf("invalid specs poll interval: %d", config.Game.SpecsPollSec)
+	}
+	if config.Game.RematchSec < 0 {
+		return fmt.Errorf("invalid rematch window: %d", config.Game.RematchSec)
+	}
+	switch config.Game.LogLevel {
+	case "debug", "info", "warn", "error":
+	default:
 		return fmt.Errorf("invalid log level: %s", config.Game.LogLevel)
 	}
 
@@ -89,5 +131,16 @@ func validateConfig(config *Config) error {
 		return fmt.Errorf("password salt cannot be empty")
 	}
 
+	// Log validation
+	if config.Log.Format != "" && config.Log.Format != "logfmt" && config.Log.Format != "json" {
+		return fmt.Errorf("invalid log format: %s", config.Log.Format)
+	}
+	if config.Log.MaxSizeMB < 0 {
+		return fmt.Errorf("invalid log max size: %d", config.Log.MaxSizeMB)
+	}
+	if config.Log.MaxAgeDays < 0 {
+		return fmt.Errorf("invalid log max age: %d", config.Log.MaxAgeDays)
+	}
+
 	return nil
 }
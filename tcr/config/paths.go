@@ -0,0 +1,46 @@
+// paths.go
+// Default locations of the files shipped in the tcr tree, so commands find
+// them wherever they are started from
+
+package config
+
+import (
+	"os"
+	"path/filepath"
+)
+
+// Find looks for name, a path inside the tcr tree such as config/dev.json, in
+// the executable's directory, then the working directory, then their parents.
+// It returns name unchanged when none has it, so the error names the file.
+func Find(name string) string {
+	var dirs []string
+	if exe, err := os.Executable(); err == nil {
+		dirs = append(dirs, filepath.Dir(exe))
+	}
+	if wd, err := os.Getwd(); err == nil {
+		dirs = append(dirs, wd)
+	}
+	for _, dir := range dirs {
+		for {
+			path := filepath.Join(dir, name)
+			if _, err := os.Stat(path); err == nil {
+				return path
+			}
+			parent := filepath.Dir(dir)
+			if parent == dir {
+				break
+			}
+			dir = parent
+		}
+	}
+	return name
+}
+
+// Beside resolves a relative path against the directory of the config file,
+// where the server keeps its accounts, replays and audit log by default
+func Beside(configPath, path string) string {
+	if path == "" || filepath.IsAbs(path) {
+		return path
+	}
+	return filepath.Join(filepath.Dir(configPath), path)
+}
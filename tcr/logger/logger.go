@@ -1,68 +1,109 @@
+// Package logger sets up the server's structured log: logfmt or JSON lines
+// with DEBUG, INFO, WARN and ERROR levels, written to logs/server.log, with
+// WARN and ERROR also copied to logs/error.log. Both files rotate by size
+// and rotated files are pruned by age.
+//
+// Code logs through log/slog; Init installs the handler as slog's default,
+// which also routes the std log package through it.
 package logger
 
 import (
+	"context"
 	"fmt"
 	"io"
-	"log"
+	"log/slog"
 	"os"
 	"path/filepath"
-	"time"
+	"strings"
 )
 
-var (
-	InfoLogger  *log.Logger
-	ErrorLogger *log.Logger
-	DebugLogger *log.Logger
-)
+// Options configures Init
+type Options struct {
+	Dir        string // log directory; empty logs to stderr only
+	Level      string // debug, info, warn or error
+	Format     string // logfmt (default) or json
+	MaxSizeMB  int    // rotate a file once it reaches this size, 0 never rotates
+	MaxAgeDays int    // delete rotated files older than this, 0 keeps them
+}
 
-// InitLogger initializes the logging system
-func InitLogger(logDir string, logLevel string) error {
-	// Create logs directory if it doesn't exist
-	if err := os.MkdirAll(logDir, 0755); err != nil {
-		return fmt.Errorf("failed to create log directory: %v", err)
+// Init installs the structured logger as slog's default. The returned
+// function closes the log files.
+func Init(opts Options) (func() error, error) {
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(opts.Level)); err != nil {
+		return nil, fmt.Errorf("invalid log level %q", opts.Level)
+	}
+	newHandler, err := handlerFor(opts.Format, level)
+	if err != nil {
+		return nil, err
 	}
 
-	// Create log file with timestamp
-	timestamp := time.Now().Format("2006-01-02_15-04-05")
-	logFile := filepath.Join(logDir, fmt.Sprintf("tcr_%s.log", timestamp))
+	if opts.Dir == "" {
+		slog.SetDefault(slog.New(newHandler(os.Stderr)))
+		return func() error { return nil }, nil
+	}
+	if err := os.MkdirAll(opts.Dir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create log directory: %v", err)
+	}
 
-	file, err := os.OpenFile(logFile, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0666)
+	maxSize := int64(opts.MaxSizeMB) << 20
+	server, err := OpenRotating(filepath.Join(opts.Dir, "server.log"), maxSize, days(opts.MaxAgeDays))
 	if err != nil {
-		return fmt.Errorf("failed to open log file: %v", err)
+		return nil, err
+	}
+	errs, err := OpenRotating(filepath.Join(opts.Dir, "error.log"), maxSize, days(opts.MaxAgeDays))
+	if err != nil {
+		server.Close()
+		return nil, err
 	}
 
-	// Initialize loggers
-	InfoLogger = log.New(file, "INFO: ", log.Ldate|log.Ltime|log.Lshortfile)
-	ErrorLogger = log.New(file, "ERROR: ", log.Ldate|log.Ltime|log.Lshortfile)
+	slog.SetDefault(slog.New(&teeHandler{all: newHandler(server), errs: newHandler(errs)}))
+	return func() error {
+		err := server.Close()
+		if err2 := errs.Close(); err == nil {
+			err = err2
+		}
+		return err
+	}, nil
+}
 
-	// Debug logger only if debug level is enabled
-	if logLevel == "debug" {
-		DebugLogger = log.New(file, "DEBUG: ", log.Ldate|log.Ltime|log.Lshortfile)
-	} else {
-		// Create a no-op debug logger
-		DebugLogger = log.New(os.Stdout, "", 0)
-		DebugLogger.SetOutput(io.Discard)
+// handlerFor returns a constructor for the format's handler
+func handlerFor(format string, level slog.Level) (func(io.Writer) slog.Handler, error) {
+	opts := &slog.HandlerOptions{Level: level}
+	switch strings.ToLower(format) {
+	case "", "logfmt", "text":
+		return func(w io.Writer) slog.Handler { return slog.NewTextHandler(w, opts) }, nil
+	case "json":
+		return func(w io.Writer) slog.Handler { return slog.NewJSONHandler(w, opts) }, nil
+	default:
+		return nil, fmt.Errorf("invalid log format %q", format)
 	}
+}
 
-	return nil
+// teeHandler writes every record to the server stream and WARN and above to
+// the error stream too
+type teeHandler struct {
+	all, errs slog.Handler
 }
 
-// Info logs an info message
-func Info(format string, v ...interface{}) {
-	InfoLogger.Printf(format, v...)
+func (h *teeHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	return h.all.Enabled(ctx, level)
 }
 
-// Error logs an error message
-func Error(format string, v ...interface{}) {
-	ErrorLogger.Printf(format, v...)
+func (h *teeHandler) Handle(ctx context.Context, r slog.Record) error {
+	err := h.all.Handle(ctx, r)
+	if r.Level >= slog.LevelWarn {
+		if err2 := h.errs.Handle(ctx, r.Clone()); err == nil {
+			err = err2
+		}
+	}
+	return err
 }
 
-// Debug logs a debug message
-func Debug(format string, v ...interface{}) {
-	DebugLogger.Printf(format, v...)
+func (h *teeHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return &teeHandler{all: h.all.WithAttrs(attrs), errs: h.errs.WithAttrs(attrs)}
 }
 
-// Fatal logs a fatal error and exits
-func Fatal(format string, v ...interface{}) {
-	ErrorLogger.Fatalf(format, v...)
+func (h *teeHandler) WithGroup(name string) slog.Handler {
+	return &teeHandler{all: h.all.WithGroup(name), errs: h.errs.WithGroup(name)}
 }
@@ -0,0 +1,175 @@
+// logger_test.go
+// Rotation, pruning and the WARN+ copy to error.log
+
+package logger
+
+import (
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// readLines returns a file's lines without the trailing newline
+func readLines(t *testing.T, path string) []string {
+	t.Helper()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	text := strings.TrimSuffix(string(data), "\n")
+	if text == "" {
+		return nil
+	}
+	return strings.Split(text, "\n")
+}
+
+func TestRotatingFile(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "server.log")
+	r, err := OpenRotating(path, 100, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+
+	// 60 bytes fit, the next 60 would not; rotations in quick succession
+	// must not overwrite each other
+	for _, c := range "abc" {
+		line := strings.Repeat(string(c), 59) + "\n"
+		if n, err := r.Write([]byte(line)); err != nil || n != len(line) {
+			t.Fatalf("write %c: %d, %v", c, n, err)
+		}
+	}
+	if got := readLines(t, path); len(got) != 1 || got[0][0] != 'c' {
+		t.Errorf("current file %q, want only the last write", got)
+	}
+	rotated, _ := filepath.Glob(filepath.Join(dir, "server-*.log"))
+	if len(rotated) != 2 {
+		t.Fatalf("rotated files %v, want 2", rotated)
+	}
+	var firsts []byte
+	for _, name := range rotated {
+		lines := readLines(t, name)
+		if len(lines) != 1 {
+			t.Errorf("%s holds %d lines, want 1", name, len(lines))
+			continue
+		}
+		firsts = append(firsts, lines[0][0])
+	}
+	if !bytes.ContainsRune(firsts, 'a') || !bytes.ContainsRune(firsts, 'b') {
+		t.Errorf("rotated files start with %q, want a and b", firsts)
+	}
+
+	// Reopening appends, and a single write larger than the limit still goes
+	// into a file of its own
+	r.Close()
+	r, err = OpenRotating(path, 100, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.Write([]byte(strings.Repeat("d", 150) + "\n"))
+	if got := readLines(t, path); len(got) != 1 || len(got[0]) != 150 {
+		t.Errorf("after an oversized write the file holds %d line(s)", len(got))
+	}
+}
+
+func TestRotatingFilePrunesOldFiles(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "error.log")
+	old := filepath.Join(dir, "error-2020-01-01T00-00-00.000.log")
+	recent := filepath.Join(dir, "error-2020-01-02T00-00-00.000.log")
+	other := filepath.Join(dir, "server-2020-01-01T00-00-00.000.log")
+	for _, name := range []string{old, recent, other} {
+		if err := os.WriteFile(name, []byte("x\n"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	weekAgo := time.Now().Add(-days(7))
+	for _, name := range []string{old, other} {
+		os.Chtimes(name, weekAgo, weekAgo)
+	}
+
+	r, err := OpenRotating(path, 0, days(3))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	for name, want := range map[string]bool{old: false, recent: true, other: true} {
+		if exists(name) != want {
+			t.Errorf("%s exists = %v, want %v", filepath.Base(name), !want, want)
+		}
+	}
+}
+
+func TestTeeHandler(t *testing.T) {
+	newHandler, err := handlerFor("logfmt", slog.LevelInfo)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var all, errs bytes.Buffer
+	log := slog.New(&teeHandler{all: newHandler(&all), errs: newHandler(&errs)}).
+		With("match", "m1").WithGroup("req")
+
+	log.Debug("below the level")
+	log.Info("session started", "n", 1)
+	log.Warn("player disconnected", "n", 2)
+	log.Error("save users failed", "n", 3)
+
+	wantAll := []string{"session started", "player disconnected", "save users failed"}
+	wantErrs := wantAll[1:]
+	for _, c := range []struct {
+		name string
+		buf  *bytes.Buffer
+		want []string
+	}{{"all", &all, wantAll}, {"errs", &errs, wantErrs}} {
+		lines := strings.Split(strings.TrimSpace(c.buf.String()), "\n")
+		if len(lines) != len(c.want) {
+			t.Fatalf("%s stream:\n%s\nwant %d lines", c.name, c.buf.String(), len(c.want))
+		}
+		for i, line := range lines {
+			if !strings.Contains(line, `msg="`+c.want[i]+`"`) ||
+				!strings.Contains(line, "match=m1") || !strings.Contains(line, "req.n=") {
+				t.Errorf("%s line %d = %q, want %q with match=m1 and req.n", c.name, i, line, c.want[i])
+			}
+		}
+	}
+}
+
+func TestInitWritesBothFiles(t *testing.T) {
+	defaultLogger := slog.Default()
+	t.Cleanup(func() { slog.SetDefault(defaultLogger) })
+	dir := t.TempDir()
+	closeLogs, err := Init(Options{Dir: dir, Level: "debug", Format: "json", MaxSizeMB: 1, MaxAgeDays: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	slog.Debug("tick", "n", 1)
+	slog.Warn("slow tick", "n", 2)
+	if err := closeLogs(); err != nil {
+		t.Fatal(err)
+	}
+
+	for name, want := range map[string][]string{
+		"server.log": {"DEBUG", "WARN"},
+		"error.log":  {"WARN"},
+	} {
+		lines := readLines(t, filepath.Join(dir, name))
+		if len(lines) != len(want) {
+			t.Fatalf("%s = %q, want %d lines", name, lines, len(want))
+		}
+		for i, line := range lines {
+			var rec struct{ Level string }
+			if err := json.Unmarshal([]byte(line), &rec); err != nil || rec.Level != want[i] {
+				t.Errorf("%s line %d = %q, want a %s JSON record", name, i, line, want[i])
+			}
+		}
+	}
+	if _, err := Init(Options{Level: "loud"}); err == nil {
+		t.Error("Init accepted log level loud")
+	}
+}
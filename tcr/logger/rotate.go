@@ -0,0 +1,121 @@
+// rotate.go
+// Size-based log file rotation with age-based pruning
+
+package logger
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// rotatedFormat is appended to a rotated file's base name; it sorts by time
+const rotatedFormat = "2006-01-02T15-04-05.000"
+
+// RotatingFile is an append-only file that is renamed to
+// <name>-<time><ext> once it reaches maxSize, after which a fresh file is
+// started. Rotated files older than maxAge are deleted.
+type RotatingFile struct {
+	path    string
+	maxSize int64         // 0 never rotates
+	maxAge  time.Duration // 0 keeps rotated files
+
+	mutex sync.Mutex
+	file  *os.File
+	size  int64
+}
+
+// OpenRotating opens path for appending
+func OpenRotating(path string, maxSize int64, maxAge time.Duration) (*RotatingFile, error) {
+	r := &RotatingFile{path: path, maxSize: maxSize, maxAge: maxAge}
+	if err := r.open(); err != nil {
+		return nil, err
+	}
+	r.prune()
+	return r, nil
+}
+
+func (r *RotatingFile) open() error {
+	f, err := os.OpenFile(r.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	r.file, r.size = f, info.Size()
+	return nil
+}
+
+// Write appends p, rotating first if p would take the file past maxSize
+func (r *RotatingFile) Write(p []byte) (int, error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if r.maxSize > 0 && r.size > 0 && r.size+int64(len(p)) > r.maxSize {
+		if err := r.rotate(); err != nil {
+			return 0, err
+		}
+	}
+	n, err := r.file.Write(p)
+	r.size += int64(n)
+	return n, err
+}
+
+// rotate renames the current file aside and opens a new one. A second
+// rotation within the same millisecond gets a numbered name rather than
+// replacing the first.
+func (r *RotatingFile) rotate() error {
+	if err := r.file.Close(); err != nil {
+		return err
+	}
+	ext := filepath.Ext(r.path)
+	base := strings.TrimSuffix(r.path, ext) + "-" + time.Now().Format(rotatedFormat)
+	rotated := base + ext
+	for n := 1; exists(rotated); n++ {
+		rotated = fmt.Sprintf("%s-%d%s", base, n, ext)
+	}
+	if err := os.Rename(r.path, rotated); err != nil {
+		return err
+	}
+	if err := r.open(); err != nil {
+		return err
+	}
+	go r.prune()
+	return nil
+}
+
+// prune deletes rotated files older than maxAge
+func (r *RotatingFile) prune() {
+	if r.maxAge <= 0 {
+		return
+	}
+	ext := filepath.Ext(r.path)
+	matches, _ := filepath.Glob(strings.TrimSuffix(r.path, ext) + "-*" + ext)
+	cutoff := time.Now().Add(-r.maxAge)
+	for _, name := range matches {
+		if info, err := os.Stat(name); err == nil && info.ModTime().Before(cutoff) {
+			os.Remove(name)
+		}
+	}
+}
+
+// Close closes the current file
+func (r *RotatingFile) Close() error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return r.file.Close()
+}
+
+func exists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
+
+func days(n int) time.Duration {
+	return time.Duration(n) * 24 * time.Hour
+}
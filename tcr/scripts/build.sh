@@ -14,11 +14,23 @@ mkdir -p $LOG_DIR
 
 # Build server
 echo "Building server..."
-go build -o $BUILD_DIR/server ./server
+go build -o $BUILD_DIR/server ./cmd/server
 
 # Build client
 echo "Building client..."
-go build -o $BUILD_DIR/client ./client
+go build -o $BUILD_DIR/client ./cmd/client
+
+# Build load generator
+echo "Building load generator..."
+go build -o $BUILD_DIR/loadgen ./cmd/loadgen
+
+# Build spec checker
+echo "Building spec checker..."
+go build -o $BUILD_DIR/tcr-specs ./cmd/tcr-specs
+
+# Build balance simulator
+echo "Building balance simulator..."
+go build -o $BUILD_DIR/tcr-sim ./cmd/tcr-sim
 
 # Copy configuration files
 echo "Copying configuration files..."
@@ -27,7 +39,13 @@ cp $CONFIG_DIR/*.json $BUILD_DIR/
 # Set permissions
 chmod +x $BUILD_DIR/server
 chmod +x $BUILD_DIR/client
+chmod +x $BUILD_DIR/loadgen
+chmod +x $BUILD_DIR/tcr-specs
+chmod +x $BUILD_DIR/tcr-sim
 
 echo "Build completed successfully!"
 echo "Server binary: $BUILD_DIR/server"
-echo "Client binary: $BUILD_DIR/client" 
\ No newline at end of file
+echo "Client binary: $BUILD_DIR/client"
+echo "Load generator binary: $BUILD_DIR/loadgen"
+echo "Spec checker binary: $BUILD_DIR/tcr-specs"
+echo "Balance simulator binary: $BUILD_DIR/tcr-sim" 
\ No newline at end of file
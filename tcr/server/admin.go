@@ -0,0 +1,332 @@
+// admin.go
+// Line-based admin console on its own port. Connections from localhost are
+// trusted; others must send "auth <admin_token>" first. Every command is
+// written to the audit log.
+
+package server
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	maxAdminAuthAttempts = 3
+	adminHelp            = `commands:
+  users                       list online users
+  sessions                    list live matches
+  kick <user>                 disconnect a user
+  ban <user>                  disconnect a user and refuse their logins
+  unban <user>                allow a banned user to log in again
+  end <match_id> [reason]     force-end a match without a result
+  reset <user>                reset a user to level 1
+  broadcast <message>         send a message to every online user
+  reload                      reload the specs file
+  help                        show this help
+  quit                        close the console`
+)
+
+// OnlineUser is a logged-in user as listed by the admin console
+type OnlineUser struct {
+	Username string
+	Level    int
+	MatchID  string // "" while queued
+}
+
+// AuditEntry is one line of the admin audit log
+type AuditEntry struct {
+	Time    time.Time `json:"time"`
+	Remote  string    `json:"remote"`
+	Command string    `json:"command"`
+	Result  string    `json:"result"`
+}
+
+// StartAdmin begins listening for admin connections
+func StartAdmin(addr string, gm *GameManager) error {
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	slog.Info("admin console listening", "addr", addr)
+	return ServeAdmin(ln, gm)
+}
+
+// ServeAdmin accepts admin connections on ln until it is closed
+func ServeAdmin(ln net.Listener, gm *GameManager) error {
+	for {
+		conn, err := ln.Accept()
+		if errors.Is(err, net.ErrClosed) {
+			return nil
+		}
+		if err != nil {
+			slog.Error("admin accept failed", "err", err)
+			continue
+		}
+		go gm.handleAdmin(conn)
+	}
+}
+
+// handleAdmin runs one console session
+func (gm *GameManager) handleAdmin(conn net.Conn) {
+	defer conn.Close()
+	remote := conn.RemoteAddr().String()
+	scanner := bufio.NewScanner(conn)
+	reply := func(format string, args ...interface{}) {
+		fmt.Fprintf(conn, format+"\n", args...)
+	}
+
+	if !isLoopback(conn.RemoteAddr()) {
+		if !gm.adminAuth(conn, scanner, remote) {
+			return
+		}
+	}
+	reply("TCR admin console, type help for commands")
+
+	for {
+		fmt.Fprint(conn, "> ")
+		if !scanner.Scan() {
+			return
+		}
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		if line == "quit" || line == "exit" {
+			return
+		}
+		out, err := gm.adminCommand(line)
+		result := "ok"
+		if err != nil {
+			result = "error: " + err.Error()
+			out = result
+		}
+		gm.audit(remote, line, result)
+		reply("%s", out)
+	}
+}
+
+// adminAuth reads "auth <token>" lines until one matches admin_token
+func (gm *GameManager) adminAuth(conn net.Conn, scanner *bufio.Scanner, remote string) bool {
+	if gm.config.Security.AdminToken == "" {
+		gm.audit(remote, "connect", "refused: remote admin disabled without admin_token")
+		fmt.Fprintln(conn, "remote admin is disabled")
+		return false
+	}
+	for i := 0; i < maxAdminAuthAttempts; i++ {
+		fmt.Fprint(conn, "auth: ")
+		if !scanner.Scan() {
+			return false
+		}
+		token, _ := strings.CutPrefix(strings.TrimSpace(scanner.Text()), "auth ")
+		if gm.isAdmin(strings.TrimSpace(token)) {
+			gm.audit(remote, "auth", "ok")
+			return true
+		}
+		gm.audit(remote, "auth", "failed")
+		fmt.Fprintln(conn, "invalid token")
+	}
+	return false
+}
+
+// adminCommand runs one console command and returns its output
+func (gm *GameManager) adminCommand(line string) (string, error) {
+	cmd, rest, _ := strings.Cut(line, " ")
+	rest = strings.TrimSpace(rest)
+	arg, reason, _ := strings.Cut(rest, " ")
+
+	switch cmd {
+	case "help":
+		return adminHelp, nil
+	case "users":
+		var b strings.Builder
+		users := gm.OnlineUsers()
+		fmt.Fprintf(&b, "%d online", len(users))
+		for _, u := range users {
+			status := "queued"
+			if u.MatchID != "" {
+				status = "in " + u.MatchID
+			}
+			fmt.Fprintf(&b, "\n  %-16s level %-3d %s", u.Username, u.Level, status)
+		}
+		return b.String(), nil
+	case "sessions":
+		var b strings.Builder
+		matches := gm.Matches()
+		fmt.Fprintf(&b, "%d live", len(matches))
+		for _, m := range matches {
+			fmt.Fprintf(&b, "\n  %s  %s vs %s  %s  %ds left  %d watching",
+				m.MatchID, m.Players[0], m.Players[1], m.Mode, m.TimeLeft, m.Spectators)
+		}
+		return b.String(), nil
+	case "kick":
+		return "kicked " + arg, gm.Kick(arg)
+	case "ban":
+		return "banned " + arg, gm.SetBanned(arg, true)
+	case "unban":
+		return "unbanned " + arg, gm.SetBanned(arg, false)
+	case "end":
+		if reason == "" {
+			reason = "ended by admin"
+		}
+		return "ended " + arg, gm.Terminate(arg, reason)
+	case "reset":
+		return "reset " + arg + " to level 1", gm.ResetLevel(arg)
+	case "broadcast":
+		if rest == "" {
+			return "", fmt.Errorf("usage: broadcast <message>")
+		}
+		return fmt.Sprintf("sent to %d user(s)", gm.Broadcast(rest)), nil
+	case "reload":
+		hash, err := gm.ReloadSpecs()
+		return "specs " + hash, err
+	default:
+		return "", fmt.Errorf("unknown command %q, type help", cmd)
+	}
+}
+
+// audit records an admin action in the server log and the audit file
+func (gm *GameManager) audit(remote, command, result string) {
+	slog.Info("admin command", "remote", remote, "command", command, "result", result)
+	if gm.auditFile == "" {
+		return
+	}
+	data, _ := json.Marshal(AuditEntry{Time: time.Now(), Remote: remote, Command: command, Result: result})
+
+	gm.auditMutex.Lock()
+	defer gm.auditMutex.Unlock()
+	f, err := os.OpenFile(gm.auditFile, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0600)
+	if err != nil {
+		slog.Error("audit log failed", "err", err)
+		return
+	}
+	defer f.Close()
+	if _, err := f.Write(append(data, '\n')); err != nil {
+		slog.Error("audit log failed", "err", err)
+	}
+}
+
+// isLoopback reports whether addr is a localhost address
+func isLoopback(addr net.Addr) bool {
+	tcp, ok := addr.(*net.TCPAddr)
+	return ok && tcp.IP.IsLoopback()
+}
+
+// setOnline remembers a logged-in user's connection
+func (gm *GameManager) setOnline(username string, conn net.Conn) {
+	gm.mutex.Lock()
+	defer gm.mutex.Unlock()
+	gm.online[username] = conn
+}
+
+// setOffline forgets the online entries of a session's players, unless they
+// logged in again on another connection
+func (gm *GameManager) setOffline(gs *GameSession) {
+	gm.mutex.Lock()
+	defer gm.mutex.Unlock()
+	for _, p := range gs.Players {
+		if p.Conn != nil && gm.online[p.Username] == p.Conn {
+			delete(gm.online, p.Username)
+		}
+	}
+}
+
+// OnlineUsers lists logged-in users and the match each one is in
+func (gm *GameManager) OnlineUsers() []OnlineUser {
+	gm.mutex.RLock()
+	inMatch := make(map[string]string)
+	for id, gs := range gm.sessions {
+		for _, p := range gs.Players {
+			inMatch[p.Username] = id
+		}
+	}
+	names := make([]string, 0, len(gm.online))
+	for name := range gm.online {
+		names = append(names, name)
+	}
+	gm.mutex.RUnlock()
+	sort.Strings(names)
+
+	mutex.Lock()
+	defer mutex.Unlock()
+	users := make([]OnlineUser, 0, len(names))
+	for _, name := range names {
+		users = append(users, OnlineUser{Username: name, Level: gm.users[name].Level, MatchID: inMatch[name]})
+	}
+	return users
+}
+
+// Kick closes an online user's connection; a match they are in ends as if
+// they had disconnected
+func (gm *GameManager) Kick(username string) error {
+	gm.mutex.Lock()
+	conn, ok := gm.online[username]
+	delete(gm.online, username)
+	gm.mutex.Unlock()
+	if !ok {
+		return fmt.Errorf("%s is not online", username)
+	}
+	return conn.Close()
+}
+
+// SetBanned bans or unbans an account; banning also kicks the user
+func (gm *GameManager) SetBanned(username string, banned bool) error {
+	err := gm.updateUser(username, func(u *User) { u.Banned = banned })
+	if err != nil || !banned {
+		return err
+	}
+	gm.Kick(username) // fine if they are offline
+	return nil
+}
+
+// ResetLevel puts an account back to level 1 with no EXP. A match the user
+// is playing still saves its result over the reset when it ends.
+func (gm *GameManager) ResetLevel(username string) error {
+	return gm.updateUser(username, func(u *User) {
+		u.Level, u.Exp, u.NextLevel, u.Multiplier = 1, 0, 200, 1.0
+	})
+}
+
+// updateUser changes and saves one account
+func (gm *GameManager) updateUser(username string, change func(*User)) error {
+	mutex.Lock()
+	defer mutex.Unlock()
+	user, ok := gm.users[username]
+	if !ok {
+		return fmt.Errorf("no user %s", username)
+	}
+	change(&user)
+	gm.users[username] = user
+	return saveUsers(gm.userFile, gm.users)
+}
+
+// Broadcast sends a message to every online user and returns how many got it
+func (gm *GameManager) Broadcast(message string) int {
+	gm.mutex.RLock()
+	conns := make([]net.Conn, 0, len(gm.online))
+	for _, conn := range gm.online {
+		conns = append(conns, conn)
+	}
+	gm.mutex.RUnlock()
+
+	data, _ := json.Marshal(struct {
+		Message string `json:"message"`
+	}{message})
+	// Sessions write state updates under mutex; hold it so frames don't interleave
+	mutex.Lock()
+	defer mutex.Unlock()
+	sent := 0
+	for _, conn := range conns {
+		if err := SendPDU(conn, PDU{Type: "broadcast", Data: data}); err == nil {
+			sent++
+		}
+	}
+	return sent
+}
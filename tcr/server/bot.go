@@ -0,0 +1,187 @@
+// bot.go
+// Built-in AI opponents that play through the same deploy path as clients
+
+package server
+
+import (
+	"fmt"
+	"math/rand"
+	"sort"
+	"time"
+)
+
+// Bot difficulties
+const (
+	BotRandom  = "random"  // deploys a random affordable troop now and then
+	BotGreedy  = "greedy"  // spends mana on the priciest troop it can afford
+	BotCounter = "counter" // heals, finishes towers and tanks based on the state
+
+	DefaultBotDifficulty = BotGreedy
+	botHandSize          = 3
+	randomBotInterval    = 2 * time.Second // how often the random bot considers a move
+)
+
+// BotDifficulties lists the supported difficulties
+func BotDifficulties() []string {
+	return []string{BotRandom, BotGreedy, BotCounter}
+}
+
+// Bot drives a Player that has no connection. It has its own random source
+// so that bot decisions never disturb the session's RNG; its deploys go
+// through GameSession.Deploy and are recorded like any client's.
+type Bot struct {
+	Difficulty string
+	Hand       []string // troop keys the bot may deploy
+	rng        *rand.Rand
+	nextMove   int // tick of the random bot's next decision
+}
+
+// NewBot creates a bot and deals it a hand from troopNames
+func NewBot(difficulty string, troopNames []string, seed int64) (*Bot, error) {
+	switch difficulty {
+	case "":
+		difficulty = DefaultBotDifficulty
+	case BotRandom, BotGreedy, BotCounter:
+	default:
+		return nil, fmt.Errorf("unknown bot difficulty: %s", difficulty)
+	}
+
+	// Sort first so the same seed always deals the same hand
+	names := append([]string(nil), troopNames...)
+	sort.Strings(names)
+	rng := rand.New(rand.NewSource(seed))
+	rng.Shuffle(len(names), func(i, j int) { names[i], names[j] = names[j], names[i] })
+
+	return &Bot{
+		Difficulty: difficulty,
+		Hand:       names[:min(botHandSize, len(names))],
+		rng:        rng,
+	}, nil
+}
+
+// Decide returns the troop the bot deploys after the current tick, or ""
+func (b *Bot) Decide(gs *GameSession, playerIdx int) string {
+	if !gs.Mode.CanDeploy(gs, playerIdx) {
+		return ""
+	}
+	me := gs.Players[playerIdx]
+	affordable := b.affordable(gs, me.Mana)
+	if len(affordable) == 0 {
+		return ""
+	}
+
+	switch b.Difficulty {
+	case BotRandom:
+		if gs.ticks < b.nextMove {
+			return ""
+		}
+		b.nextMove = gs.ticks + gs.ticksFor(randomBotInterval)
+		if b.rng.Intn(2) == 0 {
+			return ""
+		}
+		return affordable[b.rng.Intn(len(affordable))]
+	case BotCounter:
+		return b.counter(gs, playerIdx, affordable)
+	default:
+		return b.priciest(gs, affordable)
+	}
+}
+
+// counter reads the board: heal a damaged tower under attack, finish a
+// tower that one hit can take, otherwise bank mana and spend it on a tank
+// when full so that damage dealers behind it live longer
+func (b *Bot) counter(gs *GameSession, playerIdx int, affordable []string) string {
+	me := gs.Players[playerIdx]
+	opponent := gs.Players[1-playerIdx]
+
+	if len(opponent.ActiveTroops) > 0 {
+		for _, name := range affordable {
+			if gs.TroopSpecs[name].Heals() && weakestTowerRatio(me, gs) < 0.5 {
+				return name
+			}
+		}
+	}
+
+	if target := opponent.NextAliveTower(); target != nil {
+		for _, name := range affordable {
+			spec := gs.TroopSpecs[name]
+			dmg := int(float64(spec.Damage)*me.Level.Multiplier) - target.Defence
+			if !spec.Heals() && spec.Damage > 0 && dmg >= target.Health {
+				return name
+			}
+		}
+	}
+
+	if me.Mana < gs.Rules.ManaCap {
+		return ""
+	}
+	if len(me.ActiveTroops) == 0 {
+		best := ""
+		for _, name := range affordable {
+			if best == "" || gs.TroopSpecs[name].Health > gs.TroopSpecs[best].Health {
+				best = name
+			}
+		}
+		return best
+	}
+	return b.priciest(gs, affordable)
+}
+
+// priciest picks the most expensive troop, breaking ties by name
+func (b *Bot) priciest(gs *GameSession, affordable []string) string {
+	best := affordable[0]
+	for _, name := range affordable[1:] {
+		if gs.TroopSpecs[name].Cost > gs.TroopSpecs[best].Cost {
+			best = name
+		}
+	}
+	return best
+}
+
+// affordable lists the troops in hand the bot has mana for, in hand order
+func (b *Bot) affordable(gs *GameSession, mana int) []string {
+	var names []string
+	for _, name := range b.Hand {
+		if spec, ok := gs.TroopSpecs[name]; ok && spec.Cost <= mana {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// weakestTowerRatio returns the lowest remaining health fraction among the
+// player's standing towers
+func weakestTowerRatio(p *Player, gs *GameSession) float64 {
+	ratio := 1.0
+	for _, t := range p.Towers {
+		full := 0
+		for _, spec := range gs.TowerSpecs {
+			if spec.Name == t.Name {
+				full = spec.Health
+			}
+		}
+		if t.Health > 0 && full > 0 {
+			ratio = min(ratio, float64(t.Health)/float64(full))
+		}
+	}
+	return ratio
+}
+
+// RunBots lets every bot-controlled player act after a tick and reports
+// whether one of their deploys ended the match
+func (gs *GameSession) RunBots() bool {
+	for i, p := range gs.Players {
+		if p.Bot == nil {
+			continue
+		}
+		if troop := p.Bot.Decide(gs, i); troop != "" {
+			if gs.botDeployed != nil {
+				gs.botDeployed(i, troop)
+			}
+			if gs.Deploy(DeployCmd{PlayerIndex: i, TroopName: troop}) {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,64 @@
+// catalog.go
+// The card catalog: every deployable troop with its cost, stats and
+// description, sent to clients right after login so they never need their
+// own copy of the specs
+
+package server
+
+import (
+	"sort"
+	"tcr/specs"
+)
+
+// Card is one troop as the client shows it
+type Card struct {
+	ID          string         `json:"id"` // what deploy names
+	Name        string         `json:"name"`
+	Cost        int            `json:"cost"`
+	Health      int            `json:"health"`
+	Damage      int            `json:"damage"`
+	Defence     int            `json:"defence"`
+	Ability     *specs.Ability `json:"ability,omitempty"`
+	Description string         `json:"description,omitempty"`
+}
+
+// CardCatalog is the payload of a card_catalog PDU
+type CardCatalog struct {
+	Cards     []Card `json:"cards"` // cheapest first
+	ManaCap   int    `json:"mana_cap"`
+	SpecsHash string `json:"specs_hash"`
+}
+
+// NewCardCatalog lists the troops in s, cheapest first
+func NewCardCatalog(s *specs.Specs) CardCatalog {
+	cards := make([]Card, 0, len(s.Troops))
+	for id, t := range s.Troops {
+		cards = append(cards, Card{
+			ID:          id,
+			Name:        t.Name,
+			Cost:        t.Cost,
+			Health:      t.Health,
+			Damage:      t.Damage,
+			Defence:     t.Defence,
+			Ability:     t.Ability,
+			Description: t.Description,
+		})
+	}
+	sort.Slice(cards, func(i, j int) bool {
+		if cards[i].Cost != cards[j].Cost {
+			return cards[i].Cost < cards[j].Cost
+		}
+		return cards[i].ID < cards[j].ID
+	})
+	return CardCatalog{Cards: cards, ManaCap: s.Rules.ManaCap, SpecsHash: specs.Hash(s)}
+}
+
+// Card looks up a card by ID
+func (c CardCatalog) Card(id string) (Card, bool) {
+	for _, card := range c.Cards {
+		if card.ID == id {
+			return card, true
+		}
+	}
+	return Card{}, false
+}
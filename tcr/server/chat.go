@@ -0,0 +1,54 @@
+// chat.go
+// In-match chat between the two players
+
+package server
+
+import (
+	"encoding/json"
+	"strings"
+	"unicode"
+)
+
+// maxChatLen caps a chat message, in runes
+const maxChatLen = 200
+
+// ChatMessage is the payload of a chat PDU. Clients send only Message; the
+// server fills in From when relaying it.
+type ChatMessage struct {
+	From    string `json:"from,omitempty"`
+	Message string `json:"message"`
+}
+
+// cleanChat strips control characters, so a message cannot redraw the other
+// player's terminal, and caps its length
+func cleanChat(msg string) string {
+	msg = strings.Map(func(r rune) rune {
+		if unicode.IsControl(r) {
+			return -1
+		}
+		return r
+	}, msg)
+	msg = strings.TrimSpace(msg)
+	if runes := []rune(msg); len(runes) > maxChatLen {
+		msg = string(runes[:maxChatLen])
+	}
+	return msg
+}
+
+// relayChat passes a player's message to the opponent; bots do not read it
+func (gs *GameSession) relayChat(from int, msg string) {
+	msg = cleanChat(msg)
+	if msg == "" {
+		return
+	}
+	to := gs.Players[1-from]
+	if to.Conn == nil {
+		return
+	}
+	data, _ := json.Marshal(ChatMessage{From: gs.Players[from].Username, Message: msg})
+	mutex.Lock()
+	defer mutex.Unlock()
+	if err := SendPDU(to.Conn, PDU{Type: "chat", Data: data}); err != nil {
+		gs.logger().Info("chat not delivered", "user", to.Username, "err", err)
+	}
+}
@@ -0,0 +1,96 @@
+// codec.go
+// Frame body encodings. Every connection starts with JSON; a client may send
+// hello with the codecs it supports, and both sides switch to the server's
+// pick after hello_resp.
+
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"sort"
+)
+
+// Codec encodes PDUs into frame bodies and back
+type Codec interface {
+	Name() string
+	Marshal(pdu PDU) ([]byte, error)
+	Unmarshal(data []byte, pdu *PDU) error
+}
+
+var (
+	// JSONCodec is the default encoding: the PDU as a JSON object
+	JSONCodec Codec = jsonCodec{}
+	// MsgpackCodec encodes the PDU as a MessagePack [type, data] array
+	MsgpackCodec Codec = msgpackCodec{}
+)
+
+// codecs are the encodings a connection can negotiate, by name
+var codecs = map[string]Codec{
+	JSONCodec.Name():    JSONCodec,
+	MsgpackCodec.Name(): MsgpackCodec,
+}
+
+// CodecNames lists the supported codecs in alphabetical order
+func CodecNames() []string {
+	names := make([]string, 0, len(codecs))
+	for name := range codecs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// NewCodec looks up a codec by name
+func NewCodec(name string) (Codec, error) {
+	c, ok := codecs[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown codec: %s", name)
+	}
+	return c, nil
+}
+
+// pickCodec returns the first of the client's preferred codecs that is
+// supported
+func pickCodec(preferred []string) (Codec, bool) {
+	for _, name := range preferred {
+		if c, ok := codecs[name]; ok {
+			return c, true
+		}
+	}
+	return nil, false
+}
+
+// codecConn is a connection whose PDUs use a negotiated codec
+type codecConn struct {
+	net.Conn
+	codec Codec
+}
+
+// WithCodec returns conn with SendPDU and ReceivePDU using codec
+func WithCodec(conn net.Conn, codec Codec) net.Conn {
+	if cc, ok := conn.(*codecConn); ok {
+		conn = cc.Conn
+	}
+	if codec == JSONCodec {
+		return conn
+	}
+	return &codecConn{Conn: conn, codec: codec}
+}
+
+// codecOf returns the codec a connection negotiated, JSON by default
+func codecOf(conn net.Conn) Codec {
+	if cc, ok := conn.(*codecConn); ok {
+		return cc.codec
+	}
+	return JSONCodec
+}
+
+type jsonCodec struct{}
+
+func (jsonCodec) Name() string { return "json" }
+
+func (jsonCodec) Marshal(pdu PDU) ([]byte, error) { return json.Marshal(pdu) }
+
+func (jsonCodec) Unmarshal(data []byte, pdu *PDU) error { return json.Unmarshal(data, pdu) }
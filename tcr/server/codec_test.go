@@ -0,0 +1,149 @@
+// codec_test.go
+// Codec round trips, hello negotiation, and size/CPU benchmarks for the
+// frames a match sends every tick
+
+package server
+
+import (
+	"encoding/json"
+	"strings"
+	"tcr/specs"
+	"testing"
+)
+
+// benchFrames are typical per-tick frames
+func benchFrames(b testing.TB) map[string]PDU {
+	tower := func(name, typ string, hp int) specs.TowerSpec {
+		return specs.TowerSpec{Name: name, Type: typ, Health: hp, Damage: 300, Defence: 100}
+	}
+	towers := []specs.TowerSpec{
+		tower("King Tower", "king", 4000), tower("Guard Tower 1", "guard", 1850), tower("Guard Tower 2", "guard", 2500),
+	}
+	fields, err := stateFields(GameState{
+		YourMana: 7, OpponentMana: 4, Player1Towers: towers, Player2Towers: towers,
+		Phase: "double", TimeLeft: 97, Mode: "classic", Turn: -1,
+	})
+	if err != nil {
+		b.Fatal(err)
+	}
+	full, _ := fullState(fields, 42)
+	hit, _ := json.Marshal(tower("Guard Tower 1", "guard", 1400))
+	delta, _ := json.Marshal(StateDelta{Seq: 43, Changes: StateFields{
+		"your_mana": json.RawMessage("8"), "time_left": json.RawMessage("96"),
+	}, Elements: map[string]ElementChanges{"opponent_towers": {1: hit}}})
+	events, _ := json.Marshal(CombatEvents{Tick: 43, Events: []CombatEvent{
+		{Type: EventTroopAttack, Player: 0, Attacker: "Knight", Target: "Guard Tower 1", Damage: 450, Crit: true, Health: 1400},
+		{Type: EventTowerAttack, Player: 1, Attacker: "King Tower", Target: "Knight", Damage: 380, Health: 1020},
+	}})
+	return map[string]PDU{
+		"state_update":  {Type: "state_update", Data: full},
+		"state_delta":   {Type: "state_delta", Data: delta},
+		"combat_events": {Type: "combat_events", Data: events},
+	}
+}
+
+// sameJSON reports whether two JSON documents hold the same values
+func sameJSON(t *testing.T, a, b []byte) bool {
+	t.Helper()
+	var va, vb interface{}
+	if err := json.Unmarshal(a, &va); err != nil {
+		t.Fatal(err)
+	}
+	if err := json.Unmarshal(b, &vb); err != nil {
+		t.Fatal(err)
+	}
+	ja, _ := json.Marshal(va)
+	jb, _ := json.Marshal(vb)
+	return string(ja) == string(jb)
+}
+
+func TestCodecRoundTrip(t *testing.T) {
+	pdus := []PDU{
+		{Type: "deploy", Data: json.RawMessage(`{"troop":"pawn"}`)},
+		{Type: "resync", Data: json.RawMessage(`{}`)},
+		{Type: "values", Data: json.RawMessage(`{"small":-5,"neg":-200,"big":5000000000,"min":-9223372036854775808,` +
+			`"float":1.25,"exp":1e300,"t":true,"f":false,"n":null,"long":"` + strings.Repeat("x", 300) + `",` +
+			`"list":[1,2,3,4,5,6,7,8,9,10,11,12,13,14,15,16,17],"nested":{"a":[{"b":"c"}]}}`)},
+		{Type: "chat", Data: json.RawMessage(` { "message" : "say \"gg\"\\ \u00e9\ud83d\ude00\n\u0001", "empty": [ ], "o": {} } `)},
+		{Type: "wide", Data: json.RawMessage(`{` + strings.Repeat(`"k":{"a":1,"b":2,"c":3,"d":4,"e":5,"f":6,"g":7,"h":8,"i":9,"j":10,"k":11,"l":12,"m":13,"n":14,"o":15,"p":16},`, 3) + `"z":[` + strings.Repeat("0,", 70000) + `0]}`)},
+	}
+	for name, pdu := range benchFrames(t) {
+		pdus = append(pdus, PDU{Type: name, Data: pdu.Data})
+	}
+
+	for _, codec := range []Codec{JSONCodec, MsgpackCodec} {
+		for _, pdu := range pdus {
+			data, err := codec.Marshal(pdu)
+			if err != nil {
+				t.Fatalf("%s: marshal %s: %v", codec.Name(), pdu.Type, err)
+			}
+			var got PDU
+			if err := codec.Unmarshal(data, &got); err != nil {
+				t.Fatalf("%s: unmarshal %s: %v", codec.Name(), pdu.Type, err)
+			}
+			if got.Type != pdu.Type || !sameJSON(t, got.Data, pdu.Data) {
+				t.Errorf("%s: %s round trip = %s, want %s", codec.Name(), pdu.Type, got.Data, pdu.Data)
+			}
+		}
+	}
+
+	// Payloads that are not JSON are refused
+	for _, bad := range []string{`{`, `[1,]`, `{"a" 1}`, `tru`, `"open`, `{} x`, `1e999`, `--1`} {
+		if _, err := MsgpackCodec.Marshal(PDU{Type: "bad", Data: json.RawMessage(bad)}); err == nil {
+			t.Errorf("msgpack marshalled %s", bad)
+		}
+	}
+
+	// Truncated frames fail instead of panicking
+	data, _ := MsgpackCodec.Marshal(pdus[2])
+	for i := 0; i < len(data); i++ {
+		var pdu PDU
+		if err := MsgpackCodec.Unmarshal(data[:i], &pdu); err == nil {
+			t.Fatalf("truncated to %d bytes decoded without error", i)
+		}
+	}
+}
+
+func TestHelloNegotiatesMsgpack(t *testing.T) {
+	addr, _ := startTestServer(t)
+	c := dialTestClient(t, addr, "dave")
+
+	c.send("hello", map[string][]string{"codecs": {"cbor", "msgpack"}})
+	var resp struct{ Status, Codec string }
+	if err := json.Unmarshal(c.next("hello_resp").Data, &resp); err != nil {
+		t.Fatal(err)
+	}
+	if resp.Status != "OK" || resp.Codec != "msgpack" {
+		t.Fatalf("hello_resp = %+v, want OK msgpack", resp)
+	}
+
+	// The rest of the session is MessagePack both ways
+	c.conn = WithCodec(c.conn, MsgpackCodec)
+	c.login()
+}
+
+func BenchmarkCodecs(b *testing.B) {
+	for name, pdu := range benchFrames(b) {
+		for _, codec := range []Codec{JSONCodec, MsgpackCodec} {
+			data, err := codec.Marshal(pdu)
+			if err != nil {
+				b.Fatal(err)
+			}
+			b.Run(name+"/"+codec.Name()+"/marshal", func(b *testing.B) {
+				b.ReportAllocs()
+				for i := 0; i < b.N; i++ {
+					codec.Marshal(pdu)
+				}
+				b.ReportMetric(float64(len(data)), "bytes/frame")
+			})
+			b.Run(name+"/"+codec.Name()+"/unmarshal", func(b *testing.B) {
+				b.ReportAllocs()
+				var out PDU
+				for i := 0; i < b.N; i++ {
+					codec.Unmarshal(data, &out)
+				}
+				b.ReportMetric(float64(len(data)), "bytes/frame")
+			})
+		}
+	}
+}
@@ -0,0 +1,190 @@
+// delta.go
+// Delta-compressed state updates. A player gets a full state_update when the
+// match starts and whenever it asks to resync; every later tick sends only
+// the GameState fields that changed, as a sequence-numbered state_delta.
+// Tower lists of unchanged length are diffed per tower, so a hit resends one
+// tower rather than all of them. A client that sees a gap in the sequence
+// sends resync.
+
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+)
+
+var (
+	// ErrStateGap reports a missed state_delta; the client should resync
+	ErrStateGap = errors.New("state delta out of sequence")
+	// ErrResyncPending reports a delta ignored while waiting for the resync
+	ErrResyncPending = errors.New("waiting for state resync")
+)
+
+// StateFields is a GameState as its top-level JSON fields
+type StateFields map[string]json.RawMessage
+
+// Fields diffed per element while their length stays the same
+var elementFields = map[string]bool{"your_towers": true, "opponent_towers": true}
+
+// StateDelta is the payload of a state_delta PDU: the fields that changed
+// since the update with sequence number Seq-1
+type StateDelta struct {
+	Seq      int                       `json:"seq"`
+	Changes  StateFields               `json:"changes"`
+	Elements map[string]ElementChanges `json:"elements,omitempty"` // changed list elements, by field
+}
+
+// ElementChanges holds the changed elements of a list field by index
+type ElementChanges map[int]json.RawMessage
+
+// empty reports whether the delta changes nothing
+func (d StateDelta) empty() bool {
+	return len(d.Changes) == 0 && len(d.Elements) == 0
+}
+
+// stateFields splits a state into its fields
+func stateFields(state GameState) (StateFields, error) {
+	data, err := json.Marshal(state)
+	if err != nil {
+		return nil, err
+	}
+	var fields StateFields
+	err = json.Unmarshal(data, &fields)
+	return fields, err
+}
+
+// diffState returns the fields of next that are new or differ from prev,
+// with elementFields of unchanged length narrowed to the changed elements
+func diffState(prev, next StateFields) StateDelta {
+	delta := StateDelta{Changes: make(StateFields)}
+	for key, value := range next {
+		old, ok := prev[key]
+		if ok && bytes.Equal(old, value) {
+			continue
+		}
+		if elems := diffElements(old, value); ok && elementFields[key] && elems != nil {
+			if delta.Elements == nil {
+				delta.Elements = make(map[string]ElementChanges)
+			}
+			delta.Elements[key] = elems
+			continue
+		}
+		delta.Changes[key] = value
+	}
+	return delta
+}
+
+// diffElements returns the elements of the list next that differ from prev,
+// or nil unless both are lists of the same length
+func diffElements(prev, next json.RawMessage) ElementChanges {
+	var olds, news []json.RawMessage
+	if json.Unmarshal(prev, &olds) != nil || json.Unmarshal(next, &news) != nil ||
+		len(olds) != len(news) {
+		return nil
+	}
+	changes := make(ElementChanges)
+	for i := range news {
+		if !bytes.Equal(olds[i], news[i]) {
+			changes[i] = news[i]
+		}
+	}
+	return changes
+}
+
+// fullState encodes a state_update payload: every field plus the sequence
+// number the following delta builds on
+func fullState(fields StateFields, seq int) ([]byte, error) {
+	payload := make(StateFields, len(fields)+1)
+	for key, value := range fields {
+		payload[key] = value
+	}
+	payload["seq"] = json.RawMessage(fmt.Sprint(seq))
+	return json.Marshal(payload)
+}
+
+// StateTracker rebuilds a player's GameState from state_update and
+// state_delta PDUs
+type StateTracker struct {
+	fields StateFields
+	seq    int
+}
+
+// Apply folds a state_update or state_delta into the tracked state and
+// returns it. It returns ErrStateGap when a delta does not follow the last
+// update or does not fit the tracked state; the client should then send resync, and later deltas return
+// ErrResyncPending until a state_update arrives, which Apply always accepts.
+func (t *StateTracker) Apply(pdu PDU) (GameState, error) {
+	switch pdu.Type {
+	case "state_update":
+		var full struct {
+			Seq int `json:"seq"`
+		}
+		if err := json.Unmarshal(pdu.Data, &full); err != nil {
+			return GameState{}, err
+		}
+		var fields StateFields
+		if err := json.Unmarshal(pdu.Data, &fields); err != nil {
+			return GameState{}, err
+		}
+		delete(fields, "seq")
+		t.fields, t.seq = fields, full.Seq
+	case "state_delta":
+		var delta StateDelta
+		if err := json.Unmarshal(pdu.Data, &delta); err != nil {
+			return GameState{}, err
+		}
+		if t.fields == nil {
+			return GameState{}, ErrResyncPending
+		}
+		if delta.Seq != t.seq+1 {
+			t.fields = nil // ignore deltas until the resync arrives
+			return GameState{}, ErrStateGap
+		}
+		for key, value := range delta.Changes {
+			t.fields[key] = value
+		}
+		for key, elems := range delta.Elements {
+			if err := t.applyElements(key, elems); err != nil {
+				t.fields = nil // the state no longer matches the server's
+				return GameState{}, fmt.Errorf("%w: %v", ErrStateGap, err)
+			}
+		}
+		t.seq = delta.Seq
+	default:
+		return GameState{}, fmt.Errorf("not a state PDU: %s", pdu.Type)
+	}
+	return t.State()
+}
+
+// applyElements replaces elements of the tracked list field key
+func (t *StateTracker) applyElements(key string, elems ElementChanges) error {
+	var list []json.RawMessage
+	if err := json.Unmarshal(t.fields[key], &list); err != nil {
+		return fmt.Errorf("%s: %w", key, err)
+	}
+	for i, value := range elems {
+		if i < 0 || i >= len(list) {
+			return fmt.Errorf("%s: element %d of %d", key, i, len(list))
+		}
+		list[i] = value
+	}
+	data, err := json.Marshal(list)
+	if err != nil {
+		return err
+	}
+	t.fields[key] = data
+	return nil
+}
+
+// State returns the tracked state
+func (t *StateTracker) State() (GameState, error) {
+	var state GameState
+	data, err := json.Marshal(t.fields)
+	if err != nil {
+		return state, err
+	}
+	err = json.Unmarshal(data, &state)
+	return state, err
+}
@@ -0,0 +1,74 @@
+// events.go
+// Combat events: what happened during a tick, sent to players in one
+// combat_events PDU per tick so the client can explain HP changes
+
+package server
+
+import "encoding/json"
+
+// Combat event types
+const (
+	EventTroopDeployed  = "troop_deployed"
+	EventTroopAttack    = "troop_attack" // a troop hit a tower
+	EventTowerAttack    = "tower_attack" // a tower hit a troop
+	EventTowerDestroyed = "tower_destroyed"
+	EventTroopKilled    = "troop_killed"
+	EventHeal           = "heal"
+	EventManaUpdate     = "mana_update"
+	EventExpUpdate      = "exp_update"
+)
+
+// CombatEvent is one engine event. Player is the index of the side that
+// acted: the deployer, the attacker, the healer or the side gaining EXP.
+type CombatEvent struct {
+	Type     string `json:"type"`
+	Player   int    `json:"player"`
+	Attacker string `json:"attacker,omitempty"` // troop or tower that acted
+	Target   string `json:"target,omitempty"`   // troop or tower acted on
+	Damage   int    `json:"damage,omitempty"`
+	Crit     bool   `json:"crit,omitempty"`
+	Amount   int    `json:"amount,omitempty"` // HP healed, mana spent or EXP gained
+	Health   int    `json:"health"`           // target's HP afterwards
+	Mana     int    `json:"mana"`             // mana left, for mana_update
+	Exp      int    `json:"exp,omitempty"`    // EXP total, for exp_update
+}
+
+// CombatEvents is the payload of a combat_events PDU
+type CombatEvents struct {
+	Tick   int           `json:"tick"`
+	Events []CombatEvent `json:"events"`
+}
+
+// emit queues an event for this tick's batch; callers hold mutex
+func (gs *GameSession) emit(ev CombatEvent) {
+	gs.events = append(gs.events, ev)
+}
+
+// takeEvents returns and clears the queued events
+func (gs *GameSession) takeEvents() []CombatEvent {
+	events := gs.events
+	gs.events = nil
+	return events
+}
+
+// sendEvents sends a batch to both players and reports whether every send
+// succeeded
+func (gs *GameSession) sendEvents(events []CombatEvent) bool {
+	if len(events) == 0 {
+		return true
+	}
+	data, err := json.Marshal(CombatEvents{Tick: gs.ticks, Events: events})
+	if err != nil {
+		return true
+	}
+	for i, p := range gs.Players {
+		if p.Conn == nil {
+			continue
+		}
+		if err := SendPDU(p.Conn, PDU{Type: "combat_events", Data: data}); err != nil {
+			gs.disconnect(i, err)
+			return false
+		}
+	}
+	return true
+}
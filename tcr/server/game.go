@@ -6,10 +6,11 @@ package server
 import (
 	"encoding/json"
 	"fmt"
-	"log"
+	"log/slog"
 	"math/rand"
 	"net"
 	"sync"
+	"sync/atomic"
 	"tcr/specs"
 	"time"
 )
@@ -35,26 +36,80 @@ type Level struct {
 // }
 
 type GameSession struct {
+	ID                 string
 	Users              map[string]User
+	UserFile           string     // where Users is saved, "" to keep it in memory
 	Players            [2]*Player // two players
 	TroopSpecs         map[string]specs.TroopSpec
 	TowerSpecs         map[string]specs.TowerSpec
 	Commands           chan DeployCmd // incoming deploy commands
 	Done               chan struct{}  // signals end of game
-	TickInterval       time.Duration  // for enhanced mode
-	justDestroyedTower bool           // tracks if a tower was just destroyed
+	stop               chan struct{}  // closed by Stop to end the game early
+	stopOnce           sync.Once
+	stopReason         string
+	TickInterval       time.Duration   // for enhanced mode
+	Rules              specs.Rules     // mana economy and match timing
+	Mode               GameMode        // deploy permissions and end conditions
+	Clock              Clock           // paces ticks in the live loop
+	Seed               int64           // seed of Rand, enough to reproduce the match
+	Rand               *rand.Rand      // all randomness in the simulation comes from here
+	Recorder           *ReplayRecorder // optional replay output
+	spectators         []*Spectator
+	specMutex          sync.Mutex    // protects spectators
+	justDestroyedTower bool          // tracks if a tower was just destroyed
+	ticks              int           // ticks elapsed since the match started
+	overtime           bool          // sudden-death overtime after a tied timer
+	started            bool          // mode has been started
+	dropped            bool          // a player connection failed
+	events             []CombatEvent // queued for this tick's combat_events
+	lastState          StateFields   // what the players were last sent
+	stateSeq           int           // sequence number of the last state update
+
+	// End of the match and the rematch offer, see rematch.go
+	ended   atomic.Bool                 // set before game_end is sent
+	votes   chan rematchVote            // answers to the rematch offer, nil to make none
+	rematch bool                        // game_end offered a rematch
+	next    atomic.Pointer[GameSession] // the rematch, once both accepted
+
+	// Headless runs, see sim.go
+	lock        sync.Locker                    // held by ticks and deploys: mutex, or the session's own
+	botDeployed func(player int, troop string) // called for each bot deploy, if set
 }
 
 type TroopInstance struct {
-	Spec   specs.TroopSpec
-	Health int
+	Spec       specs.TroopSpec
+	Health     int
+	nextAction int // tick of the troop's next attack or heal
 	// Possibly: Position, OwnerIndex, SpawnTime, etc.
 }
 
+// TroopActionInterval is the game time between a troop's attacks or heals
+const TroopActionInterval = 2 * time.Second
+
 // DeployCmd is issued by a client or AI to deploy a troop
 type DeployCmd struct {
 	PlayerIndex int    // 0 or 1
 	TroopName   string // e.g., "Pawn"
+	Tick        int    // tick the command was applied after, stamped by Deploy
+	Forfeit     string // EndSurrender or EndDisconnect: the player gives up instead
+}
+
+// Reasons a match ended, sent in game_end
+const (
+	EndKingTower  = "king_tower" // a King Tower fell
+	EndTower      = "tower"      // the first tower fell in quick mode
+	EndTime       = "time"       // the timer ran out
+	EndOvertime   = "overtime"   // sudden-death overtime was decided or expired
+	EndSurrender  = "surrender"  // a player gave up
+	EndDisconnect = "disconnect" // a player's connection failed
+)
+
+// GameEnd is the payload of game_end
+type GameEnd struct {
+	Result  string `json:"result"` // win, loss, draw or aborted
+	Reason  string `json:"reason"` // one of the End reasons, or why the match was aborted
+	Exp     int    `json:"exp"`
+	Rematch bool   `json:"rematch,omitempty"` // a rematch PDU now starts a new match
 }
 
 // GameState represents the current state of the game
@@ -63,36 +118,20 @@ type GameState struct {
 	OpponentMana  int               `json:"opponent_mana"`
 	Player1Towers []specs.TowerSpec `json:"your_towers"`
 	Player2Towers []specs.TowerSpec `json:"opponent_towers"`
+	Phase         string            `json:"phase"`
+	TimeLeft      int               `json:"time_left"` // seconds until regulation or overtime ends
+	Mode          string            `json:"mode"`
+	Turn          int               `json:"turn"` // player index to move in turn-based mode, -1 otherwise
+	ActionsLeft   int               `json:"actions_left"`
 }
 
-// startGame launches the appropriate game loop based on mode
+// StartGame reads the players' commands and runs the match
 func (gs *GameSession) StartGame() {
 	for i, player := range gs.Players {
-		go func(index int, conn net.Conn) {
-			for {
-				pdu, err := ReceivePDU(conn)
-				if err != nil {
-					log.Printf("Error receiving PDU: %v", err)
-					return
-				}
-
-				switch pdu.Type {
-				case "deploy":
-					var payload struct {
-						Troop string `json:"troop"`
-					}
-					if err := json.Unmarshal(pdu.Data, &payload); err != nil {
-						log.Println("Invalid deploy payload:", err)
-						continue
-					}
-
-					gs.Commands <- DeployCmd{
-						PlayerIndex: index,
-						TroopName:   payload.Troop,
-					}
-				}
-			}
-		}(i, player.Conn)
+		if player.Conn == nil {
+			continue // bots act from the loop
+		}
+		go gs.readCommands(i, player.Conn)
 	}
 
 	// Start game loop
@@ -100,38 +139,97 @@ func (gs *GameSession) StartGame() {
 
 }
 
-// enhancedLoop runs real-time gameplay with mana regen and timeout
+// readCommands handles a player's PDUs until the connection closes. After a
+// rematch they go to the new session, which keeps the player's index.
+func (gs *GameSession) readCommands(index int, conn net.Conn) {
+	for {
+		pdu, err := ReceivePDU(conn)
+		gs = gs.latest()
+		plog := gs.logger().With("user", gs.Players[index].Username)
+		if err != nil {
+			plog.Info("player connection closed", "err", err)
+			// The match may end before the loop reads the forfeit, so the
+			// rematch offer hears of it too
+			gs.voteRematch(rematchVote{player: index, reason: EndDisconnect})
+			if !gs.ended.Load() {
+				gs.Commands <- DeployCmd{PlayerIndex: index, Forfeit: EndDisconnect}
+			}
+			return
+		}
+		plog.Debug("pdu received", "pdu", pdu.Type)
+
+		switch pdu.Type {
+		case "resync":
+			// The client missed a delta; the next tick sends everything
+			gs.Players[index].resync.Store(true)
+		case "udp_fallback":
+			// Snapshots stopped reaching the client; back to TCP
+			gs.Players[index].udp.drop()
+			plog.Info("udp fallback")
+		case "chat":
+			var payload ChatMessage
+			if err := json.Unmarshal(pdu.Data, &payload); err != nil {
+				plog.Warn("bad pdu payload", "pdu", pdu.Type, "err", err)
+				continue
+			}
+			gs.relayChat(index, payload.Message)
+		case "surrender":
+			if !gs.ended.Load() {
+				plog.Info("player surrendered")
+				gs.Commands <- DeployCmd{PlayerIndex: index, Forfeit: EndSurrender}
+			}
+		case "rematch":
+			var payload struct {
+				Accept bool `json:"accept"`
+			}
+			if err := json.Unmarshal(pdu.Data, &payload); err != nil {
+				plog.Warn("bad pdu payload", "pdu", pdu.Type, "err", err)
+				continue
+			}
+			if gs.ended.Load() {
+				gs.voteRematch(rematchVote{player: index, accept: payload.Accept, reason: "declined"})
+			}
+		case "deploy":
+			var payload struct {
+				Troop string `json:"troop"` // a lane may come too; the arena has one
+			}
+			if err := json.Unmarshal(pdu.Data, &payload); err != nil {
+				plog.Warn("bad pdu payload", "pdu", pdu.Type, "err", err)
+				continue
+			}
+			if gs.ended.Load() {
+				continue // nothing reads Commands any more
+			}
+			gs.Commands <- DeployCmd{
+				PlayerIndex: index,
+				TroopName:   payload.Troop,
+			}
+		}
+	}
+}
+
+// enhancedLoop runs real-time gameplay, pacing logical ticks with the clock
 func (gs *GameSession) enhancedLoop() {
-	ticker := time.NewTicker(gs.TickInterval)
-	timeout := time.After(3 * time.Minute) // 3-minute match timer
+	ticker := gs.Clock.NewTicker(gs.TickInterval)
 	defer ticker.Stop()
-	defer gs.Players[0].Conn.Close()
-	defer gs.Players[1].Conn.Close()
 	for {
 		select {
-		case <-ticker.C:
-			gs.tick() // regen mana, tower attacks, send state
-
-			// 🔽 Check if game has ended after tick
-			if gs.checkGameEnd() {
-				gs.evaluateWinner()
-				close(gs.Done)
+		case <-ticker.C():
+			// 🔽 Check if game has ended after tick or the bots' moves
+			if gs.Advance() || gs.RunBots() {
+				gs.finish()
 				return
 			}
 
 		case cmd := <-gs.Commands:
-			gs.handleDeploy(cmd)
-
 			// 🔽 Check if game has ended after deploy
-			if gs.checkGameEnd() {
-				gs.evaluateWinner()
-				close(gs.Done)
+			if gs.Deploy(cmd) {
+				gs.finish()
 				return
 			}
 
-		case <-timeout:
-			gs.evaluateWinner()
-			close(gs.Done)
+		case <-gs.stop:
+			gs.abort()
 			return
 
 		case <-gs.Done:
@@ -140,15 +238,142 @@ func (gs *GameSession) enhancedLoop() {
 	}
 }
 
-// tick handles periodic updates: mana regen and optional tower attacks
-func (gs *GameSession) tick() {
+// Advance runs one logical tick (mana regen, tower attacks, troop actions,
+// state broadcast) and reports whether the match is over. Together with
+// Deploy it is the whole simulation: the same seed, specs and commands at
+// the same ticks always produce the same match.
+func (gs *GameSession) Advance() bool {
+	gs.start()
+	gs.tick()
+	if gs.ticks%checksumEvery == 0 {
+		gs.record(ReplayEvent{Tick: gs.ticks, Checksum: gs.checksum()})
+	}
+	return gs.dropped || gs.Mode.Over(gs)
+}
+
+// Deploy applies a command between two ticks and reports whether the match
+// is over
+func (gs *GameSession) Deploy(cmd DeployCmd) bool {
+	gs.start()
+	cmd.Tick = gs.ticks
+	if cmd.Forfeit != "" {
+		gs.record(ReplayEvent{Tick: cmd.Tick, Player: cmd.PlayerIndex, Forfeit: cmd.Forfeit})
+		gs.Players[cmd.PlayerIndex].forfeit = cmd.Forfeit
+		return true
+	}
+	if cmd.TroopName != "" {
+		gs.record(ReplayEvent{Tick: cmd.Tick, Player: cmd.PlayerIndex, Troop: cmd.TroopName})
+	}
+	gs.handleDeploy(cmd)
+	return gs.dropped || gs.Mode.Over(gs)
+}
+
+// SetSeed reseeds the session's random source
+func (gs *GameSession) SetSeed(seed int64) {
+	gs.Seed = seed
+	gs.Rand = rand.New(rand.NewSource(seed))
+}
+
+// start starts the mode before the first tick or deploy
+func (gs *GameSession) start() {
+	if !gs.started {
+		gs.started = true
+		gs.Mode.Start(gs)
+	}
+}
+
+// finish settles the match, closes the replay and signals the end
+func (gs *GameSession) finish() {
+	gs.ended.Store(true)
+	result := "decided"
+	if gs.forfeiter() == nil && gs.Players[0].TowersAlive() == gs.Players[1].TowersAlive() {
+		result = "draw"
+	}
+	metrics.matchEnded(gs.Mode.Name(), result, gs.elapsed())
+	// A deploy that ends the match has events no tick has sent yet
 	mutex.Lock()
-	defer mutex.Unlock()
+	gs.sendEvents(gs.takeEvents())
+	mutex.Unlock()
+	gs.evaluateWinner()
+	gs.closeReplay()
+	close(gs.Done)
+}
 
-	for i, p := range gs.Players {
-		if p.Mana < 10 {
-			p.Mana++
+// Stop ends a live match early without a result. It is safe to call more
+// than once and from any goroutine.
+func (gs *GameSession) Stop(reason string) {
+	gs.stopOnce.Do(func() {
+		gs.stopReason = reason
+		close(gs.stop)
+	})
+}
+
+// abort ends a stopped match: no EXP is awarded and both players are
+// released for their next login
+func (gs *GameSession) abort() {
+	gs.ended.Store(true)
+	metrics.matchEnded(gs.Mode.Name(), "aborted", gs.elapsed())
+	mutex.Lock()
+	gs.logger().Info("session stopped", "reason", gs.stopReason)
+	data, _ := json.Marshal(GameEnd{Result: "aborted", Reason: gs.stopReason})
+	for _, p := range gs.Players {
+		gs.logout(p)
+		if p.Conn != nil {
+			SendPDU(p.Conn, PDU{Type: "game_end", Data: data})
 		}
+	}
+	mutex.Unlock()
+
+	gs.closeReplay()
+	close(gs.Done)
+}
+
+// logout releases a human player's account for the next login
+func (gs *GameSession) logout(p *Player) {
+	if gs.Users == nil || p.Bot != nil {
+		return
+	}
+	user := gs.Users[p.Username] // Get a copy of the struct
+	user.isLogin = false         // Modify the field
+	gs.Users[p.Username] = user  // Store it back in the map
+}
+
+// closeReplay writes the end marker and closes the replay, if recording
+func (gs *GameSession) closeReplay() {
+	if gs.Recorder == nil {
+		return
+	}
+	gs.record(ReplayEvent{Tick: gs.ticks, End: true})
+	if err := gs.Recorder.Close(); err != nil {
+		gs.logger().Error("close replay failed", "err", err)
+	}
+	gs.Recorder = nil
+}
+
+// record appends an event to the replay, if one is being recorded
+func (gs *GameSession) record(ev ReplayEvent) {
+	if gs.Recorder == nil {
+		return
+	}
+	if err := gs.Recorder.Record(ev); err != nil {
+		gs.logger().Error("record replay failed", "err", err)
+	}
+}
+
+// ticksFor converts game time to a whole number of ticks, at least one
+func (gs *GameSession) ticksFor(d time.Duration) int {
+	return max(int(d/gs.TickInterval), 1)
+}
+
+// tick handles periodic updates: mana regen, tower attacks and troop actions
+func (gs *GameSession) tick() {
+	gs.lock.Lock()
+	defer gs.lock.Unlock()
+
+	gs.ticks++
+	regen := gs.manaRegen()
+	for i, p := range gs.Players {
+		p.Mana = min(p.Mana+regen, gs.Rules.ManaCap)
 
 		// Each tower attacks one troop (if any)
 		for _, tower := range p.Towers {
@@ -166,10 +391,10 @@ func (gs *GameSession) tick() {
 			// Calculate critical hit
 			var isCrit bool
 			if tower.Type == "king" {
-				isCrit = rand.Float64() < 0.1 // 10% crit chance
+				isCrit = gs.Rand.Float64() < 0.1 // 10% crit chance
 			}
 			if tower.Type == "guard" {
-				isCrit = rand.Float64() < 0.05 // 5% crit chance
+				isCrit = gs.Rand.Float64() < 0.05 // 5% crit chance
 			}
 			if isCrit {
 				baseATK *= 1.2 // 20% more damage on crit
@@ -178,7 +403,11 @@ func (gs *GameSession) tick() {
 			// Calculate final damage
 			dmg := max(int(baseATK)-target.Spec.Defence, 0)
 			target.Health -= dmg
+			gs.emit(CombatEvent{Type: EventTowerAttack, Player: i, Attacker: tower.Name,
+				Target: target.Spec.Name, Damage: dmg, Crit: isCrit, Health: max(target.Health, 0)})
 			if target.Health <= 0 {
+				gs.emit(CombatEvent{Type: EventTroopKilled, Player: i, Attacker: tower.Name, Target: target.Spec.Name})
+				expBefore := p.Level.Exp
 				if target.Spec.Name == "Pawn" {
 					p.Level.Exp += 5
 				} else if target.Spec.Name == "Bishop" {
@@ -198,37 +427,117 @@ func (gs *GameSession) tick() {
 				} else if target.Spec.Name == "Minion" {
 					p.Level.Exp += 10
 				}
-				gs.checkLevelUp(p, gs.Users, userFilePath)
+				if gained := p.Level.Exp - expBefore; gained > 0 {
+					gs.emit(CombatEvent{Type: EventExpUpdate, Player: i, Amount: gained, Exp: p.Level.Exp})
+				}
+				gs.checkLevelUp(p, gs.Users, gs.UserFile)
 				opponent.ActiveTroops = opponent.ActiveTroops[1:]
-				log.Println("exp: ", p.Level.Exp)
-				log.Println("Troop die, active list: ", opponent.ActiveTroops)
+				gs.logger().Debug("troop died", "troop", target.Spec.Name, "killer", p.Username,
+					"exp", p.Level.Exp, "troops_left", len(opponent.ActiveTroops))
+			}
+		}
+	}
+
+	// Surviving troops attack or heal on their own cadence
+	for i, p := range gs.Players {
+		for _, troop := range p.ActiveTroops {
+			if gs.ticks >= troop.nextAction {
+				gs.troopAct(i, troop)
 			}
 		}
 	}
+
+	gs.Mode.OnTick(gs)
 	gs.broadcastState()
 }
 
+// logger returns the default logger with the match ID attached
+func (gs *GameSession) logger() *slog.Logger {
+	return slog.With("match", gs.ID)
+}
+
+// elapsed returns the match time played so far
+func (gs *GameSession) elapsed() time.Duration {
+	return time.Duration(gs.ticks) * gs.TickInterval
+}
+
+// manaRegen returns the mana each player gains this tick, scaled by the
+// active mana phase
+func (gs *GameSession) manaRegen() int {
+	regen := gs.Rules.ManaRegen
+	if phase := gs.Rules.Phase(int(gs.elapsed().Seconds())); phase != nil {
+		regen *= phase.ManaMultiplier
+	}
+	return regen
+}
+
+// timeLeft returns the time until regulation, or overtime once it started, ends
+func (gs *GameSession) timeLeft() time.Duration {
+	end := time.Duration(gs.Rules.MatchDurationSec) * time.Second
+	if gs.overtime {
+		end += time.Duration(gs.Rules.OvertimeSec) * time.Second
+	}
+	return max(end-gs.elapsed(), 0)
+}
+
+// timeUp reports whether the match timer has run out. Tied towers at the end
+// of regulation start sudden-death overtime, which ends on the first tower
+// lead or when the overtime timer expires.
+func (gs *GameSession) timeUp() bool {
+	if gs.timeLeft() > 0 {
+		if gs.overtime {
+			return gs.Players[0].TowersAlive() != gs.Players[1].TowersAlive()
+		}
+		return false
+	}
+	if gs.overtime || gs.Rules.OvertimeSec == 0 ||
+		gs.Players[0].TowersAlive() != gs.Players[1].TowersAlive() {
+		return true
+	}
+
+	// info reads overtime from other goroutines
+	gs.lock.Lock()
+	defer gs.lock.Unlock()
+	gs.overtime = true
+	gs.logger().Info("towers tied, entering sudden-death overtime")
+	for _, p := range gs.Players {
+		if p.Conn != nil {
+			SendPDU(p.Conn, PDU{
+				Type: "overtime",
+				Data: []byte(fmt.Sprintf(`{"duration":%d}`, gs.Rules.OvertimeSec)),
+			})
+		}
+	}
+	return false
+}
+
 // handleDeploy processes a DeployCmd, checking mana and applying troop effects
 func (gs *GameSession) handleDeploy(cmd DeployCmd) {
-	mutex.Lock()
-	defer mutex.Unlock()
+	gs.lock.Lock()
+	defer gs.lock.Unlock()
 	//Take the player
 	p := gs.Players[cmd.PlayerIndex]
+	if !gs.Mode.CanDeploy(gs, cmd.PlayerIndex) {
+		gs.logger().Debug("deploy rejected", "user", p.Username, "reason", rejectCannotDeploy)
+		metrics.rejectDeploy(rejectCannotDeploy)
+		return
+	}
 
 	spec, ok := gs.TroopSpecs[cmd.TroopName] // stats lookup
-	// log.Println("Spec to deploy: ", spec)
 	if !ok || p.Mana < spec.Cost {
+		reason := rejectNoMana
 		if !ok {
-			log.Println("Troop name: ", cmd.TroopName)
-			log.Println("gs.Troop name: ", gs.TroopSpecs)
-			log.Println("spec not find")
-		} else {
-			log.Println("Mana insufficient")
+			reason = rejectUnknownTroop
 		}
+		gs.logger().Debug("deploy rejected", "user", p.Username, "troop", cmd.TroopName, "reason", reason)
+		metrics.rejectDeploy(reason)
 		return // invalid or insufficient mana
 	}
 	p.Mana -= spec.Cost
-	log.Println("Current mana: ", p.Mana)
+	gs.emit(CombatEvent{Type: EventTroopDeployed, Player: cmd.PlayerIndex, Attacker: spec.Name, Amount: spec.Cost})
+	gs.emit(CombatEvent{Type: EventManaUpdate, Player: cmd.PlayerIndex, Mana: p.Mana})
+	gs.logger().Debug("troop deployed", "user", p.Username, "troop", cmd.TroopName, "mana", p.Mana)
+	gs.Mode.OnDeploy(gs, cmd.PlayerIndex)
 
 	// apply troop action: attack or heal
 	troop := &TroopInstance{
@@ -238,36 +547,23 @@ func (gs *GameSession) handleDeploy(cmd DeployCmd) {
 	}
 	p.ActiveTroops = append(p.ActiveTroops, troop)
 
-	if cmd.TroopName == "queen" {
-		go func(troop *TroopInstance, playerIdx int) {
-			for {
-				mutex.Lock()
-				if troop.Health < 0 {
-					mutex.Unlock()
-					log.Printf("Troop %s is dead, stopping attack loop\n", troop.Spec.Name)
-					break
-				}
-				p.HealWeakestTower(int(300 * p.Level.Multiplier))
-				mutex.Unlock()
-				time.Sleep(2 * time.Second)
-			}
-		}(troop, cmd.PlayerIndex)
-	}
-	if cmd.TroopName != "queen" {
-		go func(troop *TroopInstance, playerIdx int) {
-			for {
-				mutex.Lock()
-				if troop.Health <= 0 {
-					mutex.Unlock()
-					log.Printf("Troop %s is dead, stopping attack loop\n", troop.Spec.Name)
-					break
-				}
-				gs.attackOpponentTowerFromTroop(playerIdx, troop)
-				mutex.Unlock()
-				time.Sleep(2 * time.Second)
-			}
-		}(troop, cmd.PlayerIndex)
+	// A fresh troop acts right away, then every TroopActionInterval
+	gs.troopAct(cmd.PlayerIndex, troop)
+}
+
+// troopAct runs a troop's periodic action: healers heal, every other troop
+// attacks the opponent's next tower
+func (gs *GameSession) troopAct(playerIdx int, troop *TroopInstance) {
+	if troop.Spec.Heals() {
+		p := gs.Players[playerIdx]
+		if tower, healed := p.HealWeakestTower(int(float64(troop.Spec.Ability.Amount) * p.Level.Multiplier)); tower != nil {
+			gs.emit(CombatEvent{Type: EventHeal, Player: playerIdx, Attacker: troop.Spec.Name,
+				Target: tower.Name, Amount: healed, Health: tower.Health})
+		}
+	} else {
+		gs.attackOpponentTowerFromTroop(playerIdx, troop)
 	}
+	troop.nextAction = gs.ticks + gs.ticksFor(TroopActionInterval)
 }
 
 func (gs *GameSession) attackOpponentTowerFromTroop(playerIdx int, troop *TroopInstance) {
@@ -280,16 +576,20 @@ func (gs *GameSession) attackOpponentTowerFromTroop(playerIdx int, troop *TroopI
 	}
 
 	baseATK := float64(troop.Spec.Damage) * player.Level.Multiplier
-	if rand.Float64() < 0.1 {
+	isCrit := gs.Rand.Float64() < 0.1
+	if isCrit {
 		baseATK *= 1.2
 	}
 	dmg := max(int(baseATK)-target.Defence, 0)
 	target.Health -= dmg
+	gs.emit(CombatEvent{Type: EventTroopAttack, Player: playerIdx, Attacker: troop.Spec.Name,
+		Target: target.Name, Damage: dmg, Crit: isCrit, Health: max(target.Health, 0)})
 
-	log.Printf("Troop %s attacked tower %s for %d damage\n", troop.Spec.Name, target.Name, dmg)
+	gs.logger().Debug("tower hit", "troop", troop.Spec.Name, "tower", target.Name, "damage", dmg)
 
 	if target.Health <= 0 {
 		opponent.DestroyTower(target)
+		gs.emit(CombatEvent{Type: EventTowerDestroyed, Player: playerIdx, Attacker: troop.Spec.Name, Target: target.Name})
 		gs.justDestroyedTower = true
 		gs.awardExp(playerIdx, target)
 	} else {
@@ -312,7 +612,10 @@ func (gs *GameSession) awardExp(playerIdx int, tower *specs.TowerSpec) {
 
 	// Add EXP and check for level up
 	player.Level.Exp += expGain
-	gs.checkLevelUp(player, gs.Users, userFilePath)
+	if expGain > 0 {
+		gs.emit(CombatEvent{Type: EventExpUpdate, Player: playerIdx, Amount: expGain, Exp: player.Level.Exp})
+	}
+	gs.checkLevelUp(player, gs.Users, gs.UserFile)
 }
 
 // checkLevelUp handles player level progression
@@ -323,19 +626,6 @@ func (gs *GameSession) checkLevelUp(player *Player, users map[string]User, userF
 		player.Level.NextLevel = int(float64(player.Level.NextLevel) * 1.1)
 		player.Level.Multiplier = 1.0 + (float64(player.Level.Level) * 0.1) - 0.1
 
-		// Update users map
-		user := users[player.Username]
-		user.Level = player.Level.Level
-		user.Exp = player.Level.Exp
-		user.NextLevel = player.Level.NextLevel
-		user.Multiplier = player.Level.Multiplier
-		users[player.Username] = user
-
-		// Save to JSON file
-		if err := saveUsers(userFilePath, users); err != nil {
-			log.Println("Failed to save updated user level:", err)
-		}
-
 		// Notify client of level up
 		if player.Conn != nil {
 			levelData := fmt.Sprintf(`{"Your level":%d,"exp":%d,"next_level":%d,"multiplier":%.2f}`,
@@ -345,28 +635,119 @@ func (gs *GameSession) checkLevelUp(player *Player, users map[string]User, userF
 				Data: []byte(levelData),
 			})
 		}
-	} else {
-		// Update users map
-		user := users[player.Username]
-		user.Level = player.Level.Level
-		user.Exp = player.Level.Exp
-		user.NextLevel = player.Level.NextLevel
-		user.Multiplier = player.Level.Multiplier
-		users[player.Username] = user
-		// Save to JSON file
-		if err := saveUsers(userFilePath, users); err != nil {
-			log.Println("Failed to save updated user level:", err)
-		}
+	}
+
+	// Headless sessions (replay playback) and bots have no accounts to update
+	if users == nil || player.Bot != nil {
+		return
+	}
+
+	// Update users map
+	user := users[player.Username]
+	user.Level = player.Level.Level
+	user.Exp = player.Level.Exp
+	user.NextLevel = player.Level.NextLevel
+	user.Multiplier = player.Level.Multiplier
+	users[player.Username] = user
+
+	// Save to JSON file
+	if err := saveUsers(userFilePath, users); err != nil {
+		gs.logger().Error("save users failed", "user", player.Username, "err", err)
 	}
 }
 
-// broadcastState would serialize and send STATE_UPDATE to clients
+// broadcastState serializes and sends STATE_UPDATE to clients
 func (gs *GameSession) broadcastState() {
+	// Events first, so clients can tell why the state changed
+	events := gs.takeEvents()
+	if !gs.sendEvents(events) {
+		return
+	}
+
+	// Full state on the first tick, then only the changed fields
+	fields, err := stateFields(gs.snapshot())
+	if err != nil {
+		return
+	}
+	first := gs.lastState == nil
+	diff := diffState(gs.lastState, fields)
+	if !diff.empty() {
+		gs.stateSeq++
+	}
+	gs.lastState = fields
+
+	var full, delta []byte
+	for i, p := range gs.Players {
+		if p.Conn == nil {
+			continue
+		}
+		// UDP gets a full snapshot every tick, since any of them may be lost
+		if p.udp.live() {
+			if full == nil {
+				full, _ = fullState(fields, gs.stateSeq)
+			}
+			if err := p.udp.send(PDU{Type: "state_update", Data: full}); err == nil {
+				p.onUDP = true
+				continue
+			}
+		}
+		if p.onUDP {
+			// The channel went quiet; TCP starts over with a full update
+			p.onUDP = false
+			p.resync.Store(true)
+		}
+		pdu := PDU{Type: "state_delta"}
+		switch {
+		case first || p.resync.Swap(false):
+			if full == nil {
+				full, _ = fullState(fields, gs.stateSeq)
+			}
+			pdu = PDU{Type: "state_update", Data: full}
+		case diff.empty():
+			continue // nothing new this tick
+		default:
+			if delta == nil {
+				diff.Seq = gs.stateSeq
+				delta, _ = json.Marshal(diff)
+			}
+			pdu.Data = delta
+		}
+		if err := SendPDU(p.Conn, pdu); err != nil {
+			gs.disconnect(i, err)
+			return
+		}
+	}
+	gs.publishView(events)
+}
+
+// disconnect forfeits the match for a player whose connection failed, and
+// records it like a surrender so replays end the same way. The loop settles
+// the match once the tick returns; after the end it changes nothing.
+func (gs *GameSession) disconnect(index int, err error) {
+	p := gs.Players[index]
+	gs.logger().Info("player disconnected", "user", p.Username, "err", err)
+	if gs.ended.Load() || p.forfeit != "" {
+		return
+	}
+	gs.record(ReplayEvent{Tick: gs.ticks, Player: index, Forfeit: EndDisconnect})
+	p.forfeit = EndDisconnect
+	gs.dropped = true
+}
+
+// snapshot builds the state update for the current tick
+func (gs *GameSession) snapshot() GameState {
 	state := GameState{
 		YourMana:      gs.Players[0].Mana,
 		OpponentMana:  gs.Players[1].Mana,
 		Player1Towers: make([]specs.TowerSpec, 0),
 		Player2Towers: make([]specs.TowerSpec, 0),
+		TimeLeft:      int(gs.timeLeft().Seconds()),
+		Mode:          gs.Mode.Name(),
+		Turn:          -1,
+	}
+	gs.Mode.Describe(&state)
+	if phase := gs.Rules.Phase(int(gs.elapsed().Seconds())); phase != nil {
+		state.Phase = phase.Name
 	}
 
 	// Add player 0's towers
@@ -395,30 +776,11 @@ func (gs *GameSession) broadcastState() {
 		}
 	}
 
-	// Serialize and send state update
-	data, err := json.Marshal(state)
-	if err != nil {
-		return
-	}
-
-	// Send to both players
-	for _, p := range gs.Players {
-		if p.Conn != nil {
-			err := SendPDU(p.Conn, PDU{
-				Type: "state_update",
-				Data: data,
-			})
-			if err != nil {
-				log.Printf("Player %s disconnected: %v\n", p.Username, err)
-				gs.evaluateWinner()
-				close(gs.Done)
-				return
-			}
-		}
-	}
+	return state
 }
 
-// checkGameEnd returns true if a King Tower is destroyed
+// checkGameEnd returns true if a King Tower is destroyed; modes build their
+// end conditions on top of it
 func (gs *GameSession) checkGameEnd() bool {
 	for _, p := range gs.Players {
 		if p.KingTowerDestroyed() {
@@ -428,89 +790,110 @@ func (gs *GameSession) checkGameEnd() bool {
 	return false
 }
 
-// evaluateWinner compares towers on timeout and assigns EXP
+// evaluateWinner compares towers on timeout and assigns EXP. A player who
+// forfeited loses whatever the towers say. A draw is only reached once
+// sudden-death overtime has expired.
 func (gs *GameSession) evaluateWinner() {
 	mutex.Lock()
 	defer mutex.Unlock()
 
-	// Count remaining towers for each player
-	towers0 := 0
-	towers1 := 0
-	for _, t := range gs.Players[0].Towers {
-		if t.Health > 0 {
-			towers0++
-		}
-	}
-	for _, t := range gs.Players[1].Towers {
-		if t.Health > 0 {
-			towers1++
-		}
-	}
+	// Accounts stay logged in while a rematch is on offer
+	gs.rematch = gs.votes != nil && !gs.left()
+	reason := gs.endReason()
 
 	// Determine winner and assign EXP
-	var winner, loser *Player
-	if towers0 > towers1 {
-		winner = gs.Players[0]
-		loser = gs.Players[1]
-	} else if towers1 > towers0 {
-		winner = gs.Players[1]
-		loser = gs.Players[0]
-	} else {
+	w := gs.winner()
+	if w < 0 {
 		// Draw - both get small EXP
 		for _, p := range gs.Players {
-			p.Level.Exp += 10
-			gs.checkLevelUp(p, gs.Users, userFilePath)
-			user := gs.Users[p.Username] // Get a copy of the struct
-			user.isLogin = false         // Modify the field
-			gs.Users[p.Username] = user  // Store it back in the map
-			if p.Conn != nil {
-				SendPDU(p.Conn, PDU{
-					Type: "game_end",
-					Data: json.RawMessage(`{"result":"draw","exp":10}`),
-				})
-			}
+			gs.award(p, GameEnd{Result: "draw", Reason: reason, Exp: 10})
 		}
 		return
 	}
 
 	// Winner gets more EXP
-	if winner.Conn != nil {
-		winner.Level.Exp += 30
-		user := gs.Users[winner.Username] // Get a copy of the struct
-		user.isLogin = false              // Modify the field
-		gs.Users[winner.Username] = user  // Store it back in the map
-		gs.checkLevelUp(winner, gs.Users, userFilePath)
-		SendPDU(winner.Conn, PDU{
-			Type: "game_end",
-			Data: json.RawMessage(`{"result":"win","exp":30}`),
-		})
-
-	}
-	if loser.Conn != nil {
-		loser.Level.Exp += 5
-		user := gs.Users[loser.Username] // Get a copy of the struct
-		user.isLogin = false             // Modify the field
-		gs.Users[loser.Username] = user  // Store it back in the map
-		gs.checkLevelUp(loser, gs.Users, userFilePath)
-		SendPDU(loser.Conn, PDU{
-			Type: "game_end",
-			Data: json.RawMessage(`{"result":"loss","exp":5}`),
-		})
-	}
-}
-
-// NewGameSession creates a new game session
-func NewGameSession(users map[string]User, players [2]*Player,
-	troopSpecs map[string]specs.TroopSpec,
-	towerSpecs map[string]specs.TowerSpec) *GameSession {
-
-	return &GameSession{
-		Users:        users,
+	gs.award(gs.Players[w], GameEnd{Result: "win", Reason: reason, Exp: 30})
+	gs.award(gs.Players[1-w], GameEnd{Result: "loss", Reason: reason, Exp: 5})
+}
+
+// winner is the index of the player who won the match, or -1 for a draw: a
+// player who forfeits loses, otherwise more standing towers wins
+func (gs *GameSession) winner() int {
+	towers0 := gs.Players[0].TowersAlive()
+	towers1 := gs.Players[1].TowersAlive()
+	switch {
+	case gs.Players[0].forfeit != "":
+		return 1
+	case gs.Players[1].forfeit != "":
+		return 0
+	case towers0 > towers1:
+		return 0
+	case towers1 > towers0:
+		return 1
+	}
+	return -1
+}
+
+// award adds a human player's EXP and sends game_end; callers hold mutex
+func (gs *GameSession) award(p *Player, end GameEnd) {
+	if p.Conn == nil {
+		return // bots earn nothing
+	}
+	p.Level.Exp += end.Exp
+	if !gs.rematch {
+		gs.logout(p)
+	}
+	gs.checkLevelUp(p, gs.Users, gs.UserFile)
+	end.Rematch = gs.rematch
+	data, _ := json.Marshal(end)
+	SendPDU(p.Conn, PDU{Type: "game_end", Data: data})
+}
+
+// forfeiter is the player who surrendered or disconnected, if any
+func (gs *GameSession) forfeiter() *Player {
+	for _, p := range gs.Players {
+		if p.forfeit != "" {
+			return p
+		}
+	}
+	return nil
+}
+
+// endReason says why a finished match ended
+func (gs *GameSession) endReason() string {
+	if p := gs.forfeiter(); p != nil {
+		return p.forfeit
+	}
+	switch {
+	case gs.checkGameEnd():
+		return EndKingTower
+	case gs.overtime:
+		return EndOvertime
+	case gs.timeLeft() <= 0:
+		return EndTime
+	default:
+		return EndTower
+	}
+}
+
+// NewGameSession creates a new game session with the user store, specs and
+// rules from opts. Headless sessions leave opts.Users nil.
+func NewGameSession(opts Options, players [2]*Player, mode GameMode) *GameSession {
+	gs := &GameSession{
+		Users:        opts.Users,
+		UserFile:     opts.UserFile,
 		Players:      players,
-		TroopSpecs:   troopSpecs,
-		TowerSpecs:   towerSpecs,
+		TroopSpecs:   opts.Specs.Troops,
+		TowerSpecs:   opts.Specs.Towers,
 		Commands:     make(chan DeployCmd, 100),
 		Done:         make(chan struct{}),
+		stop:         make(chan struct{}),
 		TickInterval: time.Second,
+		Rules:        opts.Specs.Rules,
+		Mode:         mode,
+		Clock:        RealClock,
+		lock:         &mutex,
 	}
+	gs.SetSeed(time.Now().UnixNano())
+	return gs
 }
@@ -0,0 +1,1283 @@
+// integration_test.go
+// Full matches against an in-process server on an ephemeral port
+
+package server
+
+import (
+	"bufio"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+	"tcr/config"
+	"tcr/specs"
+	"testing"
+	"time"
+)
+
+const testTimeout = 10 * time.Second
+
+// testSpecs has one-hit towers and a cheap pawn that destroys any of them,
+// so three deploys end a match without waiting for ticks
+func testSpecs() *specs.Specs {
+	return &specs.Specs{
+		Troops: map[string]specs.TroopSpec{
+			"pawn": {Name: "Pawn", Health: 50, Damage: 100, Cost: 1},
+		},
+		Towers: map[string]specs.TowerSpec{
+			"guard_tower": {Name: "Guard Tower", Type: "guard", Health: 1},
+			"king_tower":  {Name: "King Tower", Type: "king", Health: 1},
+		},
+		Rules: specs.DefaultRules(),
+	}
+}
+
+// startTestServer serves a fresh GameManager on 127.0.0.1:0 with accounts and
+// replays kept in a temp dir, and returns its address and accounts file
+func startTestServer(t *testing.T) (addr, userFile string) {
+	t.Helper()
+	srv := startTestServers(t)
+	return srv.addr, srv.userFile
+}
+
+// testServer is where startTestServers listens
+type testServer struct {
+	addr     string // TCP
+	wsAddr   string // WebSocket
+	udpPort  int    // UDP state channel
+	userFile string
+	dir      string // temp dir holding the accounts and replays
+	gm       *GameManager
+}
+
+// startTestServers also serves WebSocket clients and the UDP channel
+func startTestServers(t *testing.T) testServer {
+	t.Helper()
+	return startTestServersWith(t, nil)
+}
+
+// startTestServersWith lets setup adjust the manager's options first
+func startTestServersWith(t *testing.T, setup func(opts *Options)) testServer {
+	t.Helper()
+	dir := t.TempDir()
+	userFile := filepath.Join(dir, "players.json")
+
+	udp, err := ListenUDP("127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { udp.Close() })
+	go udp.Serve()
+
+	cfg := &config.Config{}
+	cfg.Game.MaxPlayers = 10
+	cfg.Game.RematchSec = 5
+	opts := Options{
+		UserFile:  userFile,
+		Specs:     testSpecs(),
+		Config:    cfg,
+		ReplayDir: filepath.Join(dir, "replays"),
+		UDP:       udp,
+	}
+	if setup != nil {
+		setup(&opts)
+	}
+	gm := NewGameManager(opts)
+	// Runs after the clients hang up: a match they left still writes its
+	// accounts and replay into dir as it ends
+	t.Cleanup(func() {
+		deadline := time.Now().Add(testTimeout)
+		for len(gm.Matches()) > 0 && time.Now().Before(deadline) {
+			time.Sleep(10 * time.Millisecond)
+		}
+	})
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	go Serve(ln, gm)
+
+	wsLn, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { wsLn.Close() })
+	go ServeWebSocket(wsLn, gm)
+	return testServer{
+		addr:     ln.Addr().String(),
+		wsAddr:   wsLn.Addr().String(),
+		udpPort:  udp.Port(),
+		userFile: userFile,
+		dir:      dir,
+		gm:       gm,
+	}
+}
+
+// testClient is a scripted player speaking the raw PDU protocol
+type testClient struct {
+	t        *testing.T
+	conn     net.Conn
+	username string
+	events   []CombatEvent // collected by gameEnd
+	state    StateTracker  // fed by gameEnd
+	end      GameEnd       // the last game_end
+}
+
+func dialTestClient(t *testing.T, addr, username string) *testClient {
+	t.Helper()
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.SetDeadline(time.Now().Add(testTimeout))
+	t.Cleanup(func() { conn.Close() })
+	return &testClient{t: t, conn: conn, username: username}
+}
+
+// dialTestWebSocket connects through the WebSocket listener, as a browser
+// would
+func dialTestWebSocket(t *testing.T, addr, username string) *testClient {
+	t.Helper()
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.SetDeadline(time.Now().Add(testTimeout))
+	t.Cleanup(func() { conn.Close() })
+
+	key := base64.StdEncoding.EncodeToString([]byte("tcr-test-key-16b"))
+	fmt.Fprintf(conn, "GET /ws HTTP/1.1\r\nHost: %s\r\nUpgrade: websocket\r\n"+
+		"Connection: Upgrade\r\nSec-WebSocket-Key: %s\r\nSec-WebSocket-Version: 13\r\n\r\n", addr, key)
+	r := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(r, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusSwitchingProtocols || resp.Header.Get("Sec-WebSocket-Accept") != wsAccept(key) {
+		t.Fatalf("websocket handshake: %s, accept %q", resp.Status, resp.Header.Get("Sec-WebSocket-Accept"))
+	}
+	return &testClient{t: t, conn: newWSConn(conn, r, true), username: username}
+}
+
+// send writes one PDU
+func (c *testClient) send(pduType string, payload interface{}) {
+	c.t.Helper()
+	data, _ := json.Marshal(payload)
+	if err := SendPDU(c.conn, PDU{Type: pduType, Data: data}); err != nil {
+		c.t.Fatalf("%s: send %s: %v", c.username, pduType, err)
+	}
+}
+
+// status sends a request and returns the status of the reply
+func (c *testClient) status(pduType string, payload interface{}) string {
+	c.t.Helper()
+	c.send(pduType, payload)
+	pdu := c.next(pduType + "_resp")
+	var resp struct{ Status string }
+	if err := json.Unmarshal(pdu.Data, &resp); err != nil {
+		c.t.Fatalf("%s: parse %s: %v", c.username, pdu.Type, err)
+	}
+	return resp.Status
+}
+
+// next skips PDUs until one of the given type arrives
+func (c *testClient) next(pduType string) PDU {
+	c.t.Helper()
+	for {
+		pdu, err := ReceivePDU(c.conn)
+		if err != nil {
+			c.t.Fatalf("%s: waiting for %s: %v", c.username, pduType, err)
+		}
+		if pdu.Type == pduType {
+			return pdu
+		}
+	}
+}
+
+// login registers the account and joins the classic queue
+func (c *testClient) login() {
+	c.t.Helper()
+	creds := map[string]string{"username": c.username, "password": "secret", "mode": "classic"}
+	if status := c.status("register", creds); status != "OK" {
+		c.t.Fatalf("%s: register: %s", c.username, status)
+	}
+	if status := c.status("login", creds); status != "OK" {
+		c.t.Fatalf("%s: login: %s", c.username, status)
+	}
+
+	// The card catalog follows every login
+	var catalog CardCatalog
+	if err := json.Unmarshal(c.next("card_catalog").Data, &catalog); err != nil {
+		c.t.Fatalf("%s: parse card_catalog: %v", c.username, err)
+	}
+	if card, ok := catalog.Card("pawn"); !ok || card.Cost != 1 || card.Name != "Pawn" || catalog.ManaCap != 10 {
+		c.t.Errorf("%s: card_catalog = %+v, want the pawn for 1 mana and a cap of 10", c.username, catalog)
+	}
+}
+
+// playVsBot registers the account and starts a classic match against a bot
+func (c *testClient) playVsBot(difficulty string) {
+	c.t.Helper()
+	creds := map[string]string{"username": c.username, "password": "secret", "mode": "classic"}
+	if status := c.status("register", creds); status != "OK" {
+		c.t.Fatalf("%s: register: %s", c.username, status)
+	}
+	creds["difficulty"] = difficulty
+	if status := c.status("play_vs_bot", creds); status != "OK" {
+		c.t.Fatalf("%s: play_vs_bot: %s", c.username, status)
+	}
+}
+
+// gameStart waits for the match and returns the player index
+func (c *testClient) gameStart() int {
+	c.t.Helper()
+	var start struct {
+		Mode string `json:"mode"`
+		You  int    `json:"you"`
+	}
+	if err := json.Unmarshal(c.next("game_start").Data, &start); err != nil {
+		c.t.Fatalf("%s: parse game_start: %v", c.username, err)
+	}
+	if start.Mode != "classic" {
+		c.t.Errorf("%s: mode %q, want classic", c.username, start.Mode)
+	}
+	return start.You
+}
+
+// gameEnd reads until game_end, counting level_up PDUs and collecting
+// combat events on the way
+func (c *testClient) gameEnd() (result string, exp, levelUps int) {
+	c.t.Helper()
+	for {
+		pdu, err := ReceivePDU(c.conn)
+		if err != nil {
+			c.t.Fatalf("%s: waiting for game_end: %v", c.username, err)
+		}
+		switch pdu.Type {
+		case "level_up":
+			levelUps++
+		case "state_update", "state_delta":
+			if _, err := c.state.Apply(pdu); err != nil {
+				c.t.Errorf("%s: apply %s: %v", c.username, pdu.Type, err)
+			}
+		case "combat_events":
+			var batch CombatEvents
+			if err := json.Unmarshal(pdu.Data, &batch); err != nil {
+				c.t.Fatalf("%s: parse combat_events: %v", c.username, err)
+			}
+			c.events = append(c.events, batch.Events...)
+		case "game_end":
+			if err := json.Unmarshal(pdu.Data, &c.end); err != nil {
+				c.t.Fatalf("%s: parse game_end: %v", c.username, err)
+			}
+			return c.end.Result, c.end.Exp, levelUps
+		}
+	}
+}
+
+func TestMatchAwardsExpAndLevels(t *testing.T) {
+	addr, userFile := startTestServer(t)
+	alice := dialTestClient(t, addr, "alice")
+	bob := dialTestClient(t, addr, "bob")
+	alice.login()
+	bob.login()
+
+	// Matchmaking decides who is player 0; that player attacks
+	players := [2]*testClient{}
+	for _, c := range []*testClient{alice, bob} {
+		players[c.gameStart()] = c
+	}
+	attacker, defender := players[0], players[1]
+	if attacker == nil || defender == nil {
+		t.Fatal("both clients got the same player index")
+	}
+	for i := 0; i < 3; i++ {
+		attacker.send("deploy", map[string]string{"troop": "pawn"})
+	}
+
+	type outcome struct {
+		result string
+		exp    int
+	}
+	done := make(chan outcome, 1)
+	go func() {
+		result, exp, _ := defender.gameEnd()
+		done <- outcome{result: result, exp: exp}
+	}()
+	result, exp, levelUps := attacker.gameEnd()
+	if result != "win" || exp != 30 {
+		t.Errorf("attacker game_end = %s +%d, want win +30", result, exp)
+	}
+	// 2 guards (100 each) reach level 2; the king (200) and the win (30)
+	// reach level 3
+	if levelUps != 2 {
+		t.Errorf("attacker level ups = %d, want 2", levelUps)
+	}
+	lost := <-done
+	if lost.result != "loss" || lost.exp != 5 {
+		t.Errorf("defender game_end = %s +%d, want loss +5", lost.result, lost.exp)
+	}
+
+	// Both sides see every deploy and the tower each pawn destroyed,
+	// including the final blow
+	for _, c := range players {
+		count := make(map[string]int)
+		for _, ev := range c.events {
+			if ev.Player == 0 {
+				count[ev.Type]++
+			}
+		}
+		if count[EventTroopDeployed] != 3 || count[EventTroopAttack] < 3 || count[EventTowerDestroyed] != 3 {
+			t.Errorf("%s: player 0 events %v, want 3 deploys, 3+ attacks and 3 towers destroyed",
+				c.username, count)
+		}
+	}
+
+	saved, err := LoadUsers(userFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]User{
+		attacker.username: {Level: 3, Exp: 10, NextLevel: 242, Multiplier: 1.2},
+		defender.username: {Level: 1, Exp: 5, NextLevel: 200, Multiplier: 1.0},
+	}
+	for name, w := range want {
+		got := saved[name]
+		if got.Level != w.Level || got.Exp != w.Exp || got.NextLevel != w.NextLevel ||
+			got.Multiplier < w.Multiplier-1e-9 || got.Multiplier > w.Multiplier+1e-9 {
+			t.Errorf("%s saved as level %d exp %d next %d x%.2f, want level %d exp %d next %d x%.2f",
+				name, got.Level, got.Exp, got.NextLevel, got.Multiplier,
+				w.Level, w.Exp, w.NextLevel, w.Multiplier)
+		}
+	}
+}
+
+func TestWebSocketClientPlaysTCPClient(t *testing.T) {
+	srv := startTestServers(t)
+	carol := dialTestWebSocket(t, srv.wsAddr, "carol")
+	dave := dialTestClient(t, srv.addr, "dave")
+	// Negotiated codecs switch the WebSocket to binary messages
+	carol.send("hello", map[string][]string{"codecs": {"msgpack"}})
+	carol.next("hello_resp")
+	carol.conn = WithCodec(carol.conn, MsgpackCodec)
+	carol.login()
+	dave.login()
+
+	players := [2]*testClient{}
+	for _, c := range []*testClient{carol, dave} {
+		players[c.gameStart()] = c
+	}
+	if players[0] == nil || players[1] == nil {
+		t.Fatal("both clients got the same player index")
+	}
+	for i := 0; i < 3; i++ {
+		players[0].send("deploy", map[string]string{"troop": "pawn"})
+	}
+
+	done := make(chan string, 1)
+	go func() {
+		result, _, _ := players[1].gameEnd()
+		done <- result
+	}()
+	if result, _, _ := players[0].gameEnd(); result != "win" {
+		t.Errorf("%s: result %s, want win", players[0].username, result)
+	}
+	if result := <-done; result != "loss" {
+		t.Errorf("%s: result %s, want loss", players[1].username, result)
+	}
+}
+
+func TestStateDeltaResync(t *testing.T) {
+	addr, _ := startTestServer(t)
+	alice := dialTestClient(t, addr, "alice")
+	bob := dialTestClient(t, addr, "bob")
+	alice.login()
+	bob.login()
+	alice.gameStart()
+	bob.gameStart()
+
+	// The first tick sends the full state
+	var tracker StateTracker
+	first, err := tracker.Apply(alice.next("state_update"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(first.Player1Towers) != 3 || first.Mode != "classic" {
+		t.Errorf("first state = %+v, want 3 towers in classic mode", first)
+	}
+
+	// Mana regenerates every tick, so the next tick is a delta with it
+	pdu := alice.next("state_delta")
+	var delta StateDelta
+	if err := json.Unmarshal(pdu.Data, &delta); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := delta.Changes["your_mana"]; !ok {
+		t.Errorf("delta %s lacks your_mana", pdu.Data)
+	}
+	if _, ok := delta.Changes["your_towers"]; ok {
+		t.Errorf("delta %s resends unchanged towers", pdu.Data)
+	}
+	second, err := tracker.Apply(pdu)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if second.YourMana <= first.YourMana || len(second.Player1Towers) != 3 {
+		t.Errorf("state after delta = %+v, want more mana and the towers kept", second)
+	}
+
+	// A skipped delta is a gap; resync brings a full state that the tracker
+	// accepts
+	gap := StateDelta{Seq: delta.Seq + 5}
+	data, _ := json.Marshal(gap)
+	if _, err := tracker.Apply(PDU{Type: "state_delta", Data: data}); err != ErrStateGap {
+		t.Fatalf("out of sequence delta: %v, want ErrStateGap", err)
+	}
+	alice.send("resync", struct{}{})
+	for {
+		pdu, err := ReceivePDU(alice.conn)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if pdu.Type == "state_update" {
+			if _, err := tracker.Apply(pdu); err != nil {
+				t.Fatal(err)
+			}
+			break
+		}
+		if pdu.Type == "state_delta" {
+			if _, err := tracker.Apply(pdu); err != ErrResyncPending {
+				t.Fatalf("delta while resyncing: %v, want ErrResyncPending", err)
+			}
+		}
+	}
+	if _, err := tracker.Apply(alice.next("state_delta")); err != nil {
+		t.Errorf("delta after resync: %v", err)
+	}
+}
+
+func TestStateDeltaDiffsTowers(t *testing.T) {
+	tower := func(name string, hp int) specs.TowerSpec {
+		return specs.TowerSpec{Name: name, Type: "guard", Health: hp}
+	}
+	before := GameState{
+		Player1Towers: []specs.TowerSpec{tower("King Tower", 2000), tower("Guard Tower 1", 1000), tower("Guard Tower 2", 1000)},
+		Player2Towers: []specs.TowerSpec{tower("King Tower", 2000), tower("Guard Tower 1", 1000)},
+	}
+	after := before
+	after.Player1Towers = append([]specs.TowerSpec(nil), before.Player1Towers...)
+	after.Player1Towers[1].Health = 640              // hit: only this tower is resent
+	after.Player2Towers = before.Player2Towers[:1:1] // destroyed: the list is resent
+	prev, _ := stateFields(before)
+	next, _ := stateFields(after)
+
+	diff := diffState(prev, next)
+	if _, ok := diff.Changes["your_towers"]; ok {
+		t.Errorf("changes %v resend every tower", diff.Changes)
+	}
+	if elems := diff.Elements["your_towers"]; len(elems) != 1 || elems[1] == nil {
+		t.Errorf("your_towers elements = %v, want only tower 1", elems)
+	}
+	if _, ok := diff.Changes["opponent_towers"]; !ok {
+		t.Errorf("changes %v lack the shortened opponent_towers", diff.Changes)
+	}
+
+	var tracker StateTracker
+	full, _ := fullState(prev, 1)
+	if _, err := tracker.Apply(PDU{Type: "state_update", Data: full}); err != nil {
+		t.Fatal(err)
+	}
+	diff.Seq = 2
+	data, _ := json.Marshal(diff)
+	got, err := tracker.Apply(PDU{Type: "state_delta", Data: data})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, after) {
+		t.Errorf("tracked state = %+v, want %+v", got, after)
+	}
+
+	// An element past the end means the client is out of step
+	bad, _ := json.Marshal(StateDelta{Seq: 3, Elements: map[string]ElementChanges{
+		"opponent_towers": {4: json.RawMessage(`{}`)},
+	}})
+	if _, err := tracker.Apply(PDU{Type: "state_delta", Data: bad}); !errors.Is(err, ErrStateGap) {
+		t.Errorf("delta past the tower list: %v, want ErrStateGap", err)
+	}
+}
+
+func TestChatRelay(t *testing.T) {
+	addr, _ := startTestServer(t)
+	alice := dialTestClient(t, addr, "alice")
+	bob := dialTestClient(t, addr, "bob")
+	alice.login()
+	bob.login()
+	alice.gameStart()
+	bob.gameStart()
+
+	// Control characters are stripped so chat cannot redraw a terminal
+	alice.send("chat", ChatMessage{Message: "\x1b[2Jgood luck\n"})
+	var msg ChatMessage
+	if err := json.Unmarshal(bob.next("chat").Data, &msg); err != nil {
+		t.Fatal(err)
+	}
+	if msg.From != "alice" || msg.Message != "[2Jgood luck" {
+		t.Errorf("chat = %+v, want [2Jgood luck from alice", msg)
+	}
+}
+
+func TestSurrenderAndRematch(t *testing.T) {
+	srv := startTestServers(t)
+	addr := srv.addr
+	alice := dialTestClient(t, addr, "alice")
+	bob := dialTestClient(t, addr, "bob")
+	alice.login()
+	bob.login()
+	players := [2]*testClient{}
+	for _, c := range []*testClient{alice, bob} {
+		players[c.gameStart()] = c
+	}
+	if players[0] == nil || players[1] == nil {
+		t.Fatal("both clients got the same player index")
+	}
+
+	// surrender decides the match against the player who sent it, and
+	// game_end offers a rematch
+	settle := func(quitter *testClient) {
+		t.Helper()
+		quitter.send("surrender", struct{}{})
+		for _, c := range players {
+			want := "win"
+			if c == quitter {
+				want = "loss"
+			}
+			if c.gameEnd(); c.end.Result != want || c.end.Reason != EndSurrender || !c.end.Rematch {
+				t.Errorf("%s: game_end = %+v, want %s by surrender with a rematch offer", c.username, c.end, want)
+			}
+		}
+	}
+	settle(players[0])
+
+	// Deploys after game_end are dropped rather than filling the ended
+	// session's command buffer, so the votes behind them still arrive
+	for i := 0; i < 150; i++ {
+		players[1].send("deploy", map[string]string{"troop": "pawn"})
+	}
+	if online := srv.gm.OnlineUsers(); len(online) != 2 {
+		t.Errorf("online during the rematch offer: %+v, want both players", online)
+	}
+
+	// Both accept; the one still deciding hears the other is waiting
+	players[1].send("rematch", map[string]bool{"accept": true})
+	var offer struct{ From string }
+	if err := json.Unmarshal(players[0].next("rematch_offer").Data, &offer); err != nil || offer.From != players[1].username {
+		t.Errorf("rematch_offer from %q (%v), want %s", offer.From, err, players[1].username)
+	}
+	players[0].send("rematch", map[string]bool{"accept": true})
+	for i, c := range players {
+		if you := c.gameStart(); you != i {
+			t.Errorf("%s: rematch as player %d, want %d", c.username, you, i)
+		}
+	}
+
+	// Commands reach the new session; a declined offer closes both
+	// connections
+	settle(players[1])
+	players[0].send("rematch", map[string]bool{"accept": false})
+	for _, c := range players {
+		var cancel struct{ Reason string }
+		json.Unmarshal(c.next("rematch_cancel").Data, &cancel)
+		if cancel.Reason != "declined" {
+			t.Errorf("%s: rematch_cancel reason %q, want declined", c.username, cancel.Reason)
+		}
+		if pdu, err := ReceivePDU(c.conn); err == nil {
+			t.Errorf("%s: got %s after rematch_cancel, want the connection closed", c.username, pdu.Type)
+		}
+	}
+}
+
+// playBot checks that c is matched against a bot of difficulty and plays
+// the match out with pawns
+func playBot(t *testing.T, srv testServer, c *testClient, difficulty string) {
+	t.Helper()
+	if you := c.gameStart(); you != 0 {
+		t.Errorf("%s: player %d against a bot, want 0", c.username, you)
+	}
+	matches := srv.gm.Matches()
+	if len(matches) != 1 || matches[0].Players != [2]string{c.username, "bot_" + difficulty} {
+		t.Fatalf("matches %+v, want %s against bot_%s", matches, c.username, difficulty)
+	}
+	for i := 0; i < 3; i++ {
+		c.send("deploy", map[string]string{"troop": "pawn"})
+	}
+	if result, _, _ := c.gameEnd(); result != "win" && result != "loss" {
+		t.Errorf("%s: game_end %+v, want a decided match", c.username, c.end)
+	}
+}
+
+func TestPlayVsBot(t *testing.T) {
+	srv := startTestServers(t)
+	alice := dialTestClient(t, srv.addr, "alice")
+	alice.playVsBot(BotGreedy)
+	playBot(t, srv, alice, BotGreedy)
+}
+
+func TestQueueBackfillsWithBot(t *testing.T) {
+	srv := startTestServersWith(t, func(opts *Options) {
+		opts.Config.Game.BotBackfillSec = 1
+	})
+	alice := dialTestClient(t, srv.addr, "alice")
+	alice.login()
+	// Nobody else queues, so a bot steps in after bot_backfill_sec
+	playBot(t, srv, alice, DefaultBotDifficulty)
+}
+
+func TestUDPSnapshotsAndFallback(t *testing.T) {
+	srv := startTestServers(t)
+	alice := dialTestClient(t, srv.addr, "alice")
+	bob := dialTestClient(t, srv.addr, "bob")
+
+	creds := map[string]interface{}{"username": "alice", "password": "secret", "mode": "classic", "udp": true}
+	if status := alice.status("register", creds); status != "OK" {
+		t.Fatalf("register: %s", status)
+	}
+	alice.send("login", creds)
+	var resp struct {
+		Status   string `json:"status"`
+		UDPPort  int    `json:"udp_port"`
+		UDPToken string `json:"udp_token"`
+	}
+	if err := json.Unmarshal(alice.next("login_resp").Data, &resp); err != nil {
+		t.Fatal(err)
+	}
+	if resp.Status != "OK" || resp.UDPPort != srv.udpPort || resp.UDPToken == "" {
+		t.Fatalf("login_resp = %+v, want OK with the udp port and a token", resp)
+	}
+
+	udp, err := net.DialUDP("udp", nil, &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: resp.UDPPort})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer udp.Close()
+	udp.SetDeadline(time.Now().Add(testTimeout))
+	keepalive, _ := json.Marshal(UDPKeepaliveMsg{Token: resp.UDPToken})
+	if _, err := udp.Write(keepalive); err != nil {
+		t.Fatal(err)
+	}
+
+	bob.login()
+	alice.gameStart()
+	bob.gameStart()
+
+	// The first tick's full state comes over UDP, with its sequence number
+	buf := make([]byte, 4096)
+	n, err := udp.Read(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var pdu PDU
+	var snap struct {
+		Seq    int               `json:"seq"`
+		Towers []specs.TowerSpec `json:"your_towers"`
+	}
+	if err := json.Unmarshal(buf[:n], &pdu); err != nil || json.Unmarshal(pdu.Data, &snap) != nil {
+		t.Fatalf("bad snapshot %q", buf[:n])
+	}
+	if pdu.Type != "state_update" || snap.Seq < 1 || len(snap.Towers) != 3 {
+		t.Errorf("snapshot = %s %s, want a state_update with seq and towers", pdu.Type, pdu.Data)
+	}
+
+	// After udp_fallback the state goes back to TCP, starting with a full
+	// update
+	alice.send("udp_fallback", struct{}{})
+	for {
+		pdu, err := ReceivePDU(alice.conn)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if pdu.Type == "state_delta" {
+			t.Fatal("state_delta before the full state after fallback")
+		}
+		if pdu.Type == "state_update" {
+			break
+		}
+	}
+}
+
+func TestRegisterAndLoginErrors(t *testing.T) {
+	addr, userFile := startTestServer(t)
+	c := dialTestClient(t, addr, "carol")
+
+	creds := map[string]string{"username": "carol", "password": "secret"}
+	if status := c.status("register", creds); status != "OK" {
+		t.Fatalf("register: %s", status)
+	}
+	if status := c.status("register", creds); status != "ERR:UserExists" {
+		t.Errorf("duplicate register: %s, want ERR:UserExists", status)
+	}
+	wrong := map[string]string{"username": "carol", "password": "nope"}
+	if status := c.status("login", wrong); status == "OK" {
+		t.Error("login with a wrong password succeeded")
+	}
+	unknown := map[string]string{"username": "carol", "password": "secret", "mode": "chess"}
+	if status := c.status("login", unknown); status != "ERR:UnknownMode" {
+		t.Errorf("login with unknown mode: %s, want ERR:UnknownMode", status)
+	}
+
+	saved, err := LoadUsers(userFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if u, ok := saved["carol"]; !ok || u.Level != 1 || u.NextLevel != 200 {
+		t.Errorf("carol saved as %+v, want a fresh level 1 account", u)
+	}
+}
+
+func TestReplayRecordsDisconnect(t *testing.T) {
+	s := testSpecs()
+	mode, _ := NewGameMode(DefaultMode)
+	var players [2]*Player
+	for i, name := range []string{"alice", "bob"} {
+		players[i] = newPlayer(nil, name, Level{Level: 1, NextLevel: 200, Multiplier: 1}, s.Towers, s.Rules)
+	}
+	gs := NewGameSession(Options{Specs: s}, players, mode)
+	gs.ID = "disconnect"
+	gs.SetSeed(7)
+	dir := t.TempDir()
+	rec, err := NewReplayRecorder(dir, newReplayHeader(gs))
+	if err != nil {
+		t.Fatal(err)
+	}
+	gs.Recorder = rec
+
+	// bob's connection is attached after a checksum and closed, so the first
+	// state update sent to it fails
+	for gs.ticks < checksumEvery+2 {
+		if gs.Advance() {
+			t.Fatalf("match over at tick %d before the disconnect", gs.ticks)
+		}
+	}
+	local, remote := net.Pipe()
+	remote.Close()
+	gs.Players[1].Conn = local
+	gs.Players[1].resync.Store(true)
+	if !gs.Advance() {
+		t.Fatal("failed send did not end the match")
+	}
+	live := gs.ticks
+	gs.closeReplay()
+
+	replay, err := LoadReplay(filepath.Join(dir, gs.ID+replayExt))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := ReplayEvent{Tick: live, Player: 1, Forfeit: EndDisconnect}
+	if n := len(replay.Events); n < 2 || replay.Events[n-2] != want {
+		t.Fatalf("replay events %+v, want %+v before the end", replay.Events, want)
+	}
+	// Playback needs no specs of its own: the replay carries the match's
+	for _, given := range []*specs.Specs{s, nil} {
+		played := 0
+		if err := PlayReplay(replay, given, func(GameState) { played++ }); err != nil {
+			t.Fatal(err)
+		}
+		if played != live {
+			t.Errorf("playback ran %d ticks, live match %d", played, live)
+		}
+	}
+	changed := testSpecs()
+	changed.Rules.ManaCap++
+	if err := PlayReplay(replay, changed, func(GameState) {}); err == nil {
+		t.Error("playback accepted specs with another hash")
+	}
+}
+
+func TestManaPhasesAndOvertime(t *testing.T) {
+	s := testSpecs()
+	s.Rules.StartingMana, s.Rules.ManaCap = 0, 100
+	s.Rules.MatchDurationSec, s.Rules.OvertimeSec = 6, 4
+	s.Rules.Phases = []specs.ManaPhase{
+		{Name: "double_mana", StartSec: 2, ManaMultiplier: 2},
+		{Name: "overtime", StartSec: 6, ManaMultiplier: 3},
+	}
+	mode, _ := NewGameMode(DefaultMode)
+	var players [2]*Player
+	for i, name := range []string{"alice", "bob"} {
+		players[i] = newPlayer(nil, name, Level{Level: 1, NextLevel: 200, Multiplier: 1}, s.Towers, s.Rules)
+	}
+	gs := NewGameSession(Options{Specs: s}, players, mode)
+	gs.SetSeed(1)
+
+	// Nobody deploys, so regulation ends with the towers tied; regen follows
+	// the phase active once each tick has been played
+	var gains []int
+	for gs.ticks < s.Rules.MatchDurationSec {
+		before := gs.Players[0].Mana
+		if gs.Advance() {
+			t.Fatalf("match over at tick %d with the towers tied", gs.ticks)
+		}
+		gains = append(gains, gs.Players[0].Mana-before)
+	}
+	if want := []int{1, 2, 2, 2, 2, 3}; !slices.Equal(gains, want) {
+		t.Errorf("mana gained per tick %v, want %v", gains, want)
+	}
+	if !gs.overtime {
+		t.Fatal("tied towers at the end of regulation did not start overtime")
+	}
+	if left, want := gs.timeLeft(), time.Duration(s.Rules.OvertimeSec)*time.Second; left != want {
+		t.Errorf("time left at the start of overtime %v, want %v", left, want)
+	}
+
+	// The first tower destroyed in sudden death decides the match
+	gs.Deploy(DeployCmd{PlayerIndex: 1, TroopName: "pawn"})
+	over := false
+	for !over && gs.ticks < s.Rules.MatchDurationSec+s.Rules.OvertimeSec {
+		over = gs.Advance()
+	}
+	if !over {
+		t.Fatal("overtime expired with a tower down")
+	}
+	if w := gs.winner(); w != 1 {
+		t.Errorf("winner %d, want bob (1)", w)
+	}
+}
+
+// simSpecs has sturdier towers than testSpecs and a second troop, so the
+// bots have choices to make
+func simSpecs() *specs.Specs {
+	s := testSpecs()
+	s.Troops["giant"] = specs.TroopSpec{Name: "Giant", Health: 400, Damage: 40, Defence: 5, Cost: 3}
+	for key, tower := range s.Towers {
+		tower.Health, tower.Damage = 300, 20
+		s.Towers[key] = tower
+	}
+	return s
+}
+
+func TestSimulateIsDeterministic(t *testing.T) {
+	s := simSpecs()
+	level := Level{Level: 1, NextLevel: 200, Multiplier: 1}
+	for _, mode := range ModeNames() {
+		sides := [2]SimSide{
+			{Difficulty: BotRandom, Level: level},
+			{Difficulty: BotGreedy, Deck: []string{"giant", "pawn"}, Level: level},
+		}
+		first, err := Simulate(s, mode, sides, 42)
+		if err != nil {
+			t.Fatal(err)
+		}
+		second, err := Simulate(s, mode, sides, 42)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(first, second) {
+			t.Errorf("%s: same seed gave %+v, then %+v", mode, first, second)
+		}
+		if first.Deploys[0]["pawn"]+first.Deploys[0]["giant"] == 0 {
+			t.Errorf("%s: the random bot never deployed: %+v", mode, first)
+		}
+	}
+}
+
+func TestSimulateInParallel(t *testing.T) {
+	s := simSpecs()
+	level := Level{Level: 1, NextLevel: 200, Multiplier: 1}
+	sides := [2]SimSide{{Difficulty: BotGreedy, Level: level}, {Difficulty: BotCounter, Level: level}}
+	const matches = 8
+	var want, got [matches]SimResult
+	for i := range want {
+		want[i], _ = Simulate(s, DefaultMode, sides, int64(i))
+	}
+	// Parallel runs do not share state, so they play the same matches
+	done := make(chan struct{})
+	for i := range got {
+		go func(i int) {
+			defer func() { done <- struct{}{} }()
+			got[i], _ = Simulate(s, DefaultMode, sides, int64(i))
+		}(i)
+	}
+	for range got {
+		<-done
+	}
+	for i, res := range got {
+		if !reflect.DeepEqual(res, want[i]) {
+			t.Errorf("seed %d: parallel %+v, alone %+v", i, res, want[i])
+		}
+		deploys := [2]int{}
+		for side, counts := range res.Deploys {
+			for _, n := range counts {
+				deploys[side] += n
+			}
+		}
+		if deploys[0] == 0 || deploys[1] == 0 {
+			t.Errorf("seed %d: deploys %v, want both bots to play", i, res.Deploys)
+		}
+		// No one forfeits a simulation, so the winner kept more towers
+		lost := res.TowersLost
+		if (res.Winner == 0) != (lost[0] < lost[1]) || (res.Winner == 1) != (lost[1] < lost[0]) {
+			t.Errorf("seed %d: winner %d with towers lost %v", i, res.Winner, lost)
+		}
+	}
+}
+
+// writeSpecs saves s to path with a modification time after the last one, so
+// a polling watcher notices even within the file system's time resolution
+func writeSpecs(t *testing.T, path string, s *specs.Specs) {
+	t.Helper()
+	data, err := json.Marshal(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	modTime := time.Now()
+	if fi, err := os.Stat(path); err == nil && !modTime.After(fi.ModTime()) {
+		modTime = fi.ModTime().Add(time.Second)
+	}
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chtimes(path, modTime, modTime); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestSpecsReloadKeepsRunningMatches(t *testing.T) {
+	specsFile := filepath.Join(t.TempDir(), "game_specs.json")
+	writeSpecs(t, specsFile, testSpecs())
+	srv := startTestServersWith(t, func(opts *Options) {
+		opts.SpecsFile = specsFile
+		opts.Config.Game.SpecsPollSec = 1
+		opts.Config.Security.AdminToken = "letmein"
+	})
+	alice := dialTestClient(t, srv.addr, "alice")
+	bob := dialTestClient(t, srv.addr, "bob")
+	alice.login()
+	bob.login()
+	players := [2]*testClient{}
+	for _, c := range []*testClient{alice, bob} {
+		players[c.gameStart()] = c
+	}
+	if players[0] == nil || players[1] == nil {
+		t.Fatal("both clients got the same player index")
+	}
+
+	// The watcher picks up towers no pawn can take down in three hits
+	sturdy := testSpecs()
+	for key, tower := range sturdy.Towers {
+		tower.Health = 5000
+		sturdy.Towers[key] = tower
+	}
+	writeSpecs(t, specsFile, sturdy)
+	want := specs.Hash(sturdy)
+	for deadline := time.Now().Add(testTimeout / 2); srv.gm.SpecsHash() != want; {
+		if time.Now().After(deadline) {
+			t.Fatalf("specs hash %s after the file changed, want %s", srv.gm.SpecsHash(), want)
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+
+	// The match in progress still has one-hit towers
+	for i := 0; i < 3; i++ {
+		players[0].send("deploy", map[string]string{"troop": "pawn"})
+	}
+	if result, _, _ := players[0].gameEnd(); result != "win" {
+		t.Errorf("running match ended in a %s, want the old specs' quick win", result)
+	}
+
+	// The next match starts with the reloaded towers
+	carol := dialTestClient(t, srv.addr, "carol")
+	dave := dialTestClient(t, srv.addr, "dave")
+	carol.login()
+	dave.login()
+	carol.gameStart()
+	var state GameState
+	if err := json.Unmarshal(carol.next("state_update").Data, &state); err != nil {
+		t.Fatal(err)
+	}
+	if len(state.Player1Towers) == 0 || state.Player1Towers[0].Health != 5000 {
+		t.Errorf("new match towers %+v, want the reloaded 5000 health", state.Player1Towers)
+	}
+
+	// An invalid file keeps the specs in use; reload_specs needs the token
+	if err := os.WriteFile(specsFile, []byte(`{"troops":{}}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := srv.gm.ReloadSpecs(); err == nil || srv.gm.SpecsHash() != want {
+		t.Errorf("invalid specs: err %v, hash %s, want an error and %s", err, srv.gm.SpecsHash(), want)
+	}
+	writeSpecs(t, specsFile, testSpecs())
+	admin := dialTestClient(t, srv.addr, "admin")
+	if status := admin.status("reload_specs", map[string]string{"token": "wrong"}); status != "ERR:Unauthorized" {
+		t.Errorf("reload_specs with a wrong token: %s, want ERR:Unauthorized", status)
+	}
+	if status := admin.status("reload_specs", map[string]string{"token": "letmein"}); status != "OK" {
+		t.Errorf("reload_specs: %s, want OK", status)
+	}
+	if hash := srv.gm.SpecsHash(); hash != specs.Hash(testSpecs()) {
+		t.Errorf("specs hash %s after reload_specs, want the original %s", hash, specs.Hash(testSpecs()))
+	}
+}
+
+// adminConsole is a console connection read up to each prompt
+type adminConsole struct {
+	t    *testing.T
+	conn net.Conn
+	r    *bufio.Reader
+}
+
+// expect reads up to and including prompt and returns what came before it
+func (a *adminConsole) expect(prompt string) string {
+	a.t.Helper()
+	var b strings.Builder
+	for !strings.HasSuffix(b.String(), prompt) {
+		c, err := a.r.ReadByte()
+		if err != nil {
+			a.t.Fatalf("admin: waiting for %q after %q: %v", prompt, b.String(), err)
+		}
+		b.WriteByte(c)
+	}
+	return strings.TrimSuffix(b.String(), prompt)
+}
+
+// run sends a command and returns its output
+func (a *adminConsole) run(line string) string {
+	a.t.Helper()
+	fmt.Fprintln(a.conn, line)
+	return strings.TrimSpace(a.expect("> "))
+}
+
+func TestAdminConsole(t *testing.T) {
+	auditFile := filepath.Join(t.TempDir(), "audit.log")
+	srv := startTestServersWith(t, func(opts *Options) {
+		opts.AuditFile = auditFile
+		opts.Config.Security.AdminToken = "letmein"
+	})
+	alice := dialTestClient(t, srv.addr, "alice")
+	bob := dialTestClient(t, srv.addr, "bob")
+	alice.login()
+	bob.login()
+	alice.gameStart()
+	bob.gameStart()
+
+	// Localhost is trusted
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	go ServeAdmin(ln, srv.gm)
+	local, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer local.Close()
+	local.SetDeadline(time.Now().Add(testTimeout))
+	console := &adminConsole{t: t, conn: local, r: bufio.NewReader(local)}
+	if greeting := console.expect("> "); !strings.Contains(greeting, "admin console") {
+		t.Errorf("localhost greeting %q, want the console without auth", greeting)
+	}
+	local.Close()
+
+	// Anyone else needs the token; a pipe has no loopback address
+	conn, remote := net.Pipe()
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(testTimeout))
+	go srv.gm.handleAdmin(remote)
+	console = &adminConsole{t: t, conn: conn, r: bufio.NewReader(conn)}
+	console.expect("auth: ")
+	fmt.Fprintln(conn, "auth wrong")
+	if out := console.expect("auth: "); !strings.Contains(out, "invalid token") {
+		t.Errorf("wrong token: %q, want invalid token", out)
+	}
+	fmt.Fprintln(conn, "auth letmein")
+	console.expect("> ")
+
+	if out := console.run("users"); !strings.HasPrefix(out, "2 online") ||
+		!strings.Contains(out, "alice") || !strings.Contains(out, "in ") {
+		t.Errorf("users:\n%s\nwant alice and bob in a match", out)
+	}
+	if out := console.run("broadcast server restarts soon"); out != "sent to 2 user(s)" {
+		t.Errorf("broadcast: %q, want sent to 2 user(s)", out)
+	}
+	var msg struct{ Message string }
+	json.Unmarshal(alice.next("broadcast").Data, &msg)
+	if msg.Message != "server restarts soon" {
+		t.Errorf("alice got broadcast %q", msg.Message)
+	}
+
+	// A ban kicks bob out of the match and refuses the account's logins
+	if out := console.run("ban bob"); out != "banned bob" {
+		t.Errorf("ban: %q, want banned bob", out)
+	}
+	if alice.gameEnd(); alice.end.Result != "win" || alice.end.Reason != EndDisconnect {
+		t.Errorf("alice game_end = %+v, want a win by disconnect", alice.end)
+	}
+	again := dialTestClient(t, srv.addr, "bob")
+	creds := map[string]string{"username": "bob", "password": "secret", "mode": "classic"}
+	if status := again.status("login", creds); status != "ERR:Banned" {
+		t.Errorf("banned login: %s, want ERR:Banned", status)
+	}
+	if out := console.run("frobnicate"); !strings.HasPrefix(out, "error: unknown command") {
+		t.Errorf("unknown command: %q", out)
+	}
+	fmt.Fprintln(conn, "quit")
+	if _, err := console.r.ReadByte(); err == nil {
+		t.Error("console still open after quit")
+	}
+
+	// Every attempt and command is audited in order
+	data, err := os.ReadFile(auditFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, line := range strings.Split(strings.TrimSpace(string(data)), "\n") {
+		var entry AuditEntry
+		if err := json.Unmarshal([]byte(line), &entry); err != nil {
+			t.Fatalf("audit line %q: %v", line, err)
+		}
+		got = append(got, entry.Command+": "+strings.SplitN(entry.Result, ":", 2)[0])
+	}
+	want := []string{"auth: failed", "auth: ok", "users: ok", "broadcast server restarts soon: ok",
+		"ban bob: ok", "frobnicate: error"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("audit log %q, want %q", got, want)
+	}
+}
+
+// scrape fetches path from the metrics server and returns the status code
+// and body
+func scrape(t *testing.T, base, path string) (int, string) {
+	t.Helper()
+	resp, err := http.Get(base + path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	var b strings.Builder
+	if _, err := bufio.NewReader(resp.Body).WriteTo(&b); err != nil {
+		t.Fatal(err)
+	}
+	return resp.StatusCode, b.String()
+}
+
+// samples parses the Prometheus text format into series -> value
+func samples(t *testing.T, text string) map[string]float64 {
+	t.Helper()
+	values := make(map[string]float64)
+	for _, line := range strings.Split(text, "\n") {
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		i := strings.LastIndexByte(line, ' ')
+		if i < 0 {
+			t.Fatalf("bad sample line %q", line)
+		}
+		v, err := strconv.ParseFloat(line[i+1:], 64)
+		if err != nil {
+			t.Fatalf("bad sample line %q: %v", line, err)
+		}
+		values[line[:i]] = v
+	}
+	return values
+}
+
+func TestMetricsAndReadiness(t *testing.T) {
+	srv := startTestServers(t)
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	go ServeHTTP(ln, srv.gm)
+	base := "http://" + ln.Addr().String()
+
+	if code, body := scrape(t, base, "/healthz"); code != http.StatusOK || body != "ok\n" {
+		t.Errorf("/healthz = %d %q, want 200 ok", code, body)
+	}
+	if code, body := scrape(t, base, "/readyz"); code != http.StatusOK {
+		t.Errorf("/readyz = %d %q with the matchmaker running, want 200", code, body)
+	}
+
+	// Counters are process-wide and the test clients count what they read,
+	// so compare series only the server touches before and after a match
+	_, text := scrape(t, base, "/metrics")
+	before := samples(t, text)
+	alice := dialTestClient(t, srv.addr, "alice")
+	bob := dialTestClient(t, srv.addr, "bob")
+	alice.login()
+	bob.login()
+	alice.gameStart()
+	bob.gameStart()
+	alice.send("surrender", struct{}{})
+	alice.gameEnd()
+	bob.gameEnd()
+	stranger := dialTestClient(t, srv.addr, "stranger")
+	stranger.send("xyzzy", struct{}{})
+	stranger.next("error")
+
+	_, text = scrape(t, base, "/metrics")
+	after := samples(t, text)
+	for series, delta := range map[string]float64{
+		`tcr_pdus_received_total{type="surrender"}`:          1,
+		`tcr_pdus_received_total{type="unknown"}`:            1,
+		`tcr_pdus_sent_total{type="game_start"}`:             2,
+		`tcr_pdus_sent_total{type="game_end"}`:               2,
+		`tcr_pdus_sent_total{type="error"}`:                  1,
+		`tcr_matches_total{mode="classic",result="decided"}`: 1,
+		`tcr_match_duration_seconds_count`:                   1,
+		`tcr_match_duration_seconds_bucket{le="+Inf"}`:       1,
+	} {
+		if got := after[series] - before[series]; got != delta {
+			t.Errorf("%s went up by %g, want %g", series, got, delta)
+		}
+	}
+	if _, ok := after["tcr_connected_clients"]; !ok || !strings.Contains(text, "# TYPE tcr_match_duration_seconds histogram") {
+		t.Errorf("/metrics lacks the client gauge or the duration histogram:\n%s", text)
+	}
+
+	// A matchmaker that stops beating fails /readyz until it beats again;
+	// every test server's matchmaker beats, so one may land in between
+	stalled := false
+	for i := 0; i < 5 && !stalled; i++ {
+		metrics.heartbeat.Store(time.Now().Add(-2 * matchmakerStallAfter).UnixNano())
+		code, body := scrape(t, base, "/readyz")
+		stalled = code == http.StatusServiceUnavailable && strings.Contains(body, "matchmaker stalled")
+	}
+	if !stalled {
+		t.Error("/readyz stayed ready with a stale matchmaker heartbeat")
+	}
+	for deadline := time.Now().Add(3 * time.Second); ; time.Sleep(100 * time.Millisecond) {
+		if code, _ := scrape(t, base, "/readyz"); code == http.StatusOK {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("/readyz did not recover once the matchmaker beat again")
+		}
+	}
+}
+
+func TestSpectatorBacklogCoversDelay(t *testing.T) {
+	for _, c := range []struct {
+		delay, tick time.Duration
+		want        int
+	}{
+		{0, 100 * time.Millisecond, spectatorSlack},
+		{maxSpectateDelay, 50 * time.Millisecond, spectatorSlack + 1200},
+		{2 * maxSpectateDelay, time.Second, spectatorSlack + 60},
+	} {
+		sp := NewSpectator(nil, c.delay, c.tick)
+		if got := cap(sp.frames); got != c.want {
+			t.Errorf("delay %v at %v ticks: backlog %d, want %d", c.delay, c.tick, got, c.want)
+		}
+	}
+}
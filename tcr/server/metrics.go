@@ -0,0 +1,255 @@
+// metrics.go
+// Server counters and the HTTP /healthz, /readyz and Prometheus /metrics
+// endpoints
+
+package server
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Deploy rejection reasons
+const (
+	rejectCannotDeploy = "cannot_deploy" // the mode does not allow it now
+	rejectUnknownTroop = "unknown_troop"
+	rejectNoMana       = "insufficient_mana"
+)
+
+// matchmakerStallAfter is how long the matchmaker may go without a loop
+// before /readyz fails
+const matchmakerStallAfter = 5 * time.Second
+
+// pduTypes are the PDU types counted by name; anything else a client sends is
+// counted as "unknown" so labels stay bounded
+var pduTypes = map[string]bool{
+	"register": true, "register_resp": true, "login": true, "login_resp": true,
+	"play_vs_bot": true, "play_vs_bot_resp": true, "game_start": true,
+	"state_update": true, "deploy": true, "level_up": true, "overtime": true,
+	"game_end": true, "list_matches": true, "list_matches_resp": true,
+	"spectate": true, "spectate_resp": true, "spectate_update": true,
+	"spectate_end": true, "replay_list": true, "replay_list_resp": true,
+	"replay_fetch": true, "replay_fetch_resp": true, "reload_specs": true,
+	"reload_specs_resp": true, "broadcast": true, "combat_events": true,
+	"state_delta": true, "resync": true, "hello": true, "hello_resp": true,
+	"udp_fallback": true, "card_catalog": true, "chat": true, "surrender": true,
+	"rematch": true, "rematch_offer": true, "rematch_cancel": true,
+	"error": true,
+}
+
+// matchDurationBuckets are the upper bounds, in seconds of game time, of the
+// match duration histogram
+var matchDurationBuckets = []float64{30, 60, 90, 120, 180, 240, 300}
+
+// Metrics holds the server's counters. The zero value is not usable; the
+// package keeps one instance in metrics.
+type Metrics struct {
+	connected atomic.Int64 // open client connections
+	queued    atomic.Int64 // clients waiting for a match
+	heartbeat atomic.Int64 // unix nanos of the matchmaker's last loop
+	oldest    atomic.Int64 // nanos the longest-waiting client has waited
+
+	mutex          sync.Mutex
+	pduIn          map[string]uint64
+	pduOut         map[string]uint64
+	sendErrors     map[string]uint64
+	deployRejected map[string]uint64
+	matches        map[[2]string]uint64 // mode, result
+	durationCounts []uint64             // per bucket, not cumulative
+	durationSum    float64
+	durationCount  uint64
+}
+
+var metrics = newMetrics()
+
+func newMetrics() *Metrics {
+	return &Metrics{
+		pduIn:          make(map[string]uint64),
+		pduOut:         make(map[string]uint64),
+		sendErrors:     make(map[string]uint64),
+		deployRejected: make(map[string]uint64),
+		matches:        make(map[[2]string]uint64),
+		durationCounts: make([]uint64, len(matchDurationBuckets)+1),
+	}
+}
+
+// pduLabel bounds the PDU type label
+func pduLabel(pduType string) string {
+	if pduTypes[pduType] {
+		return pduType
+	}
+	return "unknown"
+}
+
+func (m *Metrics) countIn(pduType string) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.pduIn[pduLabel(pduType)]++
+}
+
+func (m *Metrics) countOut(pduType string, err error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	if err != nil {
+		m.sendErrors[pduLabel(pduType)]++
+		return
+	}
+	m.pduOut[pduLabel(pduType)]++
+}
+
+func (m *Metrics) rejectDeploy(reason string) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.deployRejected[reason]++
+}
+
+// matchEnded records a finished or aborted match and its game-time length
+func (m *Metrics) matchEnded(mode, result string, d time.Duration) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.matches[[2]string{mode, result}]++
+	sec := d.Seconds()
+	i := sort.SearchFloat64s(matchDurationBuckets, sec)
+	m.durationCounts[i]++
+	m.durationSum += sec
+	m.durationCount++
+}
+
+// matchmakerBeat records a matchmaker loop and the longest current wait
+func (m *Metrics) matchmakerBeat(oldest time.Duration) {
+	m.heartbeat.Store(time.Now().UnixNano())
+	m.oldest.Store(int64(oldest))
+}
+
+// trackedConn counts a client connection as open until its first Close
+type trackedConn struct {
+	net.Conn
+	once sync.Once
+}
+
+func trackConn(conn net.Conn) net.Conn {
+	metrics.connected.Add(1)
+	return &trackedConn{Conn: conn}
+}
+
+func (c *trackedConn) Close() error {
+	c.once.Do(func() { metrics.connected.Add(-1) })
+	return c.Conn.Close()
+}
+
+// writeCounters writes one labelled counter family
+func writeCounters(w io.Writer, name, help, label string, values map[string]uint64) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s counter\n", name, help, name)
+	keys := make([]string, 0, len(values))
+	for k := range values {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		fmt.Fprintf(w, "%s{%s=%q} %d\n", name, label, k, values[k])
+	}
+}
+
+// writeGauge writes one unlabelled gauge
+func writeGauge(w io.Writer, name, help string, value float64) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s gauge\n%s %g\n", name, help, name, name, value)
+}
+
+// WritePrometheus writes every metric in the Prometheus text format
+func (gm *GameManager) WritePrometheus(w io.Writer) {
+	m := metrics
+	writeGauge(w, "tcr_connected_clients", "Open client connections.", float64(m.connected.Load()))
+	writeGauge(w, "tcr_queued_players", "Players waiting in matchmaking.", float64(m.queued.Load()))
+	writeGauge(w, "tcr_queue_oldest_wait_seconds", "How long the longest-waiting player has waited.",
+		time.Duration(m.oldest.Load()).Seconds())
+	writeGauge(w, "tcr_matchmaker_heartbeat_timestamp_seconds", "Last matchmaker loop, unix time.",
+		float64(m.heartbeat.Load())/1e9)
+	writeGauge(w, "tcr_active_sessions", "Live game sessions.", float64(len(gm.Matches())))
+	writeGauge(w, "tcr_online_users", "Logged-in users.", float64(len(gm.OnlineUsers())))
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	writeCounters(w, "tcr_pdus_received_total", "PDUs received by type.", "type", m.pduIn)
+	writeCounters(w, "tcr_pdus_sent_total", "PDUs sent by type.", "type", m.pduOut)
+	writeCounters(w, "tcr_send_errors_total", "Failed SendPDU calls by PDU type.", "type", m.sendErrors)
+	writeCounters(w, "tcr_deploys_rejected_total", "Rejected deploys by reason.", "reason", m.deployRejected)
+
+	const matches = "tcr_matches_total"
+	fmt.Fprintf(w, "# HELP %s Ended matches by mode and result.\n# TYPE %s counter\n", matches, matches)
+	keys := make([][2]string, 0, len(m.matches))
+	for k := range m.matches {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool { return keys[i][0]+keys[i][1] < keys[j][0]+keys[j][1] })
+	for _, k := range keys {
+		fmt.Fprintf(w, "%s{mode=%q,result=%q} %d\n", matches, k[0], k[1], m.matches[k])
+	}
+
+	const duration = "tcr_match_duration_seconds"
+	fmt.Fprintf(w, "# HELP %s Match length in game time.\n# TYPE %s histogram\n", duration, duration)
+	var cumulative uint64
+	for i, le := range matchDurationBuckets {
+		cumulative += m.durationCounts[i]
+		fmt.Fprintf(w, "%s_bucket{le=\"%g\"} %d\n", duration, le, cumulative)
+	}
+	fmt.Fprintf(w, "%s_bucket{le=\"+Inf\"} %d\n", duration, m.durationCount)
+	fmt.Fprintf(w, "%s_sum %g\n%s_count %d\n", duration, m.durationSum, duration, m.durationCount)
+}
+
+// ready reports why the server should not get traffic, or "" when it should
+func (gm *GameManager) ready() string {
+	beat := metrics.heartbeat.Load()
+	if beat == 0 {
+		return "matchmaker not started"
+	}
+	if since := time.Since(time.Unix(0, beat)); since > matchmakerStallAfter {
+		return fmt.Sprintf("matchmaker stalled for %v", since.Round(time.Second))
+	}
+	return ""
+}
+
+// StartHTTP serves /healthz, /readyz and /metrics on addr
+func StartHTTP(addr string, gm *GameManager) error {
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	slog.Info("metrics listening", "addr", addr)
+	return ServeHTTP(ln, gm)
+}
+
+// ServeHTTP serves /healthz, /readyz and /metrics on ln until it is closed
+func ServeHTTP(ln net.Listener, gm *GameManager) error {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintln(w, "ok")
+	})
+	mux.HandleFunc("/readyz", func(w http.ResponseWriter, r *http.Request) {
+		if reason := gm.ready(); reason != "" {
+			http.Error(w, reason, http.StatusServiceUnavailable)
+			return
+		}
+		fmt.Fprintln(w, "ready")
+	})
+	mux.HandleFunc("/metrics", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+		var b strings.Builder
+		gm.WritePrometheus(&b)
+		io.WriteString(w, b.String())
+	})
+
+	srv := &http.Server{Handler: mux, ReadHeaderTimeout: 5 * time.Second}
+	if err := srv.Serve(ln); !errors.Is(err, http.ErrServerClosed) && !errors.Is(err, net.ErrClosed) {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,135 @@
+// mode.go
+// Game modes: who may deploy, when turns pass and when a match ends
+
+package server
+
+import (
+	"fmt"
+	"sort"
+	"time"
+)
+
+// DefaultMode is used when a client does not ask for a mode
+const DefaultMode = "classic"
+
+// GameMode decides deploy permissions and the end of a match. Every session
+// gets its own instance, so modes may keep per-match state.
+type GameMode interface {
+	Name() string
+	// Start is called once before the first tick
+	Start(gs *GameSession)
+	// CanDeploy reports whether the player may deploy right now
+	CanDeploy(gs *GameSession, playerIdx int) bool
+	// OnDeploy is called after a deploy has been accepted
+	OnDeploy(gs *GameSession, playerIdx int)
+	// OnTick is called at the end of every tick, before state is broadcast
+	OnTick(gs *GameSession)
+	// Over reports whether the match has ended
+	Over(gs *GameSession) bool
+	// Describe fills the mode-specific fields of a state update
+	Describe(state *GameState)
+}
+
+// gameModes maps queue names to mode constructors
+var gameModes = map[string]func() GameMode{
+	"classic": func() GameMode { return &classicMode{} },
+	"quick":   func() GameMode { return &quickMode{} },
+	"turn":    func() GameMode { return &turnMode{} },
+}
+
+// NewGameMode creates a fresh instance of the named mode
+func NewGameMode(name string) (GameMode, error) {
+	if name == "" {
+		name = DefaultMode
+	}
+	newMode, ok := gameModes[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown game mode: %s", name)
+	}
+	return newMode(), nil
+}
+
+// ModeNames lists the registered modes in alphabetical order
+func ModeNames() []string {
+	names := make([]string, 0, len(gameModes))
+	for name := range gameModes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// classicMode is real-time play until a King Tower falls or the timer runs out
+type classicMode struct{}
+
+func (m *classicMode) Name() string                                  { return "classic" }
+func (m *classicMode) Start(gs *GameSession)                         {}
+func (m *classicMode) CanDeploy(gs *GameSession, playerIdx int) bool { return true }
+func (m *classicMode) OnDeploy(gs *GameSession, playerIdx int)       {}
+func (m *classicMode) OnTick(gs *GameSession)                        {}
+func (m *classicMode) Describe(state *GameState)                     {}
+
+func (m *classicMode) Over(gs *GameSession) bool {
+	return gs.checkGameEnd() || gs.timeUp()
+}
+
+// quickMode is classic play where the first destroyed tower decides the match
+type quickMode struct {
+	classicMode
+}
+
+func (m *quickMode) Name() string { return "quick" }
+
+func (m *quickMode) Over(gs *GameSession) bool {
+	for _, p := range gs.Players {
+		if p.TowersAlive() < len(p.Towers) {
+			return true
+		}
+	}
+	return m.classicMode.Over(gs)
+}
+
+// turnMode alternates deploys between players, a fixed number per turn. A
+// turn also passes when its timer runs out.
+type turnMode struct {
+	classicMode
+	turn        int // index of the player whose turn it is
+	actionsLeft int
+	turnEnds    int // tick at which the current turn passes
+}
+
+func (m *turnMode) Name() string { return "turn" }
+
+func (m *turnMode) Start(gs *GameSession) {
+	m.turn = 1 // pass hands the first turn to player 0
+	m.pass(gs)
+}
+
+func (m *turnMode) CanDeploy(gs *GameSession, playerIdx int) bool {
+	return playerIdx == m.turn && m.actionsLeft > 0
+}
+
+func (m *turnMode) OnDeploy(gs *GameSession, playerIdx int) {
+	m.actionsLeft--
+	if m.actionsLeft == 0 {
+		m.pass(gs)
+	}
+}
+
+func (m *turnMode) OnTick(gs *GameSession) {
+	if gs.ticks >= m.turnEnds {
+		m.pass(gs)
+	}
+}
+
+func (m *turnMode) Describe(state *GameState) {
+	state.Turn = m.turn
+	state.ActionsLeft = m.actionsLeft
+}
+
+// pass hands the turn to the other player
+func (m *turnMode) pass(gs *GameSession) {
+	m.turn = 1 - m.turn
+	m.actionsLeft = gs.Rules.TurnActions
+	m.turnEnds = gs.ticks + gs.ticksFor(time.Duration(gs.Rules.TurnSec)*time.Second)
+}
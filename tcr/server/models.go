@@ -3,9 +3,9 @@ package server
 
 import (
 	"encoding/json"
-	"log"
 	"net"
 	"os"
+	"sync/atomic"
 	"tcr/specs"
 )
 
@@ -17,9 +17,20 @@ type User struct {
 	Exp          int     `json:"exp"`
 	NextLevel    int     `json:"next_level"`
 	Multiplier   float64 `json:"multiplier"`
+	Banned       bool    `json:"banned,omitempty"`
 	isLogin      bool
 }
 
+// level returns the user's progression as used in a match
+func (u *User) level() Level {
+	return Level{
+		Level:      u.Level,
+		Exp:        u.Exp,
+		NextLevel:  u.NextLevel,
+		Multiplier: u.Multiplier,
+	}
+}
+
 // Player represents a player in a game session
 type Player struct {
 	Conn         net.Conn
@@ -28,6 +39,11 @@ type Player struct {
 	Towers       []*specs.TowerSpec
 	Level        Level
 	ActiveTroops []*TroopInstance // Or a similar struct you define
+	Bot          *Bot             // set for AI-controlled players, which have no Conn
+	resync       atomic.Bool      // send a full state_update next tick
+	udp          *udpPeer         // UDP binding from login, nil for TCP only
+	onUDP        bool             // last tick's state went over UDP
+	forfeit      string           // EndSurrender or EndDisconnect once the player gave up
 }
 
 // PDU represents a Protocol Data Unit for client-server communication
@@ -46,6 +62,17 @@ func (p *Player) NextAliveTower() *specs.TowerSpec {
 	return nil
 }
 
+// TowersAlive counts the player's towers that still have health
+func (p *Player) TowersAlive() int {
+	alive := 0
+	for _, t := range p.Towers {
+		if t.Health > 0 {
+			alive++
+		}
+	}
+	return alive
+}
+
 func (p *Player) DestroyTower(t *specs.TowerSpec) {
 	t.Health = 0
 }
@@ -59,7 +86,9 @@ func (p *Player) KingTowerDestroyed() bool {
 	return false
 }
 
-func (p *Player) HealWeakestTower(amount int) {
+// HealWeakestTower heals the standing tower with the least HP, up to its
+// cap, and returns it with the HP actually restored
+func (p *Player) HealWeakestTower(amount int) (*specs.TowerSpec, int) {
 	var weakest *specs.TowerSpec
 	minHP := 999999
 	for _, t := range p.Towers {
@@ -68,25 +97,30 @@ func (p *Player) HealWeakestTower(amount int) {
 			minHP = t.Health
 		}
 	}
-	if weakest != nil {
-		weakest.Health += amount
-		//Check limit
-		if weakest.Type == "king" {
-			if weakest.Health >= 6000 {
-				weakest.Health = 6000
-			}
-		} else if weakest.Type == "guard" {
-			if weakest.Health >= 3000 {
-				weakest.Health = 3000
-			}
+	if weakest == nil {
+		return nil, 0
+	}
+	before := weakest.Health
+	weakest.Health += amount
+	//Check limit
+	if weakest.Type == "king" {
+		if weakest.Health >= 6000 {
+			weakest.Health = 6000
+		}
+	} else if weakest.Type == "guard" {
+		if weakest.Health >= 3000 {
+			weakest.Health = 3000
 		}
-		log.Println("Queen Heal weaknest tower '", weakest.Name, "' for ", amount, ". New Health is: ", weakest.Health)
 	}
+	return weakest, weakest.Health - before
 }
 
 // LoadUsers reads user data from a JSON file
 func LoadUsers(filename string) (map[string]User, error) {
 	data, err := os.ReadFile(filename)
+	if os.IsNotExist(err) {
+		return make(map[string]User), nil // first run: saved on the first register
+	}
 	if err != nil {
 		return nil, err
 	}
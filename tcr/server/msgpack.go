@@ -0,0 +1,526 @@
+// msgpack.go
+// A MessagePack codec for PDUs. Payloads are JSON throughout the server, so
+// the codec transcodes them byte by byte in a single pass (nil, bool,
+// integers, floats, strings, arrays and string-keyed maps), without decoding
+// into Go values, and every PDU type works without its own binary layout.
+
+package server
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"unicode/utf8"
+)
+
+var (
+	errMsgpackShort = errors.New("msgpack: unexpected end of data")
+	errNotJSON      = errors.New("msgpack: payload is not JSON")
+)
+
+type msgpackCodec struct{}
+
+func (msgpackCodec) Name() string { return "msgpack" }
+
+// Marshal encodes the PDU as the array [type, data]
+func (msgpackCodec) Marshal(pdu PDU) ([]byte, error) {
+	buf := make([]byte, 0, len(pdu.Data)+len(pdu.Type)+8)
+	buf = append(buf, 0x92) // fixarray of 2
+	buf = appendString(buf, pdu.Type)
+	e := jsonTranscoder{src: pdu.Data}
+	e.skipSpace()
+	if e.pos == len(e.src) {
+		return append(buf, 0xc0), nil // no payload
+	}
+	buf, err := e.value(buf)
+	if err != nil {
+		return nil, err
+	}
+	if e.skipSpace(); e.pos != len(e.src) {
+		return nil, errNotJSON
+	}
+	return buf, nil
+}
+
+// Unmarshal decodes a [type, data] array; data comes back as JSON
+func (msgpackCodec) Unmarshal(data []byte, pdu *PDU) error {
+	d := msgpackDecoder{data: data}
+	n, err := d.arrayLen()
+	if err != nil {
+		return err
+	}
+	if n != 2 {
+		return fmt.Errorf("msgpack: PDU array has %d elements, want 2", n)
+	}
+	name, err := d.string()
+	if err != nil {
+		return fmt.Errorf("msgpack: PDU type: %w", err)
+	}
+	raw, err := d.json(make([]byte, 0, 2*len(data)))
+	if err != nil {
+		return err
+	}
+	if d.pos != len(data) {
+		return fmt.Errorf("msgpack: %d trailing bytes", len(data)-d.pos)
+	}
+	pdu.Type, pdu.Data = name, raw
+	return nil
+}
+
+// jsonTranscoder reads JSON values and appends them as MessagePack
+type jsonTranscoder struct {
+	src []byte
+	pos int
+}
+
+func (e *jsonTranscoder) skipSpace() {
+	for e.pos < len(e.src) {
+		switch e.src[e.pos] {
+		case ' ', '\t', '\n', '\r':
+			e.pos++
+		default:
+			return
+		}
+	}
+}
+
+// value transcodes the JSON value at pos
+func (e *jsonTranscoder) value(buf []byte) ([]byte, error) {
+	e.skipSpace()
+	if e.pos == len(e.src) {
+		return nil, errNotJSON
+	}
+	switch c := e.src[e.pos]; {
+	case c == '{':
+		return e.container(buf, '}', 0x80, 0xde, 0xdf)
+	case c == '[':
+		return e.container(buf, ']', 0x90, 0xdc, 0xdd)
+	case c == '"':
+		s, err := e.string()
+		if err != nil {
+			return nil, err
+		}
+		return appendString(buf, s), nil
+	case c == 't':
+		return e.literal(buf, "true", 0xc3)
+	case c == 'f':
+		return e.literal(buf, "false", 0xc2)
+	case c == 'n':
+		return e.literal(buf, "null", 0xc0)
+	case c == '-' || c >= '0' && c <= '9':
+		return e.number(buf)
+	default:
+		return nil, errNotJSON
+	}
+}
+
+// container transcodes an object or array. Its length is only known at the
+// end, so a one-byte fix header is reserved and widened if it overflows.
+func (e *jsonTranscoder) container(buf []byte, end, fix, b16, b32 byte) ([]byte, error) {
+	object := end == '}'
+	e.pos++ // the opening bracket
+	start := len(buf)
+	buf = append(buf, fix)
+	n := 0
+	for {
+		e.skipSpace()
+		if e.pos < len(e.src) && e.src[e.pos] == end && n == 0 {
+			e.pos++
+			break
+		}
+		var err error
+		if object {
+			if e.pos == len(e.src) || e.src[e.pos] != '"' {
+				return nil, errNotJSON
+			}
+			key, err := e.string()
+			if err != nil {
+				return nil, err
+			}
+			buf = appendString(buf, key)
+			if e.skipSpace(); e.pos == len(e.src) || e.src[e.pos] != ':' {
+				return nil, errNotJSON
+			}
+			e.pos++
+		}
+		if buf, err = e.value(buf); err != nil {
+			return nil, err
+		}
+		n++
+		if e.skipSpace(); e.pos == len(e.src) {
+			return nil, errNotJSON
+		}
+		if c := e.src[e.pos]; c == ',' {
+			e.pos++
+		} else if c == end {
+			e.pos++
+			break
+		} else {
+			return nil, errNotJSON
+		}
+	}
+
+	if n <= 15 {
+		buf[start] = fix | byte(n)
+		return buf, nil
+	}
+	header := []byte{b32, 0, 0, 0, 0}
+	binary.BigEndian.PutUint32(header[1:], uint32(n))
+	if n <= math.MaxUint16 {
+		header = []byte{b16, byte(n >> 8), byte(n)}
+	}
+	// Shift the elements right to make room for the wider header
+	extra := len(header) - 1
+	buf = append(buf, header[1:]...)
+	copy(buf[start+len(header):], buf[start+1:len(buf)-extra])
+	copy(buf[start:], header)
+	return buf, nil
+}
+
+// string reads a JSON string; only strings with escapes are copied
+func (e *jsonTranscoder) string() ([]byte, error) {
+	start := e.pos
+	e.pos++ // the opening quote
+	for e.pos < len(e.src) {
+		switch c := e.src[e.pos]; {
+		case c == '"':
+			e.pos++
+			return e.src[start+1 : e.pos-1], nil
+		case c == '\\':
+			return e.escapedString(start)
+		case c < 0x20:
+			return nil, errNotJSON
+		}
+		e.pos++
+	}
+	return nil, errNotJSON
+}
+
+// escapedString reads a JSON string with escapes from start, leaving their
+// rules to encoding/json
+func (e *jsonTranscoder) escapedString(start int) ([]byte, error) {
+	for e.pos < len(e.src) {
+		switch e.src[e.pos] {
+		case '\\':
+			e.pos += 2
+			continue
+		case '"':
+			e.pos++
+			var s string
+			if err := json.Unmarshal(e.src[start:e.pos], &s); err != nil {
+				return nil, errNotJSON
+			}
+			return []byte(s), nil
+		}
+		e.pos++
+	}
+	return nil, errNotJSON
+}
+
+func (e *jsonTranscoder) literal(buf []byte, word string, code byte) ([]byte, error) {
+	if len(e.src)-e.pos < len(word) || string(e.src[e.pos:e.pos+len(word)]) != word {
+		return nil, errNotJSON
+	}
+	e.pos += len(word)
+	return append(buf, code), nil
+}
+
+// number encodes integers as such and everything else as a float64
+func (e *jsonTranscoder) number(buf []byte) ([]byte, error) {
+	start := e.pos
+	integer := true
+	for ; e.pos < len(e.src); e.pos++ {
+		c := e.src[e.pos]
+		if c == '.' || c == 'e' || c == 'E' || c == '+' || c == '-' && e.pos > start {
+			integer = false
+		} else if c != '-' && (c < '0' || c > '9') {
+			break
+		}
+	}
+	text := string(e.src[start:e.pos])
+	if integer {
+		if i, err := strconv.ParseInt(text, 10, 64); err == nil {
+			return appendInt(buf, i), nil
+		}
+	}
+	f, err := strconv.ParseFloat(text, 64)
+	if err != nil {
+		return nil, errNotJSON
+	}
+	buf = append(buf, 0xcb)
+	return binary.BigEndian.AppendUint64(buf, math.Float64bits(f)), nil
+}
+
+func appendString[S string | []byte](buf []byte, s S) []byte {
+	switch n := len(s); {
+	case n <= 31:
+		buf = append(buf, 0xa0|byte(n))
+	case n <= math.MaxUint8:
+		buf = append(buf, 0xd9, byte(n))
+	case n <= math.MaxUint16:
+		buf = binary.BigEndian.AppendUint16(append(buf, 0xda), uint16(n))
+	default:
+		buf = binary.BigEndian.AppendUint32(append(buf, 0xdb), uint32(n))
+	}
+	return append(buf, s...)
+}
+
+func appendInt(buf []byte, i int64) []byte {
+	switch {
+	case i >= 0 && i <= 127:
+		return append(buf, byte(i))
+	case i < 0 && i >= -32:
+		return append(buf, byte(i))
+	case i >= math.MinInt8 && i <= math.MaxInt8:
+		return append(buf, 0xd0, byte(i))
+	case i >= math.MinInt16 && i <= math.MaxInt16:
+		return binary.BigEndian.AppendUint16(append(buf, 0xd1), uint16(i))
+	case i >= math.MinInt32 && i <= math.MaxInt32:
+		return binary.BigEndian.AppendUint32(append(buf, 0xd2), uint32(i))
+	default:
+		return binary.BigEndian.AppendUint64(append(buf, 0xd3), uint64(i))
+	}
+}
+
+// msgpackDecoder reads values from a buffer
+type msgpackDecoder struct {
+	data []byte
+	pos  int
+}
+
+func (d *msgpackDecoder) next(n int) ([]byte, error) {
+	if n < 0 || len(d.data)-d.pos < n {
+		return nil, errMsgpackShort
+	}
+	b := d.data[d.pos : d.pos+n]
+	d.pos += n
+	return b, nil
+}
+
+func (d *msgpackDecoder) uint(n int) (uint64, error) {
+	b, err := d.next(n)
+	if err != nil {
+		return 0, err
+	}
+	var v uint64
+	for _, c := range b {
+		v = v<<8 | uint64(c)
+	}
+	return v, nil
+}
+
+// arrayLen reads an array header
+func (d *msgpackDecoder) arrayLen() (int, error) {
+	b, err := d.next(1)
+	if err != nil {
+		return 0, err
+	}
+	switch c := b[0]; {
+	case c&0xf0 == 0x90:
+		return int(c & 0x0f), nil
+	case c == 0xdc:
+		n, err := d.uint(2)
+		return int(n), err
+	case c == 0xdd:
+		n, err := d.uint(4)
+		return int(n), err
+	default:
+		return 0, fmt.Errorf("msgpack: expected array, got 0x%02x", c)
+	}
+}
+
+// string reads a string value
+func (d *msgpackDecoder) string() (string, error) {
+	b, err := d.bytes()
+	return string(b), err
+}
+
+// bytes reads a string value without copying it
+func (d *msgpackDecoder) bytes() ([]byte, error) {
+	b, err := d.next(1)
+	if err != nil {
+		return nil, err
+	}
+	c := b[0]
+	n := uint64(c & 0x1f)
+	switch {
+	case c&0xe0 == 0xa0:
+	case c == 0xd9 || c == 0xda || c == 0xdb:
+		if n, err = d.uint(1 << (c - 0xd9)); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("msgpack: expected string, got 0x%02x", c)
+	}
+	if n > uint64(len(d.data)-d.pos) {
+		return nil, errMsgpackShort
+	}
+	return d.next(int(n))
+}
+
+// json appends one value as JSON
+func (d *msgpackDecoder) json(buf []byte) ([]byte, error) {
+	b, err := d.next(1)
+	if err != nil {
+		return nil, err
+	}
+	c := b[0]
+	switch {
+	case c <= 0x7f:
+		return strconv.AppendInt(buf, int64(c), 10), nil
+	case c >= 0xe0:
+		return strconv.AppendInt(buf, int64(int8(c)), 10), nil
+	case c&0xe0 == 0xa0:
+		d.pos--
+		return d.jsonString(buf)
+	case c&0xf0 == 0x90:
+		return d.jsonArray(buf, uint64(c&0x0f))
+	case c&0xf0 == 0x80:
+		return d.jsonObject(buf, uint64(c&0x0f))
+	}
+
+	switch c {
+	case 0xc0:
+		return append(buf, "null"...), nil
+	case 0xc2:
+		return append(buf, "false"...), nil
+	case 0xc3:
+		return append(buf, "true"...), nil
+	case 0xcc, 0xcd, 0xce, 0xcf:
+		v, err := d.uint(1 << (c - 0xcc))
+		return strconv.AppendUint(buf, v, 10), err
+	case 0xd0, 0xd1, 0xd2, 0xd3:
+		size := 1 << (c - 0xd0)
+		v, err := d.uint(size)
+		shift := 64 - 8*size
+		return strconv.AppendInt(buf, int64(v<<shift)>>shift, 10), err
+	case 0xca:
+		v, err := d.uint(4)
+		if err != nil {
+			return nil, err
+		}
+		return appendFloat(buf, float64(math.Float32frombits(uint32(v))), 32)
+	case 0xcb:
+		v, err := d.uint(8)
+		if err != nil {
+			return nil, err
+		}
+		return appendFloat(buf, math.Float64frombits(v), 64)
+	case 0xd9, 0xda, 0xdb:
+		d.pos--
+		return d.jsonString(buf)
+	case 0xdc, 0xdd:
+		n, err := d.uint(2 << (c - 0xdc))
+		if err != nil {
+			return nil, err
+		}
+		return d.jsonArray(buf, n)
+	case 0xde, 0xdf:
+		n, err := d.uint(2 << (c - 0xde))
+		if err != nil {
+			return nil, err
+		}
+		return d.jsonObject(buf, n)
+	default:
+		return nil, fmt.Errorf("msgpack: unsupported type 0x%02x", c)
+	}
+}
+
+func (d *msgpackDecoder) jsonArray(buf []byte, n uint64) ([]byte, error) {
+	if n > uint64(len(d.data)-d.pos) {
+		return nil, errMsgpackShort // each element takes at least a byte
+	}
+	buf = append(buf, '[')
+	var err error
+	for i := uint64(0); i < n; i++ {
+		if i > 0 {
+			buf = append(buf, ',')
+		}
+		if buf, err = d.json(buf); err != nil {
+			return nil, err
+		}
+	}
+	return append(buf, ']'), nil
+}
+
+func (d *msgpackDecoder) jsonObject(buf []byte, n uint64) ([]byte, error) {
+	if 2*n > uint64(len(d.data)-d.pos) {
+		return nil, errMsgpackShort
+	}
+	buf = append(buf, '{')
+	var err error
+	for i := uint64(0); i < n; i++ {
+		if i > 0 {
+			buf = append(buf, ',')
+		}
+		if buf, err = d.jsonString(buf); err != nil {
+			return nil, fmt.Errorf("msgpack: map key: %w", err)
+		}
+		buf = append(buf, ':')
+		if buf, err = d.json(buf); err != nil {
+			return nil, err
+		}
+	}
+	return append(buf, '}'), nil
+}
+
+// jsonString appends a string value as a quoted JSON string
+func (d *msgpackDecoder) jsonString(buf []byte) ([]byte, error) {
+	s, err := d.bytes()
+	if err != nil {
+		return nil, err
+	}
+	buf = append(buf, '"')
+	start := 0
+	for i := 0; i < len(s); {
+		c := s[i]
+		if c >= 0x20 && c != '"' && c != '\\' && c < utf8.RuneSelf {
+			i++
+			continue
+		}
+		if c >= utf8.RuneSelf {
+			r, size := utf8.DecodeRune(s[i:])
+			if r != utf8.RuneError || size != 1 {
+				i += size
+				continue
+			}
+		}
+		buf = append(buf, s[start:i]...)
+		switch c {
+		case '"', '\\':
+			buf = append(buf, '\\', c)
+		case '\n':
+			buf = append(buf, '\\', 'n')
+		case '\r':
+			buf = append(buf, '\\', 'r')
+		case '\t':
+			buf = append(buf, '\\', 't')
+		default:
+			if c < 0x20 {
+				buf = append(buf, `\u00`...)
+				buf = append(buf, "0123456789abcdef"[c>>4], "0123456789abcdef"[c&0xf])
+			} else {
+				buf = append(buf, "\ufffd"...) // invalid UTF-8, as encoding/json does
+			}
+		}
+		i++
+		start = i
+	}
+	buf = append(buf, s[start:]...)
+	return append(buf, '"'), nil
+}
+
+// appendFloat formats a float the way encoding/json does
+func appendFloat(buf []byte, f float64, bits int) ([]byte, error) {
+	if math.IsInf(f, 0) || math.IsNaN(f) {
+		return nil, fmt.Errorf("msgpack: %v has no JSON form", f)
+	}
+	format := byte('f')
+	if abs := math.Abs(f); abs != 0 && (abs < 1e-6 || abs >= 1e21) {
+		format = 'e'
+	}
+	return strconv.AppendFloat(buf, f, format, -1, bits), nil
+}
@@ -4,12 +4,15 @@ package server
 import (
 	"encoding/binary"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
-	"log"
+	"log/slog"
 	"net"
 	"os"
+	"sync/atomic"
 	"tcr/specs"
+	"time"
 )
 
 // ClientHandler holds connection and user reference
@@ -18,11 +21,22 @@ type ClientHandler struct {
 	Conn      net.Conn
 	User      *User
 	HandlerID int
+	Mode      string // queue the client joined
+	queuedAt  time.Time
+	udp       *udpPeer // set when the client asked for UDP at login
 }
 
-// SendPDU sends a PDU to the server
+// SendPDU sends a PDU to the server. A frame takes more than one write, so
+// goroutines sharing a player connection send while holding mutex.
 func SendPDU(conn net.Conn, pdu PDU) error {
-	data, err := json.Marshal(pdu)
+	err := writePDU(conn, pdu)
+	metrics.countOut(pdu.Type, err)
+	return err
+}
+
+// writePDU frames and writes one PDU in the connection's codec
+func writePDU(conn net.Conn, pdu PDU) error {
+	data, err := codecOf(conn).Marshal(pdu)
 	if err != nil {
 		return fmt.Errorf("marshal error: %w", err)
 	}
@@ -32,7 +46,6 @@ func SendPDU(conn net.Conn, pdu PDU) error {
 	binary.BigEndian.PutUint32(lenBuf, uint32(len(data)))
 	// log.Println("Send length:", lenBuf)
 	if _, err := conn.Write(lenBuf); err != nil {
-		log.Printf("write length error: %v", err)
 		return fmt.Errorf("write length error: %w", err)
 	}
 
@@ -60,15 +73,20 @@ func ReceivePDU(conn net.Conn) (PDU, error) {
 	}
 	// log.Printf("Received message %d", data)
 	var pdu PDU
-	if err := json.Unmarshal(data, &pdu); err != nil {
+	if err := codecOf(conn).Unmarshal(data, &pdu); err != nil {
 		return PDU{}, fmt.Errorf("unmarshal error: %w", err)
 	}
+	metrics.countIn(pdu.Type)
 	// log.Printf("Received message data: %s", string(pdu.Data))
 	// log.Printf("Received message type: %s", string(pdu.Type))
 	return pdu, nil
 }
 
+// saveUsers writes the accounts to path; an empty path keeps them in memory
 func saveUsers(path string, users map[string]User) error {
+	if path == "" {
+		return nil
+	}
 	data, err := json.MarshalIndent(users, "", "  ")
 	if err != nil {
 		return err
@@ -77,30 +95,60 @@ func saveUsers(path string, users map[string]User) error {
 }
 
 // HandleConnection manages a single client connection
-const userFilePath = "./players.json"
-
-func HandleConnection(conn net.Conn, users map[string]User, matchQueue chan *ClientHandler, id int) {
+func HandleConnection(conn net.Conn, gm *GameManager, id int) {
+	users := gm.users
+	clog := slog.With("conn", id, "remote", conn.RemoteAddr().String())
+	clog.Debug("client connected")
 	for {
 		pdu, err := ReceivePDU(conn)
 		if err != nil {
-			log.Println("receive error:", err)
+			clog.Info("client disconnected", "err", err)
+			conn.Close()
 			return
 		}
+		clog.Debug("pdu received", "pdu", pdu.Type)
 
 		var creds struct {
-			Username string `json:"username"`
-			Password string `json:"password"`
+			Username string   `json:"username"`
+			Password string   `json:"password"`
+			Mode     string   `json:"mode"`
+			Bot      string   `json:"difficulty"` // play_vs_bot only
+			Token    string   `json:"token"`      // admin PDUs only
+			Codecs   []string `json:"codecs"`     // hello only
+			UDP      bool     `json:"udp"`        // login and play_vs_bot only
 		}
 
 		if err := json.Unmarshal(pdu.Data, &creds); err != nil {
-			log.Println("unmarshal credentials:", err)
+			clog.Warn("bad pdu payload", "pdu", pdu.Type, "err", err)
+			conn.Close()
 			return
 		}
 
 		switch pdu.Type {
 
+		case "hello":
+			// Reply in the current codec, then switch to the negotiated one
+			codec, ok := pickCodec(creds.Codecs)
+			if !ok {
+				SendPDU(conn, PDU{
+					Type: "hello_resp",
+					Data: []byte(`{"status":"ERR:NoCommonCodec"}`),
+				})
+				continue
+			}
+			SendPDU(conn, PDU{
+				Type: "hello_resp",
+				Data: []byte(fmt.Sprintf(`{"status":"OK","codec":"%s"}`, codec.Name())),
+			})
+			conn = WithCodec(conn, codec)
+			clog.Debug("codec negotiated", "codec", codec.Name())
+			continue
+
 		case "register":
+			// Sessions update the same map under mutex when matches end
+			mutex.Lock()
 			if _, exists := users[creds.Username]; exists {
+				mutex.Unlock()
 				SendPDU(conn, PDU{
 					Type: "register_resp",
 					Data: []byte(`{"status":"ERR:UserExists"}`),
@@ -118,9 +166,10 @@ func HandleConnection(conn net.Conn, users map[string]User, matchQueue chan *Cli
 			}
 
 			users[creds.Username] = newUser
-
-			if err := saveUsers(userFilePath, users); err != nil {
-				log.Println("error saving users:", err)
+			err := saveUsers(gm.userFile, users)
+			mutex.Unlock()
+			if err != nil {
+				clog.Error("save users failed", "user", creds.Username, "err", err)
 				SendPDU(conn, PDU{
 					Type: "register_resp",
 					Data: []byte(`{"status":"ERR:SaveFailed"}`),
@@ -132,35 +181,187 @@ func HandleConnection(conn net.Conn, users map[string]User, matchQueue chan *Cli
 				Type: "register_resp",
 				Data: []byte(`{"status":"OK"}`),
 			})
-			log.Printf("User registered: %s\n", creds.Username)
+			clog.Info("user registered", "user", creds.Username)
 
 			// ✅ After registration, let them login in next loop
 			continue
 
-		case "login":
+		case "login", "play_vs_bot":
+			// play_vs_bot logs in like login but starts a bot match at once
+			respType := pdu.Type + "_resp"
+			mutex.Lock()
 			stored, ok := users[creds.Username]
+			mutex.Unlock()
+			if ok && stored.PasswordHash == creds.Password && stored.Banned {
+				SendPDU(conn, PDU{
+					Type: respType,
+					Data: []byte(`{"status":"ERR:Banned"}`),
+				})
+				continue // ❗ Allow retry
+			}
 			if !ok || stored.PasswordHash != creds.Password || stored.isLogin {
 				SendPDU(conn, PDU{
-					Type: "login_resp",
+					Type: respType,
 					Data: []byte(`{"status":"ERR. Incorrect password or usernam or user currently login"}`),
 				})
 				continue // ❗ Allow retry
 			}
 
+			if creds.Mode == "" {
+				creds.Mode = DefaultMode
+			}
+			if _, err := NewGameMode(creds.Mode); err != nil {
+				SendPDU(conn, PDU{
+					Type: respType,
+					Data: []byte(`{"status":"ERR:UnknownMode"}`),
+				})
+				continue // ❗ Allow retry
+			}
+			if pdu.Type == "play_vs_bot" {
+				if _, err := NewBot(creds.Bot, nil, 0); err != nil {
+					SendPDU(conn, PDU{
+						Type: respType,
+						Data: []byte(`{"status":"ERR:UnknownDifficulty"}`),
+					})
+					continue // ❗ Allow retry
+				}
+			}
+
+			// Clients that asked for UDP get a token to bind their address with
+			var peer *udpPeer
+			resp := []byte(`{"status":"OK"}`)
+			if creds.UDP && gm.udp != nil {
+				if peer, err = gm.udp.issue(); err != nil {
+					clog.Warn("udp token failed", "user", creds.Username, "err", err)
+				} else {
+					resp = []byte(fmt.Sprintf(`{"status":"OK","udp_port":%d,"udp_token":"%s"}`,
+						gm.udp.Port(), peer.token))
+				}
+			}
+
+			mutex.Lock()
 			stored.isLogin = true
 			users[creds.Username] = stored
+			mutex.Unlock()
 			SendPDU(conn, PDU{
-				Type: "login_resp",
-				Data: []byte(`{"status":"OK"}`),
+				Type: respType,
+				Data: resp,
 			})
-			log.Printf("User logged in: %s\n", creds.Username)
-			log.Println("User logged in: ", stored.isLogin)
-			// ✅ Success: enqueue and exit loop
-			handler := &ClientHandler{Users: users, Conn: conn, User: &stored, HandlerID: id}
-			matchQueue <- handler
+			clog.Info("user logged in", "user", creds.Username, "pdu", pdu.Type, "mode", creds.Mode, "udp", peer != nil)
+			// The current cards, so the client needs no specs of its own
+			catalog, _ := json.Marshal(NewCardCatalog(gm.sessionOptions().Specs))
+			SendPDU(conn, PDU{Type: "card_catalog", Data: catalog})
+			gm.setOnline(creds.Username, conn)
+			// ✅ Success: enqueue (or start the bot match) and exit loop
+			handler := &ClientHandler{Users: users, Conn: conn, User: &stored, HandlerID: id, Mode: creds.Mode, udp: peer}
+			if pdu.Type == "play_vs_bot" {
+				go gm.StartBotSession(handler, creds.Bot)
+				return
+			}
+			gm.Enqueue(handler)
 			return
 
+		case "list_matches":
+			data, _ := json.Marshal(struct {
+				Matches []MatchInfo `json:"matches"`
+			}{gm.Matches()})
+			SendPDU(conn, PDU{Type: "list_matches_resp", Data: data})
+			continue
+
+		case "spectate":
+			var req struct {
+				MatchID  string `json:"match_id"`
+				DelaySec int    `json:"delay_sec"`
+			}
+			json.Unmarshal(pdu.Data, &req)
+			gs, ok := gm.Session(req.MatchID)
+			if !ok {
+				SendPDU(conn, PDU{
+					Type: "spectate_resp",
+					Data: []byte(`{"status":"ERR:NotFound"}`),
+				})
+				continue
+			}
+
+			spectator := NewSpectator(conn, time.Duration(req.DelaySec)*time.Second, gs.TickInterval)
+			if err := gs.addSpectator(spectator, gm.config.Game.MaxSpectators); err != nil {
+				SendPDU(conn, PDU{
+					Type: "spectate_resp",
+					Data: []byte(fmt.Sprintf(`{"status":"ERR:%s"}`, err)),
+				})
+				continue
+			}
+			SendPDU(conn, PDU{
+				Type: "spectate_resp",
+				Data: []byte(fmt.Sprintf(`{"status":"OK","delay_sec":%d}`, int(spectator.Delay.Seconds()))),
+			})
+			// Blocks until the match ends; the client may then list or log in
+			if err := spectator.Watch(gs); err != nil {
+				clog.Info("spectator disconnected", "match", req.MatchID, "err", err)
+				conn.Close()
+				return
+			}
+			continue
+
+		case "replay_list":
+			infos, err := listReplays(gm.replayDir)
+			if err != nil {
+				clog.Warn("list replays failed", "err", err)
+			}
+			data, _ := json.Marshal(struct {
+				Replays []ReplayInfo `json:"replays"`
+			}{infos})
+			SendPDU(conn, PDU{Type: "replay_list_resp", Data: data})
+			continue
+
+		case "replay_fetch":
+			var req struct {
+				ID string `json:"id"`
+			}
+			json.Unmarshal(pdu.Data, &req)
+			raw, err := readReplayFile(gm.replayDir, req.ID)
+			if err != nil {
+				clog.Info("fetch replay failed", "replay", req.ID, "err", err)
+				SendPDU(conn, PDU{
+					Type: "replay_fetch_resp",
+					Data: []byte(`{"status":"ERR:NotFound"}`),
+				})
+				continue
+			}
+			// []byte marshals as base64
+			data, _ := json.Marshal(struct {
+				Status string `json:"status"`
+				ID     string `json:"id"`
+				Replay []byte `json:"replay"`
+			}{"OK", req.ID, raw})
+			SendPDU(conn, PDU{Type: "replay_fetch_resp", Data: data})
+			continue
+
+		case "reload_specs":
+			if !gm.isAdmin(creds.Token) {
+				SendPDU(conn, PDU{
+					Type: "reload_specs_resp",
+					Data: []byte(`{"status":"ERR:Unauthorized"}`),
+				})
+				continue
+			}
+			hash, err := gm.ReloadSpecs()
+			if err != nil {
+				clog.Warn("specs reload rejected", "err", err)
+				data, _ := json.Marshal(struct {
+					Status string `json:"status"`
+				}{"ERR:" + err.Error()})
+				SendPDU(conn, PDU{Type: "reload_specs_resp", Data: data})
+				continue
+			}
+			SendPDU(conn, PDU{
+				Type: "reload_specs_resp",
+				Data: []byte(fmt.Sprintf(`{"status":"OK","specs_hash":"%s"}`, hash)),
+			})
+			continue
+
 		default:
+			clog.Debug("unknown pdu", "pdu", pdu.Type)
 			SendPDU(conn, PDU{
 				Type: "error",
 				Data: []byte(`{"msg":"invalid command"}`),
@@ -170,99 +371,55 @@ func HandleConnection(conn net.Conn, users map[string]User, matchQueue chan *Cli
 	}
 }
 
-// StartServer begins listening and handles matchmaking
-func StartServer(addr string, users map[string]User,
-	troopSpecs map[string]specs.TroopSpec, towerSpecs map[string]specs.TowerSpec,
-	matchQueue chan *ClientHandler) error {
+// connIDs numbers connections across the TCP and WebSocket listeners
+var connIDs atomic.Int64
 
+func nextConnID() int {
+	return int(connIDs.Add(1))
+}
+
+// StartServer begins listening and hands logged-in clients to the manager's
+// matchmaking
+func StartServer(addr string, gm *GameManager) error {
 	ln, err := net.Listen("tcp", addr)
 	if err != nil {
 		return err
 	}
-	log.Println("Server listening on", addr)
-
-	go func() {
-		for {
-			log.Println("Waiting for first client...")
-			c1 := <-matchQueue
-			log.Printf("Got first client: %v", c1.User.Username)
-
-			log.Println("Waiting for second client...")
-			c2 := <-matchQueue
-			log.Printf("Got second client: %v", c2.User.Username)
+	slog.Info("server listening", "addr", addr)
+	return Serve(ln, gm)
+}
 
-			log.Println("Starting game session...")
-			go StartGameSession(c1, c2, troopSpecs, towerSpecs)
-		}
-	}()
-	handlerID := 0
+// Serve accepts clients on ln until it is closed
+func Serve(ln net.Listener, gm *GameManager) error {
+	gm.StartMatchmaking()
+	gm.WatchSpecs()
 	for {
 		conn, err := ln.Accept()
+		if errors.Is(err, net.ErrClosed) {
+			return nil
+		}
 		if err != nil {
-			log.Println("accept:", err)
+			slog.Error("accept failed", "err", err)
 			continue
 		}
-		handlerID++
-		go HandleConnection(conn, users, matchQueue, handlerID)
+		go HandleConnection(trackConn(conn), gm, nextConnID())
 	}
 }
 
-// StartGameSession initializes GameSession and triggers startGame
-func StartGameSession(c1, c2 *ClientHandler,
-	troopSpecs map[string]specs.TroopSpec, towerSpecs map[string]specs.TowerSpec) {
-	log.Println("accept:", c1, c2)
-	// Send game_start PDU
-	startData := fmt.Sprintf(`{"players":[%d,%d]}`, c1.HandlerID, c2.HandlerID)
-	// fmt.Println("startData: ", startData)
-	if err := SendPDU(c1.Conn, PDU{
-		Type: "game_start",
-		Data: []byte(startData)}); err != nil {
-	}
-
-	if err := SendPDU(c2.Conn, PDU{
-		Type: "game_start",
-		Data: []byte(startData)}); err != nil {
-	}
-	// fmt.Println("Send success")
-
-	// Initialize session
-	players := [2]*Player{
-		{
-			Conn:     c1.Conn,
-			Username: c1.User.Username,
-			Mana:     5,
-			Towers: []*specs.TowerSpec{
-				cloneTowerSpec(towerSpecs["guard_tower"]),
-				cloneTowerSpec(towerSpecs["guard_tower"]),
-				cloneTowerSpec(towerSpecs["king_tower"]),
-			},
-			Level: Level{
-				Level:      c1.User.Level,
-				Exp:        c1.User.Exp,
-				NextLevel:  c1.User.NextLevel,
-				Multiplier: c1.User.Multiplier,
-			},
-		},
-		{
-			Conn:     c2.Conn,
-			Username: c2.User.Username,
-			Mana:     5,
-			Towers: []*specs.TowerSpec{
-				cloneTowerSpec(towerSpecs["guard_tower"]),
-				cloneTowerSpec(towerSpecs["guard_tower"]),
-				cloneTowerSpec(towerSpecs["king_tower"]),
-			},
-			Level: Level{
-				Level:      c2.User.Level,
-				Exp:        c2.User.Exp,
-				NextLevel:  c2.User.NextLevel,
-				Multiplier: c2.User.Multiplier,
-			},
+// newPlayer sets up a player with fresh towers and the starting mana
+func newPlayer(conn net.Conn, username string, level Level,
+	towerSpecs map[string]specs.TowerSpec, rules specs.Rules) *Player {
+	return &Player{
+		Conn:     conn,
+		Username: username,
+		Mana:     rules.StartingMana,
+		Towers: []*specs.TowerSpec{
+			cloneTowerSpec(towerSpecs["guard_tower"]),
+			cloneTowerSpec(towerSpecs["guard_tower"]),
+			cloneTowerSpec(towerSpecs["king_tower"]),
 		},
+		Level: level,
 	}
-	gs := NewGameSession(c1.Users, players, troopSpecs, towerSpecs)
-	gs.StartGame()
-
 }
 
 func cloneTowerSpec(spec specs.TowerSpec) *specs.TowerSpec {
@@ -0,0 +1,84 @@
+// reload.go
+// Hot reload of the game specs: new sessions use the reloaded specs while
+// running sessions keep the snapshot they started with
+
+package server
+
+import (
+	"crypto/subtle"
+	"fmt"
+	"log/slog"
+	"os"
+	"tcr/specs"
+	"time"
+)
+
+// ReloadSpecs loads and validates the specs file again and, if it is valid,
+// uses it for every session created from now on. It returns the hash of the
+// specs in use afterwards.
+func (gm *GameManager) ReloadSpecs() (string, error) {
+	if gm.specsFile == "" {
+		return "", fmt.Errorf("no specs file to reload")
+	}
+	loaded, err := specs.LoadSpecs(gm.specsFile)
+	if err != nil {
+		return "", fmt.Errorf("reload %s: %w", gm.specsFile, err)
+	}
+	hash := specs.Hash(loaded)
+
+	gm.mutex.Lock()
+	defer gm.mutex.Unlock()
+	if hash == gm.specsHash {
+		return hash, nil
+	}
+	slog.Info("specs reloaded", "file", gm.specsFile, "from", gm.specsHash, "to", hash)
+	gm.specs = loaded
+	gm.specsHash = hash
+	return hash, nil
+}
+
+// isAdmin checks an admin PDU's token against the configured admin_token
+func (gm *GameManager) isAdmin(token string) bool {
+	want := gm.config.Security.AdminToken
+	return want != "" && subtle.ConstantTimeCompare([]byte(token), []byte(want)) == 1
+}
+
+// SpecsHash returns the hash of the specs new sessions are created with
+func (gm *GameManager) SpecsHash() string {
+	gm.mutex.RLock()
+	defer gm.mutex.RUnlock()
+	return gm.specsHash
+}
+
+// WatchSpecs reloads the specs whenever the file's modification time changes,
+// checking every specs_poll_sec. An invalid file is logged and ignored until
+// it changes again.
+func (gm *GameManager) WatchSpecs() {
+	interval := time.Duration(gm.config.Game.SpecsPollSec) * time.Second
+	if gm.specsFile == "" || interval == 0 {
+		return
+	}
+	modTime := func() time.Time {
+		fi, err := os.Stat(gm.specsFile)
+		if err != nil {
+			return time.Time{}
+		}
+		return fi.ModTime()
+	}
+
+	go func() {
+		last := modTime()
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for range ticker.C {
+			current := modTime()
+			if current.IsZero() || current.Equal(last) {
+				continue
+			}
+			last = current
+			if _, err := gm.ReloadSpecs(); err != nil {
+				slog.Warn("specs unchanged", "err", err)
+			}
+		}
+	}()
+}
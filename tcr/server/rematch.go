@@ -0,0 +1,110 @@
+// rematch.go
+// Rematches: game_end offers one, and when both players accept within
+// rematch_sec a fresh session starts on the same connections and sides
+// without going back to the matchmaking queue. Bots always accept.
+
+package server
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// rematchVote is a player's answer to the rematch offer
+type rematchVote struct {
+	player int
+	accept bool
+	reason string // why the rematch is off when accept is unset
+}
+
+// voteRematch passes an answer on without blocking; answers after the offer
+// is settled are dropped
+func (gs *GameSession) voteRematch(v rematchVote) {
+	if gs.votes == nil {
+		return
+	}
+	select {
+	case gs.votes <- v:
+	default:
+	}
+}
+
+// latest follows rematches to the session being played now
+func (gs *GameSession) latest() *GameSession {
+	for next := gs.next.Load(); next != nil; next = gs.next.Load() {
+		gs = next
+	}
+	return gs
+}
+
+// left reports whether a player's connection failed during the match
+func (gs *GameSession) left() bool {
+	p := gs.forfeiter()
+	return p != nil && p.forfeit == EndDisconnect
+}
+
+// awaitRematch waits until both players accept the offer made with
+// game_end, telling each when the other is waiting, and reports whether
+// they did. An offer that is declined or expires is cancelled.
+func (gs *GameSession) awaitRematch(timeout time.Duration) bool {
+	if !gs.rematch {
+		return false
+	}
+	var accepted [2]bool
+	for i, p := range gs.Players {
+		accepted[i] = p.Conn == nil
+	}
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	for !accepted[0] || !accepted[1] {
+		select {
+		case v := <-gs.votes:
+			if !v.accept {
+				gs.cancelRematch(v.reason)
+				return false
+			}
+			accepted[v.player] = true
+			if other := gs.Players[1-v.player]; !accepted[1-v.player] {
+				data, _ := json.Marshal(struct {
+					From string `json:"from"`
+				}{gs.Players[v.player].Username})
+				mutex.Lock()
+				SendPDU(other.Conn, PDU{Type: "rematch_offer", Data: data})
+				mutex.Unlock()
+			}
+		case <-timer.C:
+			gs.cancelRematch("timeout")
+			return false
+		}
+	}
+	gs.logger().Info("rematch accepted")
+	return true
+}
+
+// cancelRematch tells the players there will be no rematch
+func (gs *GameSession) cancelRematch(reason string) {
+	gs.logger().Info("rematch cancelled", "reason", reason)
+	data, _ := json.Marshal(struct {
+		Reason string `json:"reason"`
+	}{reason})
+	mutex.Lock()
+	defer mutex.Unlock()
+	for _, p := range gs.Players {
+		if p.Conn != nil {
+			SendPDU(p.Conn, PDU{Type: "rematch_cancel", Data: data})
+		}
+	}
+}
+
+// leave logs the players out and closes their connections once no rematch
+// follows
+func (gs *GameSession) leave() {
+	mutex.Lock()
+	defer mutex.Unlock()
+	for _, p := range gs.Players {
+		gs.logout(p)
+		if p.Conn != nil {
+			p.Conn.Close()
+		}
+	}
+}
@@ -0,0 +1,285 @@
+// replay.go
+// Match recording and deterministic playback
+
+package server
+
+import (
+	"bufio"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"tcr/specs"
+	"time"
+)
+
+const (
+	replayVersion   = 2 // version 1 recorded only the specs hash
+	replayExt       = ".replay"
+	checksumEvery   = 10 // ticks between recorded state checksums
+	maxReplayListed = 50
+)
+
+// ReplayPlayer is a participant as they entered the match
+type ReplayPlayer struct {
+	Username string `json:"username"`
+	Level    Level  `json:"level"`
+}
+
+// ReplayHeader holds everything besides the commands needed to re-run a match
+type ReplayHeader struct {
+	Version   int             `json:"version"`
+	MatchID   string          `json:"match_id"`
+	SpecsHash string          `json:"specs_hash"`
+	Specs     *specs.Specs    `json:"specs,omitempty"` // the match's own, which the server may have reloaded since
+	Seed      int64           `json:"seed"`
+	Mode      string          `json:"mode"`
+	TickMs    int64           `json:"tick_ms"`
+	StartedAt time.Time       `json:"started_at"`
+	Players   [2]ReplayPlayer `json:"players"`
+}
+
+// ReplayEvent is one line of the replay body: a deploy, a state checksum
+// taken after a tick, or the end marker
+type ReplayEvent struct {
+	Tick     int    `json:"t"`
+	Player   int    `json:"p,omitempty"`
+	Troop    string `json:"d,omitempty"`
+	Forfeit  string `json:"f,omitempty"` // the player surrendered or disconnected
+	Checksum uint32 `json:"c,omitempty"`
+	End      bool   `json:"end,omitempty"`
+}
+
+// Replay is a decoded replay file
+type Replay struct {
+	Header ReplayHeader
+	Events []ReplayEvent
+}
+
+// ReplayInfo describes a stored replay for replay_list
+type ReplayInfo struct {
+	ID       string    `json:"id"`
+	Size     int64     `json:"size"`
+	Modified time.Time `json:"modified"`
+}
+
+// ReplayRecorder streams a session's replay to disk as gzipped JSON lines
+type ReplayRecorder struct {
+	file *os.File
+	gz   *gzip.Writer
+	enc  *json.Encoder
+}
+
+// NewReplayRecorder creates <dir>/<matchID>.replay and writes the header
+func NewReplayRecorder(dir string, header ReplayHeader) (*ReplayRecorder, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("create replay dir: %w", err)
+	}
+	file, err := os.Create(filepath.Join(dir, header.MatchID+replayExt))
+	if err != nil {
+		return nil, fmt.Errorf("create replay: %w", err)
+	}
+	gz := gzip.NewWriter(file)
+	rec := &ReplayRecorder{file: file, gz: gz, enc: json.NewEncoder(gz)}
+	if err := rec.enc.Encode(header); err != nil {
+		rec.Close()
+		return nil, fmt.Errorf("write replay header: %w", err)
+	}
+	return rec, nil
+}
+
+// Record appends one event
+func (r *ReplayRecorder) Record(ev ReplayEvent) error {
+	return r.enc.Encode(ev)
+}
+
+// Close flushes and closes the replay file
+func (r *ReplayRecorder) Close() error {
+	gzErr := r.gz.Close()
+	if err := r.file.Close(); err != nil {
+		return err
+	}
+	return gzErr
+}
+
+// newReplayHeader describes a session that is about to start
+func newReplayHeader(gs *GameSession) ReplayHeader {
+	s := &specs.Specs{
+		Troops: gs.TroopSpecs,
+		Towers: gs.TowerSpecs,
+		Rules:  gs.Rules,
+	}
+	header := ReplayHeader{
+		Version:   replayVersion,
+		MatchID:   gs.ID,
+		SpecsHash: specs.Hash(s),
+		Specs:     s,
+		Seed:      gs.Seed,
+		Mode:      gs.Mode.Name(),
+		TickMs:    gs.TickInterval.Milliseconds(),
+		StartedAt: gs.Clock.Now(),
+	}
+	for i, p := range gs.Players {
+		header.Players[i] = ReplayPlayer{Username: p.Username, Level: p.Level}
+	}
+	return header
+}
+
+// checksum hashes the simulated state so playback can detect divergence
+func (gs *GameSession) checksum() uint32 {
+	h := fnv.New32a()
+	fmt.Fprint(h, gs.ticks)
+	for _, p := range gs.Players {
+		fmt.Fprint(h, "|", p.Mana, "|", p.Level.Exp)
+		for _, t := range p.Towers {
+			fmt.Fprint(h, ",", t.Health)
+		}
+		for _, troop := range p.ActiveTroops {
+			fmt.Fprint(h, ";", troop.Spec.Name, ":", troop.Health, ":", troop.nextAction)
+		}
+	}
+	return h.Sum32()
+}
+
+// ReadReplay decodes a replay stream
+func ReadReplay(r io.Reader) (*Replay, error) {
+	gz, err := gzip.NewReader(r)
+	if err != nil {
+		return nil, fmt.Errorf("open replay: %w", err)
+	}
+	defer gz.Close()
+
+	dec := json.NewDecoder(bufio.NewReader(gz))
+	var replay Replay
+	if err := dec.Decode(&replay.Header); err != nil {
+		return nil, fmt.Errorf("read replay header: %w", err)
+	}
+	if v := replay.Header.Version; v < 1 || v > replayVersion {
+		return nil, fmt.Errorf("unsupported replay version %d", replay.Header.Version)
+	}
+	for {
+		var ev ReplayEvent
+		if err := dec.Decode(&ev); err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, fmt.Errorf("read replay event: %w", err)
+		}
+		replay.Events = append(replay.Events, ev)
+	}
+	return &replay, nil
+}
+
+// LoadReplay reads a replay file
+func LoadReplay(path string) (*Replay, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	return ReadReplay(file)
+}
+
+// PlayReplay re-runs a recorded match headlessly with the given specs, or
+// with the recorded ones when s is nil, calling onTick with the state after
+// every tick. It fails on the first checksum that does not match the
+// recording.
+func PlayReplay(replay *Replay, s *specs.Specs, onTick func(GameState)) error {
+	h := replay.Header
+	if s == nil {
+		if s = h.Specs; s == nil {
+			return fmt.Errorf("replay %s predates recorded specs; pass the specs with hash %s", h.MatchID, h.SpecsHash)
+		}
+	}
+	if hash := specs.Hash(s); hash != h.SpecsHash {
+		return fmt.Errorf("specs hash %s does not match replay %s", hash, h.SpecsHash)
+	}
+	mode, err := NewGameMode(h.Mode)
+	if err != nil {
+		return err
+	}
+
+	var players [2]*Player
+	for i, rp := range h.Players {
+		players[i] = newPlayer(nil, rp.Username, rp.Level, s.Towers, s.Rules)
+	}
+	gs := NewGameSession(Options{Specs: s}, players, mode)
+	gs.ID = h.MatchID
+	gs.TickInterval = time.Duration(h.TickMs) * time.Millisecond
+	gs.SetSeed(h.Seed)
+
+	// advanceTo runs ticks until the session reaches tick
+	advanceTo := func(tick int) {
+		for gs.ticks < tick {
+			gs.Advance()
+			onTick(gs.snapshot())
+		}
+	}
+
+	for _, ev := range replay.Events {
+		advanceTo(ev.Tick)
+		switch {
+		case ev.End:
+			return nil
+		case ev.Troop != "" || ev.Forfeit != "":
+			gs.Deploy(DeployCmd{PlayerIndex: ev.Player, TroopName: ev.Troop, Forfeit: ev.Forfeit})
+		default:
+			if sum := gs.checksum(); sum != ev.Checksum {
+				return fmt.Errorf("replay diverged at tick %d: checksum %08x, recorded %08x",
+					ev.Tick, sum, ev.Checksum)
+			}
+		}
+	}
+	return nil
+}
+
+// listReplays returns the newest replays in dir
+func listReplays(dir string) ([]ReplayInfo, error) {
+	if dir == "" {
+		return nil, nil // recording disabled
+	}
+	entries, err := os.ReadDir(dir)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var infos []ReplayInfo
+	for _, e := range entries {
+		if e.IsDir() || !strings.HasSuffix(e.Name(), replayExt) {
+			continue
+		}
+		fi, err := e.Info()
+		if err != nil {
+			continue
+		}
+		infos = append(infos, ReplayInfo{
+			ID:       strings.TrimSuffix(e.Name(), replayExt),
+			Size:     fi.Size(),
+			Modified: fi.ModTime(),
+		})
+	}
+	sort.Slice(infos, func(i, j int) bool { return infos[i].Modified.After(infos[j].Modified) })
+	if len(infos) > maxReplayListed {
+		infos = infos[:maxReplayListed]
+	}
+	return infos, nil
+}
+
+// readReplayFile returns the raw bytes of a stored replay, refusing IDs that
+// would escape dir
+func readReplayFile(dir, id string) ([]byte, error) {
+	if dir == "" {
+		return nil, fmt.Errorf("replays are disabled")
+	}
+	if id == "" || filepath.Base(id) != id {
+		return nil, fmt.Errorf("invalid replay id %q", id)
+	}
+	return os.ReadFile(filepath.Join(dir, id+replayExt))
+}
@@ -2,101 +2,344 @@
 package server
 
 import (
-	// "tcr/common"
-
+	"fmt"
+	"log/slog"
+	"net"
+	"sort"
+	"sync"
 	"tcr/config"
 	"tcr/specs"
+	"time"
 )
 
-// GameManager handles all active game sessions
+// GameManager handles all active game sessions: it pairs queued clients,
+// creates and indexes sessions, and removes them once they end
 type GameManager struct {
+	users      map[string]User
 	sessions   map[string]*GameSession
 	matchQueue chan *ClientHandler
-	// mutex      sync.RWMutex
-	specs  *specs.Specs
-	config *config.Config
+	mutex      sync.RWMutex
+	specs      *specs.Specs // replaced as a whole on reload, never modified
+	specsHash  string
+	specsFile  string
+	config     *config.Config
+	userFile   string
+	replayDir  string
+	auditFile  string
+	auditMutex sync.Mutex
+	online     map[string]net.Conn // logged-in users by name
+	lastID     int64
+	udp        *UDPChannel // nil without a UDP port
+}
+
+// Options configures a server instance. Nothing in it depends on the working
+// directory, so several instances (or tests) can run side by side.
+type Options struct {
+	Users     map[string]User // account store, shared with every session
+	UserFile  string          // where accounts are saved, "" to keep them in memory
+	Specs     *specs.Specs    // troops, towers and rules
+	SpecsFile string          // where Specs was loaded from, "" disables reloading
+	Config    *config.Config
+	ReplayDir string      // where matches are recorded, "" disables recording
+	AuditFile string      // where admin actions are appended, "" only logs them
+	UDP       *UDPChannel // state snapshots over UDP, nil keeps everything on TCP
+}
+
+// MatchInfo summarizes a live session for list_matches
+type MatchInfo struct {
+	MatchID    string    `json:"match_id"`
+	Mode       string    `json:"mode"`
+	Players    [2]string `json:"players"`
+	TimeLeft   int       `json:"time_left"`
+	Spectators int       `json:"spectators"`
 }
 
 // NewGameManager creates a new game manager
-func NewGameManager(specs *specs.Specs, config *config.Config) *GameManager {
+func NewGameManager(opts Options) *GameManager {
+	users := opts.Users
+	if users == nil {
+		users = make(map[string]User)
+	}
 	return &GameManager{
+		users:      users,
 		sessions:   make(map[string]*GameSession),
-		matchQueue: make(chan *ClientHandler, config.Game.MaxPlayers),
-		specs:      specs,
-		config:     config,
+		matchQueue: make(chan *ClientHandler, opts.Config.Game.MaxPlayers),
+		specs:      opts.Specs,
+		specsHash:  specs.Hash(opts.Specs),
+		specsFile:  opts.SpecsFile,
+		config:     opts.Config,
+		userFile:   opts.UserFile,
+		replayDir:  opts.ReplayDir,
+		auditFile:  opts.AuditFile,
+		online:     make(map[string]net.Conn),
+		udp:        opts.UDP,
+	}
+}
+
+// sessionOptions returns the options a new session is created with. The
+// session keeps the specs it started with even if they are reloaded.
+func (gm *GameManager) sessionOptions() Options {
+	gm.mutex.RLock()
+	defer gm.mutex.RUnlock()
+	return Options{
+		Users:     gm.users,
+		UserFile:  gm.userFile,
+		Specs:     gm.specs,
+		SpecsFile: gm.specsFile,
+		Config:    gm.config,
+		ReplayDir: gm.replayDir,
+		AuditFile: gm.auditFile,
+		UDP:       gm.udp,
+	}
+}
+
+// StartMatchmaking starts the matchmaking process. A client left waiting
+// longer than bot_backfill_sec is matched against a bot instead.
+func (gm *GameManager) StartMatchmaking() {
+	go func() {
+		// One waiting client per mode; the next client in the same mode
+		// completes the pair
+		waiting := make(map[string]*ClientHandler)
+		backfill := time.Duration(gm.config.Game.BotBackfillSec) * time.Second
+		ticker := time.NewTicker(time.Second)
+		defer ticker.Stop()
+		metrics.matchmakerBeat(0)
+		for {
+			select {
+			case c := <-gm.matchQueue:
+				clog := slog.With("conn", c.HandlerID, "user", c.User.Username, "mode", c.Mode)
+				clog.Debug("client queued")
+
+				c1, ok := waiting[c.Mode]
+				if !ok {
+					clog.Debug("waiting for opponent")
+					waiting[c.Mode] = c
+					continue
+				}
+				delete(waiting, c.Mode)
+				metrics.queued.Add(-2)
+
+				clog.Debug("pairing", "opponent", c1.User.Username)
+				go gm.StartGameSession(c1, c)
+
+			case <-ticker.C:
+				var oldest time.Duration
+				for mode, c := range waiting {
+					wait := time.Since(c.queuedAt)
+					if backfill > 0 && wait >= backfill {
+						delete(waiting, mode)
+						metrics.queued.Add(-1)
+						slog.Info("backfilling with a bot", "conn", c.HandlerID, "user", c.User.Username,
+							"mode", mode, "waited", backfill)
+						go gm.StartBotSession(c, DefaultBotDifficulty)
+						continue
+					}
+					oldest = max(oldest, wait)
+				}
+				// /readyz fails if this stops, e.g. when the loop is wedged
+				metrics.matchmakerBeat(oldest)
+			}
+		}
+	}()
+}
+
+// Enqueue adds a logged-in client to matchmaking
+func (gm *GameManager) Enqueue(c *ClientHandler) {
+	c.queuedAt = time.Now()
+	metrics.queued.Add(1)
+	gm.matchQueue <- c
+}
+
+// StartGameSession creates a session for two matched clients and runs it,
+// and any rematches they agree to, until it ends
+func (gm *GameManager) StartGameSession(c1, c2 *ClientHandler) {
+	gm.runMatches(c1, c2, "")
+}
+
+// StartBotSession runs a match between a client and a bot of the given
+// difficulty, scaled to the client's level
+func (gm *GameManager) StartBotSession(c *ClientHandler, difficulty string) {
+	if difficulty == "" {
+		difficulty = DefaultBotDifficulty
+	}
+	botUser := &User{
+		Username:   "bot_" + difficulty,
+		Level:      c.User.Level,
+		NextLevel:  c.User.NextLevel,
+		Multiplier: c.User.Multiplier,
+	}
+	gm.runMatches(c, &ClientHandler{User: botUser, Mode: c.Mode}, difficulty)
+}
+
+// runMatches plays c1 against c2, a bot of difficulty if it is set, and
+// starts a fresh session each time both accept a rematch
+func (gm *GameManager) runMatches(c1, c2 *ClientHandler, difficulty string) {
+	var prev *GameSession
+	for {
+		var bot *Bot
+		if difficulty != "" {
+			troops := gm.sessionOptions().Specs.Troops
+			names := make([]string, 0, len(troops))
+			for name := range troops {
+				names = append(names, name)
+			}
+			var err error
+			if bot, err = NewBot(difficulty, names, time.Now().UnixNano()); err != nil {
+				slog.Error("start bot session failed", "user", c1.User.Username, "err", err)
+				return
+			}
+		}
+
+		gs, err := gm.createSession(c1, c2, prev)
+		if err != nil {
+			slog.Error("start session failed", "err", err)
+			return
+		}
+		gs.Players[1].Bot = bot
+		if prev == nil {
+			gs.StartGame()
+		} else {
+			gs.enhancedLoop() // the first session's readers carry on
+		}
+
+		// The players stay online while the rematch offer is open
+		if !gs.awaitRematch(time.Duration(gm.config.Game.RematchSec) * time.Second) {
+			gs.leave()
+			gm.setOffline(gs)
+			return
+		}
+		gm.rejoin(c1)
+		gm.rejoin(c2)
+		prev = gs
+	}
+}
+
+// rejoin refreshes a client's account after a match, for the levels it
+// earned, and marks it online again
+func (gm *GameManager) rejoin(c *ClientHandler) {
+	if c.Conn == nil {
+		return // bot
+	}
+	mutex.Lock()
+	stored := gm.users[c.User.Username]
+	mutex.Unlock()
+	c.User = &stored
+	gm.setOnline(stored.Username, c.Conn)
+}
+
+// CreateSession announces the match to both clients and registers a new
+// session with a fresh ID; the caller starts it
+func (gm *GameManager) CreateSession(c1, c2 *ClientHandler) (*GameSession, error) {
+	return gm.createSession(c1, c2, nil)
+}
+
+// createSession is CreateSession for a rematch of prev, if set. Commands
+// that follow game_start must reach the new session, so prev leads to it
+// before game_start is sent.
+func (gm *GameManager) createSession(c1, c2 *ClientHandler, prev *GameSession) (*GameSession, error) {
+	mode, err := NewGameMode(c1.Mode)
+	if err != nil {
+		return nil, err
+	}
+
+	opts := gm.sessionOptions()
+	s := opts.Specs
+	players := [2]*Player{
+		newPlayer(c1.Conn, c1.User.Username, c1.User.level(), s.Towers, s.Rules),
+		newPlayer(c2.Conn, c2.User.Username, c2.User.level(), s.Towers, s.Rules),
+	}
+	players[0].udp, players[1].udp = c1.udp, c2.udp
+	gs := NewGameSession(opts, players, mode)
+	gs.ID = gm.newSessionID()
+	if gm.config.Game.RematchSec > 0 {
+		gs.votes = make(chan rematchVote, 4)
+	}
+	if prev != nil {
+		prev.next.Store(gs)
+	}
+
+	// Send game_start PDU, telling each client which player it is. The
+	// previous session's reader may be relaying chat on these connections.
+	mutex.Lock()
+	for i, c := range []*ClientHandler{c1, c2} {
+		if c.Conn == nil {
+			continue // bot
+		}
+		startData := fmt.Sprintf(`{"players":[%d,%d],"mode":"%s","you":%d}`,
+			c1.HandlerID, c2.HandlerID, mode.Name(), i)
+		if err := SendPDU(c.Conn, PDU{
+			Type: "game_start",
+			Data: []byte(startData)}); err != nil {
+			slog.Warn("send failed", "conn", c.HandlerID, "user", c.User.Username, "pdu", "game_start", "err", err)
+		}
+	}
+	mutex.Unlock()
+	gs.logger().Info("session started", "mode", mode.Name(), "seed", gs.Seed, "specs", specs.Hash(s),
+		"players", []string{c1.User.Username, c2.User.Username}, "rematch", prev != nil)
+
+	if gm.replayDir != "" {
+		rec, err := NewReplayRecorder(gm.replayDir, newReplayHeader(gs))
+		if err != nil {
+			gs.logger().Warn("replay disabled", "err", err)
+		} else {
+			gs.Recorder = rec
+		}
+	}
+	gm.addSession(gs)
+	return gs, nil
+}
+
+// newSessionID returns a unique, time-ordered match ID
+func (gm *GameManager) newSessionID() string {
+	gm.mutex.Lock()
+	defer gm.mutex.Unlock()
+	id := max(time.Now().UnixNano(), gm.lastID+1)
+	gm.lastID = id
+	return fmt.Sprintf("game_%d", id)
+}
+
+// addSession indexes a live session until it ends
+func (gm *GameManager) addSession(gs *GameSession) {
+	gm.mutex.Lock()
+	gm.sessions[gs.ID] = gs
+	gm.mutex.Unlock()
+
+	go func() {
+		<-gs.Done
+		gm.mutex.Lock()
+		delete(gm.sessions, gs.ID)
+		gm.mutex.Unlock()
+		gs.logger().Info("session ended")
+	}()
+}
+
+// Session looks up a live session by match ID
+func (gm *GameManager) Session(id string) (*GameSession, bool) {
+	gm.mutex.RLock()
+	defer gm.mutex.RUnlock()
+	gs, ok := gm.sessions[id]
+	return gs, ok
+}
+
+// Matches lists the live sessions, oldest first
+func (gm *GameManager) Matches() []MatchInfo {
+	gm.mutex.RLock()
+	defer gm.mutex.RUnlock()
+
+	infos := make([]MatchInfo, 0, len(gm.sessions))
+	for _, gs := range gm.sessions {
+		infos = append(infos, gs.info())
 	}
+	sort.Slice(infos, func(i, j int) bool { return infos[i].MatchID < infos[j].MatchID })
+	return infos
 }
 
-// convertSpecs converts specs package types to game types
-// func convertSpecs(s *specs.Specs) (map[string]specs.TroopSpec, map[string]specs.TowerSpec) {
-// 	troopSpecs := make(map[string]specs.TroopSpec)
-// 	towerSpecs := make(map[string]specs.TowerSpec)
-
-// 	for k, v := range s.Troops {
-// 		troopSpecs[k] = specs.TroopSpec{
-// 			Name:        v.Name,
-// 			Type:        v.Type,
-// 			Health:      v.Health,
-// 			Damage:      v.Damage,
-// 			Defence:     v.Defence,
-// 			Range:       v.Range,
-// 			Speed:       v.Speed,
-// 			AttackSpeed: v.AttackSpeed,
-// 			Cost:        v.Cost,
-// 			Target:      v.Target,
-// 		}
-// 	}
-
-// 	for k, v := range s.Towers {
-// 		towerSpecs[k] = specs.TowerSpec{
-// 			Name:        v.Name,
-// 			Type:        v.Type,
-// 			Health:      v.Health,
-// 			Damage:      v.Damage,
-// 			Defence:     v.Defence,
-// 			Range:       v.Range,
-// 			AttackSpeed: v.AttackSpeed,
-// 			Target:      v.Target,
-// 		}
-// 	}
-
-// 	return troopSpecs, towerSpecs
-// }
-
-// StartMatchmaking starts the matchmaking process
-// func (gm *GameManager) StartMatchmaking() {
-// 	go func() {
-// 		for {
-// 			// Wait for two players
-// 			player1 := <-gm.matchQueue
-// 			player2 := <-gm.matchQueue
-
-// 			// Create new game session
-// 			sessionID := fmt.Sprintf("game_%d", time.Now().UnixNano())
-// 			players := [2]*Player{
-// 				{Conn: player1.Conn, Username: player1.User.Username},
-// 				{Conn: player2.Conn, Username: player2.User.Username},
-// 			}
-
-// 			// Convert specs to game types
-// 			troopSpecs, towerSpecs := convertSpecs(gm.specs)
-
-// 			// Initialize game session
-// 			session := NewGameSession(players, troopSpecs, towerSpecs)
-
-// 			// Store session
-// 			gm.mutex.Lock()
-// 			gm.sessions[sessionID] = session
-// 			gm.mutex.Unlock()
-
-// 			// Start game in goroutine
-// 			go func() {
-// 				session.StartGame()
-// 				// Clean up when game ends
-// 				gm.mutex.Lock()
-// 				delete(gm.sessions, sessionID)
-// 				gm.mutex.Unlock()
-// 			}()
-// 		}
-// 	}()
-// }
+// Terminate force-ends a live session without a result
+func (gm *GameManager) Terminate(id, reason string) error {
+	gs, ok := gm.Session(id)
+	if !ok {
+		return fmt.Errorf("no live session %s", id)
+	}
+	gs.Stop(reason)
+	return nil
+}
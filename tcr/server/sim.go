@@ -0,0 +1,89 @@
+// sim.go
+// Headless bot-vs-bot matches on the real engine, for balance simulation
+
+package server
+
+import (
+	"fmt"
+	"sync"
+	"tcr/specs"
+)
+
+// SimSide configures one side of a simulated match
+type SimSide struct {
+	Difficulty string   // bot strategy, see BotDifficulties
+	Deck       []string // troop keys the bot may deploy; empty deals a random hand
+	Level      Level
+}
+
+// SimResult is the outcome of a simulated match
+type SimResult struct {
+	Winner       int // player index, -1 for a draw
+	Ticks        int
+	Overtime     bool
+	TowersLost   [2]int
+	KingLost     [2]bool
+	Deploys      [2]map[string]int // troop key -> times deployed
+	TickInterval float64           // seconds per tick, for converting Ticks
+}
+
+// maxSimTicks stops a simulated match that no mode rule ends
+const maxSimTicks = 100000
+
+// Simulate plays one bot-vs-bot match as fast as possible, with the same
+// ticks and deploy handling as a live match but no connections or accounts.
+// The seed fixes both the match and the bots' decisions.
+func Simulate(s *specs.Specs, modeName string, sides [2]SimSide, seed int64) (SimResult, error) {
+	mode, err := NewGameMode(modeName)
+	if err != nil {
+		return SimResult{}, err
+	}
+
+	var players [2]*Player
+	names := make([]string, 0, len(s.Troops))
+	for name := range s.Troops {
+		names = append(names, name)
+	}
+	for i, side := range sides {
+		bot, err := NewBot(side.Difficulty, names, seed+int64(i)+1)
+		if err != nil {
+			return SimResult{}, err
+		}
+		if len(side.Deck) > 0 {
+			for _, name := range side.Deck {
+				if _, ok := s.Troops[name]; !ok {
+					return SimResult{}, fmt.Errorf("unknown troop in deck: %s", name)
+				}
+			}
+			bot.Hand = side.Deck
+		}
+		players[i] = newPlayer(nil, fmt.Sprintf("sim_%d", i+1), side.Level, s.Towers, s.Rules)
+		players[i].Bot = bot
+	}
+
+	gs := NewGameSession(Options{Specs: s}, players, mode)
+	gs.ID = fmt.Sprintf("sim_%d", seed)
+	gs.SetSeed(seed)
+	// Nothing else touches the session, so simulations running in parallel
+	// need not queue for the global mutex
+	gs.lock = new(sync.Mutex)
+
+	result := SimResult{
+		Deploys:      [2]map[string]int{{}, {}},
+		TickInterval: gs.TickInterval.Seconds(),
+	}
+	gs.botDeployed = func(player int, troop string) { result.Deploys[player][troop]++ }
+	// Same order as the live loop: bots move after each tick
+	for over := false; !over && gs.ticks < maxSimTicks; {
+		over = gs.Advance() || gs.RunBots()
+	}
+
+	result.Ticks = gs.ticks
+	result.Overtime = gs.overtime
+	for i, p := range gs.Players {
+		result.TowersLost[i] = len(p.Towers) - p.TowersAlive()
+		result.KingLost[i] = p.KingTowerDestroyed()
+	}
+	result.Winner = gs.winner()
+	return result, nil
+}
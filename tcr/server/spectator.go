@@ -0,0 +1,200 @@
+// spectator.go
+// Read-only, optionally delayed state streams for live matches
+
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"net"
+	"tcr/specs"
+	"time"
+)
+
+const (
+	maxSpectateDelay  = 60 * time.Second
+	spectatorSlack    = 64 // frames buffered per spectator beyond its delay before dropping
+	spectateEndReason = `{"reason":"match ended"}`
+)
+
+var (
+	errSpectatorsFull = errors.New("spectator limit reached")
+	errMatchOver      = errors.New("match is over")
+)
+
+// PlayerView is one side of a match as seen by a spectator
+type PlayerView struct {
+	Username string            `json:"username"`
+	Mana     int               `json:"mana"`
+	Towers   []specs.TowerSpec `json:"towers"`
+	Troops   []string          `json:"troops"`
+}
+
+// MatchView is the neutral-perspective state streamed to spectators
+type MatchView struct {
+	MatchID  string        `json:"match_id"`
+	Mode     string        `json:"mode"`
+	Phase    string        `json:"phase"`
+	TimeLeft int           `json:"time_left"`
+	Players  [2]PlayerView `json:"players"`
+	Events   []CombatEvent `json:"events,omitempty"` // since the previous view
+}
+
+// Spectator is a connection subscribed to a session's state stream
+type Spectator struct {
+	Conn   net.Conn
+	Delay  time.Duration // hides live information from players being watched
+	frames chan spectatorFrame
+}
+
+type spectatorFrame struct {
+	at   time.Time
+	data []byte
+}
+
+// NewSpectator creates a spectator for a match publishing a frame every
+// tick; delays are capped at maxSpectateDelay
+func NewSpectator(conn net.Conn, delay, tick time.Duration) *Spectator {
+	delay = min(max(delay, 0), maxSpectateDelay)
+	// Every frame of the delay is held back at once, so the buffer has to fit
+	// them all
+	backlog := spectatorSlack
+	if tick > 0 {
+		backlog += int(delay / tick)
+	}
+	return &Spectator{
+		Conn:   conn,
+		Delay:  delay,
+		frames: make(chan spectatorFrame, backlog),
+	}
+}
+
+// Watch streams views of gs, which the spectator was added to, until the
+// match ends or the connection fails
+func (sp *Spectator) Watch(gs *GameSession) error {
+	defer gs.removeSpectator(sp)
+
+	for {
+		select {
+		case frame := <-sp.frames:
+			if wait := time.Until(frame.at.Add(sp.Delay)); wait > 0 {
+				select {
+				case <-time.After(wait):
+				case <-gs.Done:
+					return sp.end()
+				}
+			}
+			if err := SendPDU(sp.Conn, PDU{Type: "spectate_update", Data: frame.data}); err != nil {
+				return err
+			}
+		case <-gs.Done:
+			return sp.end()
+		}
+	}
+}
+
+// end tells the spectator the match is over
+func (sp *Spectator) end() error {
+	return SendPDU(sp.Conn, PDU{Type: "spectate_end", Data: []byte(spectateEndReason)})
+}
+
+// addSpectator subscribes sp unless the match is over or full
+func (gs *GameSession) addSpectator(sp *Spectator, limit int) error {
+	gs.specMutex.Lock()
+	defer gs.specMutex.Unlock()
+
+	select {
+	case <-gs.Done:
+		return errMatchOver
+	default:
+	}
+	if len(gs.spectators) >= limit {
+		return errSpectatorsFull
+	}
+	gs.spectators = append(gs.spectators, sp)
+	return nil
+}
+
+// removeSpectator unsubscribes sp
+func (gs *GameSession) removeSpectator(sp *Spectator) {
+	gs.specMutex.Lock()
+	defer gs.specMutex.Unlock()
+
+	for i, s := range gs.spectators {
+		if s == sp {
+			gs.spectators = append(gs.spectators[:i], gs.spectators[i+1:]...)
+			return
+		}
+	}
+}
+
+// spectatorCount returns the number of subscribed spectators
+func (gs *GameSession) spectatorCount() int {
+	gs.specMutex.Lock()
+	defer gs.specMutex.Unlock()
+	return len(gs.spectators)
+}
+
+// publishView queues the current view and the tick's events for every
+// spectator. A spectator that
+// falls a full backlog behind misses frames rather than stalling the match.
+func (gs *GameSession) publishView(events []CombatEvent) {
+	gs.specMutex.Lock()
+	defer gs.specMutex.Unlock()
+	if len(gs.spectators) == 0 {
+		return
+	}
+
+	view := gs.view()
+	view.Events = events
+	data, err := json.Marshal(view)
+	if err != nil {
+		return
+	}
+	frame := spectatorFrame{at: time.Now(), data: data}
+	for _, sp := range gs.spectators {
+		select {
+		case sp.frames <- frame:
+		default:
+		}
+	}
+}
+
+// view builds the neutral-perspective state for spectators
+func (gs *GameSession) view() MatchView {
+	state := gs.snapshot()
+	view := MatchView{
+		MatchID:  gs.ID,
+		Mode:     state.Mode,
+		Phase:    state.Phase,
+		TimeLeft: state.TimeLeft,
+	}
+	towers := [2][]specs.TowerSpec{state.Player1Towers, state.Player2Towers}
+	for i, p := range gs.Players {
+		view.Players[i] = PlayerView{
+			Username: p.Username,
+			Mana:     p.Mana,
+			Towers:   towers[i],
+			Troops:   make([]string, 0, len(p.ActiveTroops)),
+		}
+		for _, troop := range p.ActiveTroops {
+			view.Players[i].Troops = append(view.Players[i].Troops, troop.Spec.Name)
+		}
+	}
+	return view
+}
+
+// info summarizes the session for list_matches
+func (gs *GameSession) info() MatchInfo {
+	gs.lock.Lock()
+	timeLeft := int(gs.timeLeft().Seconds())
+	gs.lock.Unlock()
+
+	return MatchInfo{
+		MatchID:    gs.ID,
+		Mode:       gs.Mode.Name(),
+		Players:    [2]string{gs.Players[0].Username, gs.Players[1].Username},
+		TimeLeft:   timeLeft,
+		Spectators: gs.spectatorCount(),
+	}
+}
@@ -0,0 +1,176 @@
+// udp.go
+// Optional UDP channel for state snapshots. A client that asks for it at
+// login gets a token; it sends that token in keepalive datagrams, and while
+// they keep arriving each tick's full state goes to the datagram's source
+// address instead of over TCP. Snapshots carry the state sequence number, are
+// never retransmitted, and the client keeps only the newest. Everything else,
+// commands included, stays on TCP.
+
+package server
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net"
+	"sync"
+	"time"
+)
+
+const (
+	// UDPTimeout is how long either side goes without datagrams from the
+	// other before state goes back to TCP
+	UDPTimeout = 3 * time.Second
+	// UDPKeepalive is how often clients send their token
+	UDPKeepalive = time.Second
+	// udpExpireAfter forgets tokens that have not been heard from in a while
+	udpExpireAfter = time.Minute
+	// udpMaxDatagram bounds keepalives; snapshots are well under an MTU
+	udpMaxDatagram = 512
+)
+
+// UDPKeepaliveMsg is the datagram a client sends to bind its address to the
+// token from login_resp
+type UDPKeepaliveMsg struct {
+	Token string `json:"token"`
+}
+
+// UDPChannel is the server's UDP socket and the tokens issued for it
+type UDPChannel struct {
+	conn  *net.UDPConn
+	mutex sync.Mutex // guards peers and every peer's fields
+	peers map[string]*udpPeer
+}
+
+// udpPeer is one logged-in client's UDP binding
+type udpPeer struct {
+	channel *UDPChannel
+	token   string
+	addr    *net.UDPAddr // where keepalives come from, nil until the first
+	seen    time.Time    // last keepalive, or when the token was issued
+	dropped bool         // the client fell back to TCP
+}
+
+// ListenUDP opens the UDP channel on addr
+func ListenUDP(addr string) (*UDPChannel, error) {
+	udpAddr, err := net.ResolveUDPAddr("udp", addr)
+	if err != nil {
+		return nil, err
+	}
+	conn, err := net.ListenUDP("udp", udpAddr)
+	if err != nil {
+		return nil, err
+	}
+	slog.Info("udp listening", "addr", conn.LocalAddr().String())
+	return &UDPChannel{conn: conn, peers: make(map[string]*udpPeer)}, nil
+}
+
+// Port is the local port clients send keepalives to
+func (u *UDPChannel) Port() int {
+	return u.conn.LocalAddr().(*net.UDPAddr).Port
+}
+
+// Close stops Serve
+func (u *UDPChannel) Close() error {
+	return u.conn.Close()
+}
+
+// Serve reads keepalives until the channel is closed
+func (u *UDPChannel) Serve() error {
+	buf := make([]byte, udpMaxDatagram)
+	lastSweep := time.Now()
+	for {
+		n, addr, err := u.conn.ReadFromUDP(buf)
+		if errors.Is(err, net.ErrClosed) {
+			return nil
+		}
+		if err != nil {
+			slog.Warn("udp read failed", "err", err)
+			continue
+		}
+		var msg UDPKeepaliveMsg
+		if json.Unmarshal(buf[:n], &msg) == nil {
+			u.keepalive(msg.Token, addr)
+		}
+		if time.Since(lastSweep) > udpExpireAfter {
+			u.sweep()
+			lastSweep = time.Now()
+		}
+	}
+}
+
+// issue creates a token for a client that just logged in
+func (u *UDPChannel) issue() (*udpPeer, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return nil, err
+	}
+	peer := &udpPeer{channel: u, token: hex.EncodeToString(b), seen: time.Now()}
+	u.mutex.Lock()
+	u.peers[peer.token] = peer
+	u.mutex.Unlock()
+	return peer, nil
+}
+
+// keepalive binds a token to the address it arrived from; a client that
+// changes address moves with it
+func (u *UDPChannel) keepalive(token string, addr *net.UDPAddr) {
+	u.mutex.Lock()
+	defer u.mutex.Unlock()
+	peer, ok := u.peers[token]
+	if !ok {
+		return
+	}
+	if peer.addr == nil || peer.addr.String() != addr.String() {
+		slog.Debug("udp peer bound", "addr", addr.String())
+	}
+	peer.addr, peer.seen = addr, time.Now()
+}
+
+// sweep forgets peers that have gone quiet
+func (u *UDPChannel) sweep() {
+	u.mutex.Lock()
+	defer u.mutex.Unlock()
+	for token, peer := range u.peers {
+		if time.Since(peer.seen) > udpExpireAfter {
+			delete(u.peers, token)
+		}
+	}
+}
+
+// live reports whether snapshots should go over UDP: the client has bound an
+// address and sent a keepalive within UDPTimeout
+func (p *udpPeer) live() bool {
+	if p == nil {
+		return false
+	}
+	p.channel.mutex.Lock()
+	defer p.channel.mutex.Unlock()
+	return !p.dropped && p.addr != nil && time.Since(p.seen) < UDPTimeout
+}
+
+// drop stops UDP for this client for good; its token no longer binds
+func (p *udpPeer) drop() {
+	if p == nil {
+		return
+	}
+	p.channel.mutex.Lock()
+	defer p.channel.mutex.Unlock()
+	p.dropped = true
+	delete(p.channel.peers, p.token)
+}
+
+// send writes one PDU as a JSON datagram to the bound address
+func (p *udpPeer) send(pdu PDU) error {
+	data, err := json.Marshal(pdu)
+	if err == nil {
+		p.channel.mutex.Lock()
+		addr := p.addr
+		p.channel.mutex.Unlock()
+		_, err = p.channel.conn.WriteToUDP(data, addr)
+	}
+	metrics.countOut(pdu.Type, err)
+	return err
+}
@@ -0,0 +1,316 @@
+// websocket.go
+// WebSocket listener for browser clients. Each WebSocket message carries one
+// PDU body (JSON in text frames, other codecs in binary frames); wsConn turns
+// messages into the length-prefixed stream SendPDU and ReceivePDU expect, so
+// these connections go through HandleConnection like TCP ones.
+
+package server
+
+import (
+	"bufio"
+	"crypto/rand"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+// wsGUID is the fixed key suffix from RFC 6455
+const wsGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC11B55"
+
+// wsMaxMessage caps the size of one incoming message
+const wsMaxMessage = 1 << 20
+
+// WebSocket opcodes
+const (
+	wsContinuation = 0x0
+	wsText         = 0x1
+	wsBinary       = 0x2
+	wsClose        = 0x8
+	wsPing         = 0x9
+	wsPong         = 0xa
+)
+
+var errWSTooLarge = errors.New("websocket: message too large")
+
+// StartWebSocket serves WebSocket clients on addr at /ws. It does not start
+// matchmaking; run it next to StartServer.
+func StartWebSocket(addr string, gm *GameManager) error {
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	slog.Info("websocket listening", "addr", addr)
+	return ServeWebSocket(ln, gm)
+}
+
+// ServeWebSocket accepts WebSocket clients on ln until it is closed
+func ServeWebSocket(ln net.Listener, gm *GameManager) error {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/ws", func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgradeWebSocket(w, r)
+		if err != nil {
+			slog.Debug("websocket upgrade failed", "remote", r.RemoteAddr, "err", err)
+			return
+		}
+		// The HTTP server is done with the request once the handler returns
+		go HandleConnection(trackConn(conn), gm, nextConnID())
+	})
+	srv := &http.Server{Handler: mux, ReadHeaderTimeout: 5 * time.Second}
+	if err := srv.Serve(ln); !errors.Is(err, http.ErrServerClosed) && !errors.Is(err, net.ErrClosed) {
+		return err
+	}
+	return nil
+}
+
+// upgradeWebSocket completes the opening handshake and takes over the
+// connection
+func upgradeWebSocket(w http.ResponseWriter, r *http.Request) (net.Conn, error) {
+	if r.Method != http.MethodGet ||
+		!headerHas(r.Header, "Connection", "upgrade") ||
+		!headerHas(r.Header, "Upgrade", "websocket") {
+		http.Error(w, "websocket upgrade required", http.StatusUpgradeRequired)
+		return nil, errors.New("not a websocket upgrade")
+	}
+	if r.Header.Get("Sec-WebSocket-Version") != "13" {
+		w.Header().Set("Sec-WebSocket-Version", "13")
+		http.Error(w, "unsupported websocket version", http.StatusUpgradeRequired)
+		return nil, errors.New("unsupported websocket version")
+	}
+	key := r.Header.Get("Sec-WebSocket-Key")
+	if key == "" {
+		http.Error(w, "missing Sec-WebSocket-Key", http.StatusBadRequest)
+		return nil, errors.New("missing key")
+	}
+	hj, ok := w.(http.Hijacker)
+	if !ok {
+		http.Error(w, "websocket not supported", http.StatusInternalServerError)
+		return nil, errors.New("connection cannot be hijacked")
+	}
+	conn, rw, err := hj.Hijack()
+	if err != nil {
+		return nil, err
+	}
+	// Drop the handshake deadline the HTTP server may have set
+	conn.SetDeadline(time.Time{})
+	fmt.Fprintf(rw, "HTTP/1.1 101 Switching Protocols\r\n"+
+		"Upgrade: websocket\r\nConnection: Upgrade\r\nSec-WebSocket-Accept: %s\r\n\r\n", wsAccept(key))
+	if err := rw.Flush(); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return newWSConn(conn, rw.Reader, false), nil
+}
+
+// headerHas reports whether a comma-separated header lists token
+func headerHas(h http.Header, name, token string) bool {
+	for _, value := range h.Values(name) {
+		for _, part := range strings.Split(value, ",") {
+			if strings.EqualFold(strings.TrimSpace(part), token) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// wsAccept derives Sec-WebSocket-Accept from the client's key
+func wsAccept(key string) string {
+	sum := sha1.Sum([]byte(key + wsGUID))
+	return base64.StdEncoding.EncodeToString(sum[:])
+}
+
+// wsConn adapts a WebSocket to the length-prefixed PDU stream. Reads return
+// each incoming message behind its 4-byte length; writes collect one framed
+// PDU and send its body as a single message.
+type wsConn struct {
+	net.Conn
+	r      *bufio.Reader
+	client bool // mask outgoing frames, as clients must
+
+	readBuf []byte // rest of the current message, length prefix included
+
+	writeMutex sync.Mutex // guards writeBuf and frame writes
+	writeBuf   []byte
+	closeOnce  sync.Once
+}
+
+func newWSConn(conn net.Conn, r *bufio.Reader, client bool) *wsConn {
+	if r == nil {
+		r = bufio.NewReader(conn)
+	}
+	return &wsConn{Conn: conn, r: r, client: client}
+}
+
+func (c *wsConn) Read(p []byte) (int, error) {
+	for len(c.readBuf) == 0 {
+		msg, err := c.readMessage()
+		if err != nil {
+			return 0, err
+		}
+		c.readBuf = binary.BigEndian.AppendUint32(make([]byte, 0, 4+len(msg)), uint32(len(msg)))
+		c.readBuf = append(c.readBuf, msg...)
+	}
+	n := copy(p, c.readBuf)
+	c.readBuf = c.readBuf[n:]
+	return n, nil
+}
+
+func (c *wsConn) Write(p []byte) (int, error) {
+	c.writeMutex.Lock()
+	defer c.writeMutex.Unlock()
+	c.writeBuf = append(c.writeBuf, p...)
+	for len(c.writeBuf) >= 4 {
+		n := int(binary.BigEndian.Uint32(c.writeBuf))
+		if len(c.writeBuf) < 4+n {
+			break
+		}
+		msg := c.writeBuf[4 : 4+n]
+		opcode := byte(wsBinary)
+		if utf8.Valid(msg) {
+			opcode = wsText
+		}
+		if err := c.writeFrame(opcode, msg); err != nil {
+			return 0, err
+		}
+		c.writeBuf = c.writeBuf[4+n:]
+	}
+	return len(p), nil
+}
+
+// Close sends a close frame before closing the connection
+func (c *wsConn) Close() error {
+	c.closeOnce.Do(func() {
+		c.writeMutex.Lock()
+		c.writeFrame(wsClose, nil)
+		c.writeMutex.Unlock()
+	})
+	return c.Conn.Close()
+}
+
+// readMessage returns the next data message, answering pings on the way
+func (c *wsConn) readMessage() ([]byte, error) {
+	var msg []byte
+	for {
+		fin, opcode, payload, err := c.readFrame()
+		if err != nil {
+			return nil, err
+		}
+		switch opcode {
+		case wsPing:
+			c.writeMutex.Lock()
+			err = c.writeFrame(wsPong, payload)
+			c.writeMutex.Unlock()
+			if err != nil {
+				return nil, err
+			}
+			continue
+		case wsPong:
+			continue
+		case wsClose:
+			return nil, io.EOF
+		case wsText, wsBinary, wsContinuation:
+		default:
+			return nil, fmt.Errorf("websocket: unknown opcode %d", opcode)
+		}
+		if len(msg)+len(payload) > wsMaxMessage {
+			return nil, errWSTooLarge
+		}
+		msg = append(msg, payload...)
+		if fin {
+			return msg, nil
+		}
+	}
+}
+
+// readFrame reads and unmasks one frame
+func (c *wsConn) readFrame() (fin bool, opcode byte, payload []byte, err error) {
+	var head [2]byte
+	if _, err = io.ReadFull(c.r, head[:]); err != nil {
+		return
+	}
+	fin, opcode = head[0]&0x80 != 0, head[0]&0x0f
+	masked := head[1]&0x80 != 0
+	length := uint64(head[1] & 0x7f)
+	switch length {
+	case 126:
+		var ext [2]byte
+		if _, err = io.ReadFull(c.r, ext[:]); err != nil {
+			return
+		}
+		length = uint64(binary.BigEndian.Uint16(ext[:]))
+	case 127:
+		var ext [8]byte
+		if _, err = io.ReadFull(c.r, ext[:]); err != nil {
+			return
+		}
+		length = binary.BigEndian.Uint64(ext[:])
+	}
+	if length > wsMaxMessage {
+		err = errWSTooLarge
+		return
+	}
+	if !c.client && !masked {
+		err = errors.New("websocket: unmasked client frame")
+		return
+	}
+	var mask [4]byte
+	if masked {
+		if _, err = io.ReadFull(c.r, mask[:]); err != nil {
+			return
+		}
+	}
+	payload = make([]byte, length)
+	if _, err = io.ReadFull(c.r, payload); err != nil {
+		return
+	}
+	if masked {
+		for i := range payload {
+			payload[i] ^= mask[i%4]
+		}
+	}
+	return
+}
+
+// writeFrame sends one unfragmented frame; callers hold writeMutex
+func (c *wsConn) writeFrame(opcode byte, payload []byte) error {
+	frame := make([]byte, 0, 14+len(payload))
+	frame = append(frame, 0x80|opcode)
+	maskBit := byte(0)
+	if c.client {
+		maskBit = 0x80
+	}
+	switch n := len(payload); {
+	case n < 126:
+		frame = append(frame, maskBit|byte(n))
+	case n <= 0xffff:
+		frame = binary.BigEndian.AppendUint16(append(frame, maskBit|126), uint16(n))
+	default:
+		frame = binary.BigEndian.AppendUint64(append(frame, maskBit|127), uint64(n))
+	}
+	if c.client {
+		var mask [4]byte
+		if _, err := rand.Read(mask[:]); err != nil {
+			return err
+		}
+		frame = append(frame, mask[:]...)
+		start := len(frame)
+		frame = append(frame, payload...)
+		for i := range frame[start:] {
+			frame[start+i] ^= mask[i%4]
+		}
+	} else {
+		frame = append(frame, payload...)
+	}
+	_, err := c.Conn.Write(frame)
+	return err
+}
@@ -0,0 +1,244 @@
+// check.go
+// Detailed spec validation with JSON paths, shared by LoadSpecs and tcr-specs
+
+package specs
+
+import (
+	"fmt"
+	"sort"
+)
+
+// Towers every player starts with, by key in the towers section
+var requiredTowers = map[string]string{
+	"king_tower":  "king",
+	"guard_tower": "guard",
+}
+
+// Problem is one finding of Check
+type Problem struct {
+	Path    string // JSON path into the spec file, e.g. troops.queen.health
+	Message string
+	Warning bool // playable but suspicious; LoadSpecs only rejects errors
+}
+
+func (p Problem) String() string {
+	level := "error"
+	if p.Warning {
+		level = "warning"
+	}
+	return fmt.Sprintf("%s: %s: %s", level, p.Path, p.Message)
+}
+
+// checker accumulates problems
+type checker struct {
+	problems []Problem
+}
+
+func (c *checker) errorf(path, format string, args ...interface{}) {
+	c.problems = append(c.problems, Problem{Path: path, Message: fmt.Sprintf(format, args...)})
+}
+
+func (c *checker) warnf(path, format string, args ...interface{}) {
+	c.problems = append(c.problems, Problem{Path: path, Message: fmt.Sprintf(format, args...), Warning: true})
+}
+
+// Check validates the specs and returns every problem found, in file order
+// for rules and sorted by key for troops and towers
+func Check(specs *Specs) []Problem {
+	c := &checker{}
+	c.checkTroops(specs)
+	c.checkTowers(specs)
+	c.checkRules(&specs.Rules)
+	c.checkCrossReferences(specs)
+	return c.problems
+}
+
+// HasErrors reports whether any problem is an error rather than a warning
+func HasErrors(problems []Problem) bool {
+	for _, p := range problems {
+		if !p.Warning {
+			return true
+		}
+	}
+	return false
+}
+
+func (c *checker) checkTroops(specs *Specs) {
+	if len(specs.Troops) == 0 {
+		c.errorf("troops", "no troops defined")
+		return
+	}
+
+	names := make(map[string]string) // display name -> key
+	for _, key := range sortedKeys(specs.Troops) {
+		troop := specs.Troops[key]
+		path := "troops." + key
+		if key == "" {
+			c.errorf(path, "troop key cannot be empty")
+		}
+		if troop.Name == "" {
+			c.errorf(path+".name", "troop name cannot be empty")
+		} else if other, ok := names[troop.Name]; ok {
+			c.errorf(path+".name", "name %q is already used by troops.%s", troop.Name, other)
+		} else {
+			names[troop.Name] = key
+		}
+
+		if troop.Health <= 0 {
+			c.errorf(path+".health", "must be positive, got %d", troop.Health)
+		}
+		if troop.Damage < 0 {
+			c.errorf(path+".damage", "must not be negative, got %d", troop.Damage)
+		}
+		if troop.Defence < 0 {
+			c.errorf(path+".defence", "must not be negative, got %d", troop.Defence)
+		}
+		if troop.Cost < 1 || troop.Cost > specs.Rules.ManaCap {
+			c.errorf(path+".cost", "must be between 1 and the mana cap %d, got %d",
+				specs.Rules.ManaCap, troop.Cost)
+		}
+		c.checkAbility(path, troop)
+	}
+}
+
+func (c *checker) checkAbility(path string, troop TroopSpec) {
+	a := troop.Ability
+	if a == nil {
+		if troop.Damage == 0 {
+			c.errorf(path+".damage", "troop has no damage and no ability, so it does nothing")
+		}
+		return
+	}
+	path += ".ability"
+	switch a.Type {
+	case AbilityHeal:
+		if a.Amount <= 0 {
+			c.errorf(path+".amount", "heal amount must be positive, got %d", a.Amount)
+		}
+		if troop.Damage > 0 {
+			c.warnf(path, "healers do not attack, damage %d is unused", troop.Damage)
+		}
+	default:
+		c.errorf(path+".type", "unknown ability %q", a.Type)
+	}
+}
+
+func (c *checker) checkTowers(specs *Specs) {
+	for _, key := range sortedKeys(requiredTowers) {
+		wantType := requiredTowers[key]
+		tower, ok := specs.Towers[key]
+		if !ok {
+			c.errorf("towers."+key, "required %s tower is missing", wantType)
+		} else if tower.Type != wantType {
+			c.errorf("towers."+key+".type", "must be %q, got %q", wantType, tower.Type)
+		}
+	}
+
+	for _, key := range sortedKeys(specs.Towers) {
+		tower := specs.Towers[key]
+		path := "towers." + key
+		if _, ok := requiredTowers[key]; !ok {
+			c.warnf(path, "unused: players only get towers.king_tower and towers.guard_tower")
+		}
+		if tower.Name == "" {
+			c.errorf(path+".name", "tower name cannot be empty")
+		}
+		if tower.Type != "king" && tower.Type != "guard" {
+			c.errorf(path+".type", "must be \"king\" or \"guard\", got %q", tower.Type)
+		}
+		if tower.Health <= 0 {
+			c.errorf(path+".health", "must be positive, got %d", tower.Health)
+		}
+		if tower.Damage < 0 {
+			c.errorf(path+".damage", "must not be negative, got %d", tower.Damage)
+		}
+		if tower.Defence < 0 {
+			c.errorf(path+".defence", "must not be negative, got %d", tower.Defence)
+		}
+	}
+}
+
+func (c *checker) checkRules(rules *Rules) {
+	if rules.StartingMana < 0 {
+		c.errorf("rules.starting_mana", "must not be negative, got %d", rules.StartingMana)
+	}
+	if rules.ManaCap <= 0 {
+		c.errorf("rules.mana_cap", "must be positive, got %d", rules.ManaCap)
+	}
+	if rules.StartingMana > rules.ManaCap {
+		c.errorf("rules.starting_mana", "%d exceeds mana cap %d", rules.StartingMana, rules.ManaCap)
+	}
+	if rules.ManaRegen < 0 {
+		c.errorf("rules.mana_regen", "must not be negative, got %d", rules.ManaRegen)
+	} else if rules.ManaRegen == 0 {
+		c.warnf("rules.mana_regen", "0: players only ever have their starting mana")
+	}
+	if rules.MatchDurationSec <= 0 {
+		c.errorf("rules.match_duration_sec", "must be positive, got %d", rules.MatchDurationSec)
+	}
+	if rules.OvertimeSec < 0 {
+		c.errorf("rules.overtime_sec", "must not be negative, got %d", rules.OvertimeSec)
+	}
+	if rules.TurnActions <= 0 {
+		c.errorf("rules.turn_actions", "must be positive, got %d", rules.TurnActions)
+	}
+	if rules.TurnSec <= 0 {
+		c.errorf("rules.turn_sec", "must be positive, got %d", rules.TurnSec)
+	}
+
+	prev := -1
+	for i, phase := range rules.Phases {
+		path := fmt.Sprintf("rules.phases[%d]", i)
+		if phase.StartSec <= prev {
+			c.errorf(path+".start_sec", "phase %s must start after the previous phase", phase.Name)
+		}
+		if phase.ManaMultiplier <= 0 {
+			c.errorf(path+".mana_multiplier", "must be positive, got %d", phase.ManaMultiplier)
+		}
+		if phase.StartSec > rules.MatchDurationSec+rules.OvertimeSec {
+			c.warnf(path+".start_sec", "phase %s starts after the match can end", phase.Name)
+		}
+		prev = phase.StartSec
+	}
+}
+
+// checkCrossReferences looks for specs that are valid one by one but cannot
+// produce a sensible match together
+func (c *checker) checkCrossReferences(specs *Specs) {
+	for _, key := range sortedKeys(requiredTowers) {
+		tower, ok := specs.Towers[key]
+		if !ok {
+			continue
+		}
+		best := 0
+		for _, troop := range specs.Troops {
+			if !troop.Heals() {
+				best = max(best, troop.Damage)
+			}
+		}
+		if best > 0 && best <= tower.Defence {
+			c.errorf("towers."+key+".defence",
+				"%d blocks every troop at level 1 (strongest hits for %d)", tower.Defence, best)
+		}
+	}
+
+	cheapest := 0
+	for _, troop := range specs.Troops {
+		if cheapest == 0 || troop.Cost < cheapest {
+			cheapest = troop.Cost
+		}
+	}
+	if cheapest > 0 && cheapest > specs.Rules.StartingMana && specs.Rules.ManaRegen == 0 {
+		c.errorf("rules.starting_mana", "no troop is affordable: cheapest costs %d", cheapest)
+	}
+}
+
+// sortedKeys returns a map's keys in order, for stable output
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
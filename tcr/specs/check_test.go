@@ -0,0 +1,127 @@
+// check_test.go
+// Check findings and their order for small hand-written specs
+
+package specs
+
+import (
+	"reflect"
+	"testing"
+)
+
+// validSpecs has no problems; each case breaks one part of it
+func validSpecs() *Specs {
+	return &Specs{
+		Troops: map[string]TroopSpec{
+			"knight": {Name: "Knight", Health: 1000, Damage: 200, Defence: 50, Cost: 4},
+			"pawn":   {Name: "Pawn", Health: 300, Damage: 150, Cost: 2},
+		},
+		Towers: map[string]TowerSpec{
+			"guard_tower": {Name: "Guard Tower", Type: "guard", Health: 1000, Damage: 100, Defence: 50},
+			"king_tower":  {Name: "King Tower", Type: "king", Health: 2000, Damage: 150, Defence: 100},
+		},
+		Rules: DefaultRules(),
+	}
+}
+
+func TestCheck(t *testing.T) {
+	tests := []struct {
+		name  string
+		edit  func(s *Specs)
+		paths []string // of the problems, in order
+		warns []bool
+	}{
+		{
+			name: "valid",
+			edit: func(s *Specs) {},
+		},
+		{
+			name:  "required towers missing",
+			edit:  func(s *Specs) { s.Towers = map[string]TowerSpec{} },
+			paths: []string{"towers.guard_tower", "towers.king_tower"},
+			warns: []bool{false, false},
+		},
+		{
+			name: "tower types swapped",
+			edit: func(s *Specs) {
+				guard, king := s.Towers["guard_tower"], s.Towers["king_tower"]
+				guard.Type, king.Type = "king", "guard"
+				s.Towers["guard_tower"], s.Towers["king_tower"] = guard, king
+			},
+			paths: []string{"towers.guard_tower.type", "towers.king_tower.type"},
+			warns: []bool{false, false},
+		},
+		{
+			name: "defence blocks every troop",
+			edit: func(s *Specs) {
+				for key, tower := range s.Towers {
+					tower.Defence = 500
+					s.Towers[key] = tower
+				}
+			},
+			paths: []string{"towers.guard_tower.defence", "towers.king_tower.defence"},
+			warns: []bool{false, false},
+		},
+		{
+			name: "extra tower and duplicate troop name",
+			edit: func(s *Specs) {
+				s.Towers["archer_tower"] = TowerSpec{Name: "Archer Tower", Type: "guard", Health: 500}
+				pawn := s.Troops["pawn"]
+				pawn.Name = "Knight"
+				s.Troops["pawn"] = pawn
+			},
+			paths: []string{"troops.pawn.name", "towers.archer_tower"},
+			warns: []bool{false, true},
+		},
+		{
+			name: "healer without health",
+			edit: func(s *Specs) {
+				s.Troops["queen"] = TroopSpec{Name: "Queen", Damage: 10, Cost: 3,
+					Ability: &Ability{Type: AbilityHeal, Amount: 100}}
+			},
+			paths: []string{"troops.queen.health", "troops.queen.ability"},
+			warns: []bool{false, true},
+		},
+		{
+			name: "nothing affordable",
+			edit: func(s *Specs) {
+				s.Rules.StartingMana, s.Rules.ManaRegen = 1, 0
+			},
+			paths: []string{"rules.mana_regen", "rules.starting_mana"},
+			warns: []bool{true, false},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := validSpecs()
+			tt.edit(s)
+			// Map order differs between runs; repeat to catch unsorted output
+			first := Check(s)
+			for i := 0; i < 20; i++ {
+				if again := Check(s); !reflect.DeepEqual(again, first) {
+					t.Fatalf("Check gave %v, then %v", first, again)
+				}
+			}
+			var paths []string
+			var warns []bool
+			for _, p := range first {
+				paths = append(paths, p.Path)
+				warns = append(warns, p.Warning)
+			}
+			if !reflect.DeepEqual(paths, tt.paths) || !reflect.DeepEqual(warns, tt.warns) {
+				t.Errorf("Check = %v, want paths %v with warnings %v", first, tt.paths, tt.warns)
+			}
+			if HasErrors(first) != contains(tt.warns, false) {
+				t.Errorf("HasErrors = %v for %v", HasErrors(first), first)
+			}
+		})
+	}
+}
+
+func contains(list []bool, v bool) bool {
+	for _, b := range list {
+		if b == v {
+			return true
+		}
+	}
+	return false
+}
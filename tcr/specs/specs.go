@@ -1,6 +1,8 @@
 package specs
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
 	"fmt"
 	"os"
@@ -8,11 +10,29 @@ import (
 
 // TroopSpec represents the specification for a troop
 type TroopSpec struct {
-	Name    string `json:"name"`
-	Health  int    `json:"health"`
-	Damage  int    `json:"damage"`
-	Defence int    `json:"defence"`
-	Cost    int    `json:"cost"`
+	Name        string   `json:"name"`
+	Health      int      `json:"health"`
+	Damage      int      `json:"damage"`
+	Defence     int      `json:"defence"`
+	Cost        int      `json:"cost"`
+	Ability     *Ability `json:"ability,omitempty"`     // replaces the troop's attack
+	Description string   `json:"description,omitempty"` // shown on the client's card
+}
+
+// Ability types
+const (
+	AbilityHeal = "heal" // restores the owner's weakest tower
+)
+
+// Ability is a troop's special action, taken instead of attacking
+type Ability struct {
+	Type   string `json:"type"`
+	Amount int    `json:"amount"` // health restored per heal at level 1
+}
+
+// Heals reports whether the troop heals instead of attacking
+func (t TroopSpec) Heals() bool {
+	return t.Ability != nil && t.Ability.Type == AbilityHeal
 }
 
 // TowerSpec represents the specification for a tower
@@ -24,10 +44,59 @@ type TowerSpec struct {
 	Defence int    `json:"defence"`
 }
 
+// ManaPhase scales mana regeneration from a point in the match onwards
+type ManaPhase struct {
+	Name           string `json:"name"`
+	StartSec       int    `json:"start_sec"`
+	ManaMultiplier int    `json:"mana_multiplier"`
+}
+
+// Rules represents the mana economy and match timing
+type Rules struct {
+	StartingMana     int         `json:"starting_mana"`
+	ManaCap          int         `json:"mana_cap"`
+	ManaRegen        int         `json:"mana_regen"` // mana gained per tick
+	MatchDurationSec int         `json:"match_duration_sec"`
+	OvertimeSec      int         `json:"overtime_sec"` // sudden death when towers are tied
+	Phases           []ManaPhase `json:"phases"`
+	TurnActions      int         `json:"turn_actions"` // deploys per turn in turn-based mode
+	TurnSec          int         `json:"turn_sec"`     // turn length in turn-based mode
+}
+
 // Specs holds all game specifications
 type Specs struct {
 	Troops map[string]TroopSpec `json:"troops"`
 	Towers map[string]TowerSpec `json:"towers"`
+	Rules  Rules                `json:"rules"`
+}
+
+// DefaultRules returns the rules used when a spec file has no rules section
+func DefaultRules() Rules {
+	return Rules{
+		StartingMana:     5,
+		ManaCap:          10,
+		ManaRegen:        1,
+		MatchDurationSec: 180,
+		OvertimeSec:      60,
+		Phases: []ManaPhase{
+			{Name: "double_mana", StartSec: 120, ManaMultiplier: 2},
+			{Name: "overtime", StartSec: 180, ManaMultiplier: 3},
+		},
+		TurnActions: 1,
+		TurnSec:     10,
+	}
+}
+
+// Phase returns the mana phase active after elapsedSec seconds, or nil
+// before the first phase starts
+func (r Rules) Phase(elapsedSec int) *ManaPhase {
+	var active *ManaPhase
+	for i := range r.Phases {
+		if elapsedSec >= r.Phases[i].StartSec {
+			active = &r.Phases[i]
+		}
+	}
+	return active
 }
 
 // LoadSpecs reads and parses the specifications file
@@ -37,7 +106,7 @@ func LoadSpecs(filename string) (*Specs, error) {
 		return nil, err
 	}
 
-	var specs Specs
+	specs := Specs{Rules: DefaultRules()}
 	if err := json.Unmarshal(data, &specs); err != nil {
 		return nil, err
 	}
@@ -50,53 +119,25 @@ func LoadSpecs(filename string) (*Specs, error) {
 	return &specs, nil
 }
 
-// validateSpecs checks if the specifications are valid
-func validateSpecs(specs *Specs) error {
-	// Validate troops
-	if len(specs.Troops) == 0 {
-		return fmt.Errorf("no troops defined")
-	}
-
-	for name, troop := range specs.Troops {
-		if name == "" {
-			return fmt.Errorf("troop name cannot be empty")
-		}
-		if troop.Health < 0 {
-			return fmt.Errorf("invalid health for troop %s: %d", name, troop.Health)
-		}
-		if troop.Damage < 0 {
-			return fmt.Errorf("invalid damage for troop %s: %d", name, troop.Damage)
-		}
-		if troop.Defence < 0 {
-			return fmt.Errorf("invalid defence for troop %s: %d", name, troop.Defence)
-		}
-		if troop.Cost < 0 {
-			return fmt.Errorf("invalid cost for troop %s: %d", name, troop.Cost)
-		}
-	}
-
-	// Validate towers
-	if len(specs.Towers) == 0 {
-		return fmt.Errorf("no towers defined")
+// Hash returns a short fingerprint of the specifications, used to tell which
+// balance version a match was played with
+func Hash(specs *Specs) string {
+	// encoding/json sorts map keys, so equal specs always hash the same
+	data, err := json.Marshal(specs)
+	if err != nil {
+		return ""
 	}
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:8])
+}
 
-	for name, tower := range specs.Towers {
-		if name == "" {
-			return fmt.Errorf("tower name cannot be empty")
-		}
-		if tower.Health <= 0 {
-			return fmt.Errorf("invalid health for tower %s: %d", name, tower.Health)
-		}
-		if tower.Damage < 0 {
-			return fmt.Errorf("invalid damage for tower %s: %d", name, tower.Damage)
-		}
-		if tower.Defence < 0 {
-			return fmt.Errorf("invalid damage for tower %s: %d", name, tower.Defence)
-		}
-		if tower.Type != "king" && tower.Type != "guard" {
-			return fmt.Errorf("invalid tower type for %s: %s", name, tower.Type)
+// validateSpecs checks if the specifications are valid, failing on the first
+// error Check finds
+func validateSpecs(specs *Specs) error {
+	for _, p := range Check(specs) {
+		if !p.Warning {
+			return fmt.Errorf("%s: %s", p.Path, p.Message)
 		}
 	}
-
 	return nil
 }